@@ -0,0 +1,86 @@
+package analytics
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Event is a single resolution of a link
+// Only a coarse client network is kept, never the full client address
+type Event struct {
+	LinkPath  string
+	Timestamp int64
+	Referer   string
+	UserAgent string
+	ClientNet string
+}
+
+const (
+	// maxFieldLength bounds the length of client supplied strings stored per event
+	maxFieldLength = 255
+
+	hourSeconds = int64(time.Hour / time.Second)
+	daySeconds  = 24 * hourSeconds
+)
+
+// NewEvent builds a click event for the link from the incoming request
+func NewEvent(linkpath string, r *http.Request) *Event {
+	return &Event{
+		LinkPath:  linkpath,
+		Timestamp: time.Now().Unix(),
+		Referer:   sanitize(r.Referer()),
+		UserAgent: sanitize(r.UserAgent()),
+		ClientNet: coarseNetwork(r.RemoteAddr),
+	}
+}
+
+// coarseNetwork reduces a client address to its /24 (IPv4) or /48 (IPv6) network
+func coarseNetwork(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// sanitize makes a client supplied header safe to store in any of the stores
+// Invalid UTF-8 and control characters such as NUL are rejected by PostgreSQL and would fail the whole batch,
+// so they are replaced or dropped, and the result is truncated to maxFieldLength bytes on a rune boundary
+func sanitize(s string) string {
+	s = strings.ToValidUTF8(s, string(utf8.RuneError))
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) <= maxFieldLength {
+		return s
+	}
+	end := maxFieldLength
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end]
+}
+
+// hourStart returns the start of the UTC hour containing the timestamp
+func hourStart(ts int64) int64 {
+	return ts - ts%hourSeconds
+}
+
+// dayStart returns the start of the UTC day containing the timestamp
+func dayStart(ts int64) int64 {
+	return ts - ts%daySeconds
+}
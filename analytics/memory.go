@@ -0,0 +1,165 @@
+package analytics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Retention windows for the in-memory counters, older buckets are pruned on write
+	memoryHourlyRetention = 31 * daySeconds
+	memoryDailyRetention  = 400 * daySeconds
+)
+
+// MemoryStore keeps click counters in process memory
+// Implements the analytics.Store interface. Raw events are not kept, referers are counted per day
+type MemoryStore struct {
+	mu    sync.Mutex
+	links map[string]*linkCounters
+}
+
+type linkCounters struct {
+	hourly   map[int64]int64
+	daily    map[int64]int64
+	referers map[int64]map[string]int64
+}
+
+// NewMemoryStore creates a new, empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		links: make(map[string]*linkCounters),
+	}
+}
+
+// Init is a no-op, there is nothing to create
+func (ms *MemoryStore) Init() error {
+	return nil
+}
+
+// RecordBatch increments the counters for each event
+func (ms *MemoryStore) RecordBatch(events []*Event) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	touched := map[*linkCounters]bool{}
+	for _, e := range events {
+		lc, ok := ms.links[e.LinkPath]
+		if !ok {
+			lc = &linkCounters{
+				hourly:   map[int64]int64{},
+				daily:    map[int64]int64{},
+				referers: map[int64]map[string]int64{},
+			}
+			ms.links[e.LinkPath] = lc
+		}
+		lc.hourly[hourStart(e.Timestamp)]++
+		day := dayStart(e.Timestamp)
+		lc.daily[day]++
+		if e.Referer != "" {
+			if lc.referers[day] == nil {
+				lc.referers[day] = map[string]int64{}
+			}
+			lc.referers[day][e.Referer]++
+		}
+		touched[lc] = true
+	}
+
+	now := time.Now().Unix()
+	for lc := range touched {
+		lc.prune(now)
+	}
+	return nil
+}
+
+// Prune is a no-op, raw events are never kept and the counters have their own retention windows
+func (ms *MemoryStore) Prune(before int64) error {
+	return nil
+}
+
+// GetStats returns the counters for the link within the range
+// Totals are computed from hourly counters while they are retained, and daily counters beyond that
+func (ms *MemoryStore) GetStats(linkpath string, from int64, to int64) (*Stats, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	st := &Stats{
+		LinkPath:    linkpath,
+		From:        from,
+		To:          to,
+		Hourly:      []Bucket{},
+		Daily:       []Bucket{},
+		TopReferers: []RefererCount{},
+	}
+	lc, ok := ms.links[linkpath]
+	if !ok {
+		return st, nil
+	}
+
+	st.Hourly = bucketsInRange(lc.hourly, hourStart(from), to)
+	st.Daily = bucketsInRange(lc.daily, dayStart(from), to)
+	if from >= time.Now().Unix()-memoryHourlyRetention {
+		st.Total = sumBuckets(st.Hourly)
+	} else {
+		st.Total = sumBuckets(st.Daily)
+	}
+
+	refs := map[string]int64{}
+	for day, counts := range lc.referers {
+		if day >= dayStart(from) && day < to {
+			for ref, n := range counts {
+				refs[ref] += n
+			}
+		}
+	}
+	for ref, n := range refs {
+		st.TopReferers = append(st.TopReferers, RefererCount{Referer: ref, Count: n})
+	}
+	sort.Slice(st.TopReferers, func(i, j int) bool {
+		if st.TopReferers[i].Count != st.TopReferers[j].Count {
+			return st.TopReferers[i].Count > st.TopReferers[j].Count
+		}
+		return st.TopReferers[i].Referer < st.TopReferers[j].Referer
+	})
+	if len(st.TopReferers) > topReferers {
+		st.TopReferers = st.TopReferers[:topReferers]
+	}
+	return st, nil
+}
+
+// prune drops buckets that have fallen out of the retention windows
+func (lc *linkCounters) prune(now int64) {
+	for start := range lc.hourly {
+		if start < now-memoryHourlyRetention {
+			delete(lc.hourly, start)
+		}
+	}
+	for start := range lc.daily {
+		if start < now-memoryDailyRetention {
+			delete(lc.daily, start)
+			delete(lc.referers, start)
+		}
+	}
+}
+
+// bucketsInRange returns the buckets starting within [from, to), ordered by start time
+func bucketsInRange(counts map[int64]int64, from int64, to int64) []Bucket {
+	buckets := []Bucket{}
+	for start, n := range counts {
+		if start >= from && start < to {
+			buckets = append(buckets, Bucket{Start: start, Count: n})
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start < buckets[j].Start
+	})
+	return buckets
+}
+
+func sumBuckets(buckets []Bucket) int64 {
+	var total int64
+	for _, b := range buckets {
+		total += b.Count
+	}
+	return total
+}
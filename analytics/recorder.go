@@ -0,0 +1,124 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ErrDrainTimeout is returned when queued events could not be flushed before the deadline
+var ErrDrainTimeout = errors.New("DrainTimeout")
+
+// Recorder queues click events and writes them to the store in batches from a single worker
+// Batches are flushed when full or when the flush interval elapses, whichever comes first
+type Recorder struct {
+	events        chan *Event
+	store         Store
+	logger        *zerolog.Logger
+	batchSize     int
+	flushInterval time.Duration
+	dropped       uint64
+	quit          chan struct{}
+	done          chan struct{}
+	stopOnce      sync.Once
+}
+
+// NewRecorder creates a recorder with the specified queue size, batch size and flush interval
+func NewRecorder(store Store, queueSize int, batchSize int, flushInterval time.Duration, logger *zerolog.Logger) *Recorder {
+	return &Recorder{
+		events:        make(chan *Event, queueSize),
+		store:         store,
+		logger:        logger,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// Record queues an event without waiting, so redirects are never slowed down by analytics
+// Returns false if the event was dropped because the queue is full or the recorder is stopping
+func (rc *Recorder) Record(e *Event) bool {
+	select {
+	case <-rc.quit:
+		return false
+	default:
+	}
+	select {
+	case rc.events <- e:
+		return true
+	default:
+		atomic.AddUint64(&rc.dropped, 1)
+		return false
+	}
+}
+
+// RunWorker runs the batching worker thread until Drain is called
+func (rc *Recorder) RunWorker() {
+	defer close(rc.done)
+
+	ticker := time.NewTicker(rc.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Event, 0, rc.batchSize)
+	for {
+		select {
+		case e := <-rc.events:
+			batch = append(batch, e)
+			if len(batch) >= rc.batchSize {
+				batch = rc.flush(batch)
+			}
+		case <-ticker.C:
+			batch = rc.flush(batch)
+		case <-rc.quit:
+			// Flush whatever is still queued before exiting
+			for {
+				select {
+				case e := <-rc.events:
+					batch = append(batch, e)
+					if len(batch) >= rc.batchSize {
+						batch = rc.flush(batch)
+					}
+				default:
+					rc.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Drain stops the recorder accepting new events and waits for the worker to flush the queued ones
+// Returns an ErrDrainTimeout error if the context expires first
+func (rc *Recorder) Drain(ctx context.Context) error {
+	rc.stopOnce.Do(func() {
+		close(rc.quit)
+	})
+	select {
+	case <-rc.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %d events were not recorded", ErrDrainTimeout, len(rc.events))
+	}
+}
+
+// flush writes the batch to the store and returns an empty batch to reuse
+// Failed batches are logged and dropped, analytics are best effort
+func (rc *Recorder) flush(batch []*Event) []*Event {
+	if dropped := atomic.SwapUint64(&rc.dropped, 0); dropped > 0 {
+		rc.logger.Warn().Str("Segment", "ClickRecorder").Msg(strconv.FormatUint(dropped, 10) + " click events dropped, queue was full")
+	}
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := rc.store.RecordBatch(batch); err != nil {
+		rc.logger.Error().Str("Segment", "ClickRecorder").Msg("Couldn't record " + strconv.Itoa(len(batch)) + " click events: " + err.Error())
+	}
+	return batch[:0]
+}
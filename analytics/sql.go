@@ -0,0 +1,180 @@
+package analytics
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/regalias/atlas-api/util"
+
+	// Register the drivers, the database package may not be in use
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// SQLStore keeps raw click events and aggregated counters in a PostgreSQL or SQLite database
+// Implements the analytics.Store interface
+type SQLStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+// NewSQLStore opens a database/sql connection pool for the given dialect ("postgres" or "sqlite")
+func NewSQLStore(dialect string, dsn string) (*SQLStore, error) {
+	if dialect != "postgres" && dialect != "sqlite" {
+		return nil, errors.New("unsupported SQL dialect " + dialect)
+	}
+	db, err := sql.Open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == "sqlite" {
+		// SQLite only allows a single writer, and every connection to :memory: is a separate database
+		db.SetMaxOpenConns(1)
+	}
+	return &SQLStore{
+		db:      db,
+		dialect: dialect,
+	}, nil
+}
+
+// Init creates the event and counter tables if they do not exist
+// The statements work unchanged on both PostgreSQL and SQLite
+func (ss *SQLStore) Init() error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS click_events (
+			link_path  VARCHAR(50) NOT NULL,
+			ts         BIGINT NOT NULL,
+			referer    VARCHAR(255) NOT NULL,
+			user_agent VARCHAR(255) NOT NULL,
+			client_net VARCHAR(64) NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS click_events_link_ts ON click_events (link_path, ts)`,
+		`CREATE INDEX IF NOT EXISTS click_events_ts ON click_events (ts)`,
+		`CREATE TABLE IF NOT EXISTS click_counts (
+			link_path    VARCHAR(50) NOT NULL,
+			granularity  CHAR(1) NOT NULL,
+			bucket_start BIGINT NOT NULL,
+			clicks       BIGINT NOT NULL,
+			PRIMARY KEY (link_path, granularity, bucket_start)
+		)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := ss.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// counterKey identifies a single hourly ("h") or daily ("d") counter row
+type counterKey struct {
+	linkPath    string
+	granularity string
+	start       int64
+}
+
+// RecordBatch inserts the raw events and increments the counters in a single transaction
+func (ss *SQLStore) RecordBatch(events []*Event) error {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare(ss.rebind("INSERT INTO click_events (link_path, ts, referer, user_agent, client_net) VALUES (?, ?, ?, ?, ?)"))
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	// Pre-aggregate so each counter row is only written once per batch
+	counts := map[counterKey]int64{}
+	for _, e := range events {
+		if _, err := insert.Exec(e.LinkPath, e.Timestamp, e.Referer, e.UserAgent, e.ClientNet); err != nil {
+			return err
+		}
+		counts[counterKey{e.LinkPath, "h", hourStart(e.Timestamp)}]++
+		counts[counterKey{e.LinkPath, "d", dayStart(e.Timestamp)}]++
+	}
+
+	for k, n := range counts {
+		if _, err := tx.Exec(ss.rebind(`INSERT INTO click_counts (link_path, granularity, bucket_start, clicks) VALUES (?, ?, ?, ?)
+			ON CONFLICT (link_path, granularity, bucket_start) DO UPDATE SET clicks = click_counts.clicks + excluded.clicks`),
+			k.linkPath, k.granularity, k.start, n); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetStats returns the counters for the link within the range
+// Totals and buckets come from the counter tables, referers from the raw events still retained
+func (ss *SQLStore) GetStats(linkpath string, from int64, to int64) (*Stats, error) {
+	st := &Stats{
+		LinkPath:    linkpath,
+		From:        from,
+		To:          to,
+		TopReferers: []RefererCount{},
+	}
+
+	var err error
+	if st.Hourly, err = ss.buckets(linkpath, "h", hourStart(from), to); err != nil {
+		return nil, err
+	}
+	if st.Daily, err = ss.buckets(linkpath, "d", dayStart(from), to); err != nil {
+		return nil, err
+	}
+
+	st.Total = sumBuckets(st.Hourly)
+
+	rows, err := ss.db.Query(ss.rebind(`SELECT referer, COUNT(*) AS clicks FROM click_events
+		WHERE link_path = ? AND ts >= ? AND ts < ? AND referer <> ''
+		GROUP BY referer ORDER BY clicks DESC, referer LIMIT ?`), linkpath, from, to, topReferers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rc RefererCount
+		if err := rows.Scan(&rc.Referer, &rc.Count); err != nil {
+			return nil, err
+		}
+		st.TopReferers = append(st.TopReferers, rc)
+	}
+	return st, rows.Err()
+}
+
+// Prune deletes the raw events recorded before the given unix time, the counters are kept
+func (ss *SQLStore) Prune(before int64) error {
+	_, err := ss.db.Exec(ss.rebind("DELETE FROM click_events WHERE ts < ?"), before)
+	return err
+}
+
+func (ss *SQLStore) buckets(linkpath string, granularity string, from int64, to int64) ([]Bucket, error) {
+	rows, err := ss.db.Query(ss.rebind(`SELECT bucket_start, clicks FROM click_counts
+		WHERE link_path = ? AND granularity = ? AND bucket_start >= ? AND bucket_start < ?
+		ORDER BY bucket_start`), linkpath, granularity, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []Bucket{}
+	for rows.Next() {
+		var b Bucket
+		if err := rows.Scan(&b.Start, &b.Count); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// rebind rewrites ? placeholders for the dialect
+// Queries in this package never contain a literal ?
+func (ss *SQLStore) rebind(query string) string {
+	if ss.dialect != "postgres" {
+		return query
+	}
+	return util.RebindPostgres(query)
+}
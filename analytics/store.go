@@ -0,0 +1,45 @@
+package analytics
+
+// Store is the generic interface for persisting click events and reading aggregated counters
+type Store interface {
+
+	// Init creates any required schema
+	Init() error
+
+	// RecordBatch persists a batch of click events and increments the hourly and daily counters
+	RecordBatch(events []*Event) error
+
+	// GetStats returns the counters for the link between from (inclusive) and to (exclusive), as unix seconds
+	// Links without any clicks return zeroed stats rather than an error
+	GetStats(linkpath string, from int64, to int64) (*Stats, error)
+
+	// Prune deletes raw events recorded before the given unix time
+	// The hourly and daily counters are not affected
+	Prune(before int64) error
+}
+
+// Stats contains the aggregated click counters for a single link
+type Stats struct {
+	LinkPath    string         `json:"LinkPath"`
+	From        int64          `json:"From"`
+	To          int64          `json:"To"`
+	Total       int64          `json:"Total"`
+	Hourly      []Bucket       `json:"Hourly"`
+	Daily       []Bucket       `json:"Daily"`
+	TopReferers []RefererCount `json:"TopReferers"`
+}
+
+// Bucket is the number of clicks in the hour or day starting at Start
+type Bucket struct {
+	Start int64 `json:"Start"`
+	Count int64 `json:"Count"`
+}
+
+// RefererCount is the number of clicks from a single referer
+type RefererCount struct {
+	Referer string `json:"Referer"`
+	Count   int64  `json:"Count"`
+}
+
+// topReferers is the number of referers returned in Stats
+const topReferers = 10
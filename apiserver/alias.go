@@ -0,0 +1,182 @@
+package apiserver
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/regalias/atlas-api/cache"
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+)
+
+// handleListAliases lists the aliases of a link
+// Aliases outlive their link, so the aliases of a deleted link are still listed
+func (s *server) handleListAliases() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		linkPath := httprouter.ParamsFromContext(r.Context()).ByName("linkpath")
+
+		aliases, err := s.dataProvider.ListAliases(linkPath)
+		if err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+		util.SendGenericResponse(w, r, "None", map[string]interface{}{"Aliases": aliases}, http.StatusOK)
+	}
+}
+
+// handleCreateAlias adds an alias to a link and caches it with the link's current destination
+func (s *server) handleCreateAlias() http.HandlerFunc {
+	type request struct {
+		AliasPath string `json:"AliasPath" validate:"required,min=3,max=50,is-uri-path,not-reserved"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		linkPath := httprouter.ParamsFromContext(r.Context()).ByName("linkpath")
+
+		var req request
+		if err := s.getRequest(w, r, &req); err != nil {
+			return
+		}
+
+		alias := &models.Alias{
+			AliasPath:   req.AliasPath,
+			LinkPath:    linkPath,
+			CreatedTime: time.Now().Unix(),
+			CreatedBy:   actor(r),
+		}
+		if err := s.dataProvider.CreateAlias(alias, s.trash.ReusePaths); err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+
+		link, err := s.dataProvider.GetLinkDetails(linkPath)
+		if err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+		if err := s.cacheTaskHandler.SubmitTask(s.aliasCacheTask(link, alias.AliasPath)); err != nil {
+			s.logger.Error().Msg("Couldn't submit cache set task: " + err.Error())
+			util.ThrowISE(w, r)
+			return
+		}
+
+		util.SendGenericResponse(w, r, "None", alias, http.StatusCreated)
+	}
+}
+
+// handleGetAlias fetches a single alias
+func (s *server) handleGetAlias() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		aliasPath := httprouter.ParamsFromContext(r.Context()).ByName("aliaspath")
+
+		alias, err := s.dataProvider.GetAlias(aliasPath)
+		if err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+		util.SendGenericResponse(w, r, "None", alias, http.StatusOK)
+	}
+}
+
+// handleDeleteAlias removes an alias and its cache entry
+func (s *server) handleDeleteAlias() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		aliasPath := httprouter.ParamsFromContext(r.Context()).ByName("aliaspath")
+
+		if err := s.dataProvider.DeleteAlias(aliasPath); err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+		if err := s.cacheTaskHandler.SubmitTask(&cache.Task{
+			Operation: cache.RemoveLink,
+			Linkpath:  aliasPath,
+		}); err != nil {
+			s.logger.Error().Msg("Couldn't submit cache deletion task: " + err.Error())
+			util.ThrowISE(w, r)
+			return
+		}
+		util.SendGenericResponse(w, r, "None", http.StatusText(http.StatusOK), http.StatusOK)
+	}
+}
+
+// cacheLink submits the cache update for a link, and the same update for each of its aliases
+func (s *server) cacheLink(link *models.LinkModel) error {
+	if err := s.cacheTaskHandler.SubmitTask(s.cacheTaskFor(link)); err != nil {
+		return err
+	}
+	aliases, err := s.dataProvider.ListAliases(link.LinkPath)
+	if err != nil {
+		return err
+	}
+	for _, alias := range aliases {
+		if err := s.cacheTaskHandler.SubmitTask(s.aliasCacheTask(link, alias.AliasPath)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uncacheLink removes a link and each of its aliases from the cache
+// The aliases are kept in the database, and are cached again if the link comes back
+func (s *server) uncacheLink(linkpath string) error {
+	if err := s.cacheTaskHandler.SubmitTask(&cache.Task{
+		Operation: cache.RemoveLink,
+		Linkpath:  linkpath,
+	}); err != nil {
+		return err
+	}
+	aliases, err := s.dataProvider.ListAliases(linkpath)
+	if err != nil {
+		return err
+	}
+	for _, alias := range aliases {
+		if err := s.cacheTaskHandler.SubmitTask(&cache.Task{
+			Operation: cache.RemoveLink,
+			Linkpath:  alias.AliasPath,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolvePath looks up the link served at a path, following an alias to its link
+// Returns database.ErrNotFound if neither a link nor an alias with a live link exists
+func (s *server) resolvePath(linkpath string) (*models.LinkModel, error) {
+	m, err := s.dataProvider.GetLinkDetails(linkpath)
+	if err == nil || !errors.Is(err, database.ErrNotFound) {
+		return m, err
+	}
+	alias, aerr := s.dataProvider.GetAlias(linkpath)
+	if aerr != nil {
+		if errors.Is(aerr, database.ErrNotFound) {
+			return nil, err
+		}
+		return nil, aerr
+	}
+	return s.dataProvider.GetLinkDetails(alias.LinkPath)
+}
+
+// aliasesByLink lists every alias at once and groups the alias paths by the link they point at
+// Passes over every link use it instead of listing the aliases of each link in turn
+func (s *server) aliasesByLink() (map[string][]string, error) {
+	aliases, err := s.dataProvider.ListAllAliases()
+	if err != nil {
+		return nil, err
+	}
+	byLink := make(map[string][]string)
+	for _, alias := range aliases {
+		byLink[alias.LinkPath] = append(byLink[alias.LinkPath], alias.AliasPath)
+	}
+	return byLink, nil
+}
+
+// aliasCacheTask builds the cache operation for an alias, which caches the same entry as its link
+func (s *server) aliasCacheTask(link *models.LinkModel, aliaspath string) *cache.Task {
+	task := s.cacheTaskFor(link)
+	task.Linkpath = aliaspath
+	return task
+}
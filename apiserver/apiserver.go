@@ -1,111 +1,391 @@
-package apiserver
-
-import (
-	"encoding/json"
-	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"time"
-
-	"github.com/go-playground/validator/v10"
-	"github.com/julienschmidt/httprouter"
-
-	// dataprovider "github.com/regalias/atlas-api/apiserver/providers"
-	"github.com/rs/zerolog"
-
-	"github.com/regalias/atlas-api/cache"
-	"github.com/regalias/atlas-api/database"
-	"github.com/regalias/atlas-api/logging"
-
-	"github.com/regalias/atlas-api/util"
-)
-
-type server struct {
-	router           *httprouter.Router
-	validator        *validator.Validate
-	logger           *zerolog.Logger
-	http             *http.Server
-	dataProvider     database.Provider
-	cacheTaskHandler *cache.AsyncHandler
-}
-
-// Run does magic things
-func Run(args []string) int {
-
-	// TODO: parse run args
-
-	// Create logger
-	lgr, err := logging.New("debug", "atlas-api", true)
-	if err != nil {
-		fmt.Printf("Oh noes! Something went horribly wrong!")
-		panic(err)
-	}
-
-	r := httprouter.New()
-	d, err := database.NewDDB(lgr, "atlas-table-main")
-	if err != nil {
-		lgr.Fatal().Str("Error", err.Error()).Msg("Could not initialize database provider")
-	}
-	// TODO: grab table name from config
-	if err := d.InitDatabase(); err != nil {
-		lgr.Fatal().Str("Error", err.Error()).Msg("Database or table was not found and could not create required resources")
-	}
-
-	// Create cache and async task handler
-	lgr.Info().Msg("Starting cache worker...")
-	c, err := cache.NewRedisProvider("127.0.0.1", 6379, nil)
-	if err != nil {
-		lgr.Fatal().Msg(err.Error())
-	}
-
-	tq := cache.NewAsyncQueue(100, lgr, c)
-	go tq.RunWorker() // Start the worker
-	lgr.Info().Msg("Cache worker started")
-
-	// Create server context struct
-	s := server{
-		router:    r,
-		validator: newValidator(),
-		logger:    lgr,
-		http: &http.Server{
-			ReadHeaderTimeout: 20 * time.Second,
-			ReadTimeout:       1 * time.Minute,
-			WriteTimeout:      2 * time.Minute,
-			Addr:              ":8081",
-			Handler:           r,
-		},
-		dataProvider:     d,
-		cacheTaskHandler: tq,
-	}
-
-	s.routes(lgr)
-
-	lgr.Info().Msg("Atlas API server starting...")
-	if err := s.http.ListenAndServe(); err != nil {
-		lgr.Fatal().Err(err).Msg("API Startup failed")
-	}
-
-	return 0
-}
-
-// getRequest takes in an arbitrary struct, attempts to read the request, marshal the request into the struct, and perform validation
-func (s *server) getRequest(w http.ResponseWriter, r *http.Request, model interface{}) error {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		io.WriteString(w, http.StatusText(400))
-		return err
-	}
-
-	if err := json.Unmarshal(body, model); err != nil {
-		util.SendGenericResponse(w, r, http.StatusText(400), "None", http.StatusBadRequest)
-		return err
-	}
-
-	errMsg, err := s.validateModel(model)
-	if err != nil {
-		util.SendGenericResponse(w, r, "InvalidParameters", errMsg, http.StatusBadRequest)
-		return err
-	}
-	return nil
-}
+package apiserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+
+	// dataprovider "github.com/regalias/atlas-api/apiserver/providers"
+	"github.com/rs/zerolog"
+
+	"github.com/regalias/atlas-api/analytics"
+	"github.com/regalias/atlas-api/auth"
+	"github.com/regalias/atlas-api/cache"
+	"github.com/regalias/atlas-api/config"
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/logging"
+
+	"github.com/regalias/atlas-api/util"
+)
+
+type server struct {
+	router           *httprouter.Router
+	validator        *validator.Validate
+	logger           *zerolog.Logger
+	http             *http.Server
+	dataProvider     database.Provider
+	cacheProvider    cache.Provider
+	cacheTaskHandler *cache.AsyncHandler
+	authenticator    auth.Authenticator
+	roleMapper       *auth.RoleMapper
+	clickStore       analytics.Store
+	clickRecorder    *analytics.Recorder
+	redirectCode     int
+	cacheTTL         time.Duration
+	requireIfMatch   bool
+	trash            config.TrashConfig
+	shortCodes       *shortCoder
+	reaper           *job
+	clickPruner      *job
+	scheduler        *job
+	warmup           *job
+	reconciler       *reconciler
+	reconcileJob     *job
+	// ready is set to 1 once the server can take traffic, see handleReady
+	ready int32
+}
+
+// Run loads the configuration from args, wires up the providers and serves the API
+// Returns the process exit code
+func Run(args []string) int {
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	// Create logger
+	lgr, err := logging.New(cfg.LogLevel, "atlas-api", cfg.LogConsole)
+	if err != nil {
+		fmt.Printf("Oh noes! Something went horribly wrong!")
+		panic(err)
+	}
+
+	r := httprouter.New()
+	d, err := newDatabaseProvider(&cfg.Database, lgr)
+	if err != nil {
+		lgr.Fatal().Str("Error", err.Error()).Msg("Could not initialize database provider")
+	}
+	if err := d.InitDatabase(); err != nil {
+		lgr.Fatal().Str("Error", err.Error()).Msg("Database or table was not found and could not create required resources")
+	}
+
+	authn, roles, err := newAuthenticator(&cfg.Auth)
+	if err != nil {
+		lgr.Fatal().Str("Error", err.Error()).Msg("Could not initialize authentication")
+	}
+	if authn == nil {
+		lgr.Warn().Msg("Authentication is disabled, all API endpoints are open to anyone")
+	}
+
+	// Create cache and async task handler
+	lgr.Info().Msg("Starting cache worker...")
+	c, err := newCacheProvider(&cfg.Cache)
+	if err != nil {
+		lgr.Fatal().Msg(err.Error())
+	}
+
+	tq := cache.NewAsyncQueue(cfg.Cache.QueueSize, lgr, c)
+	go tq.RunWorker() // Start the worker
+	lgr.Info().Msg("Cache worker started")
+
+	clicks, rec, err := newClickRecorder(&cfg.Analytics, lgr)
+	if err != nil {
+		lgr.Fatal().Str("Error", err.Error()).Msg("Could not initialize click analytics")
+	}
+	if rec != nil {
+		go rec.RunWorker()
+	}
+
+	// Create server context struct
+	shortCodes := newShortCoder(&cfg.ShortCodes)
+	s := server{
+		router:    r,
+		validator: newValidator(shortCodes),
+		logger:    lgr,
+		http: &http.Server{
+			ReadHeaderTimeout: 20 * time.Second,
+			ReadTimeout:       1 * time.Minute,
+			WriteTimeout:      2 * time.Minute,
+			Addr:              cfg.ListenAddr,
+		},
+		dataProvider:     d,
+		cacheProvider:    c,
+		cacheTaskHandler: tq,
+		authenticator:    authn,
+		roleMapper:       roles,
+		clickStore:       clicks,
+		clickRecorder:    rec,
+		redirectCode:     cfg.RedirectCode,
+		cacheTTL:         time.Duration(cfg.Cache.EntryTTLSec) * time.Second,
+		requireIfMatch:   cfg.RequireIfMatch,
+		trash:            cfg.Trash,
+		shortCodes:       shortCodes,
+		reconciler:       newReconciler(cfg.Cache.Reconcile.RemoveOrphans),
+	}
+
+	s.routes(lgr)
+	if cfg.Cache.Warmup.Enabled {
+		if !cfg.Cache.Warmup.BlockReadiness {
+			s.setReady()
+		}
+		s.warmup = startJob(s.runCacheWarmup(cfg.Cache.Warmup))
+	} else {
+		s.setReady()
+	}
+	if cfg.Trash.Enabled {
+		s.reaper = startJob(s.runTrashReaper(time.Duration(cfg.Trash.ReapIntervalSec) * time.Second))
+	}
+	if rec != nil && cfg.Analytics.RetentionDays > 0 {
+		s.clickPruner = startJob(s.runClickPruner(time.Duration(cfg.Analytics.RetentionDays) * 24 * time.Hour))
+	}
+	s.reconcileJob = startJob(s.runReconciler(time.Duration(cfg.Cache.Reconcile.IntervalSec) * time.Second))
+	s.scheduler = startJob(s.runScheduler(time.Duration(cfg.ScheduleIntervalSec) * time.Second))
+
+	// API routes take precedence, everything else is treated as a link to resolve
+	mux := http.NewServeMux()
+	mux.Handle("/api/", r)
+	mux.Handle("/", s.redirectRoutes(lgr))
+	s.http.Handler = mux
+
+	lgr.Info().Msg("Atlas API server starting...")
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.http.ListenAndServe()
+	}()
+
+	// Serve until the listener fails or we are asked to stop
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case err := <-errc:
+		lgr.Error().Err(err).Msg("API server failed")
+		exitCode = 1
+	case recv := <-sig:
+		lgr.Info().Str("Signal", recv.String()).Msg("Atlas API server shutting down...")
+	}
+	signal.Stop(sig)
+
+	if err := s.shutdown(time.Duration(cfg.ShutdownTimeoutSec) * time.Second); err != nil {
+		exitCode = 1
+	}
+	return exitCode
+}
+
+// shutdown stops accepting requests, waits for in-flight requests, drains the cache and click queues and closes the cache
+// All steps share the same deadline
+func (s *server) shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var firstErr error
+	if err := s.http.Shutdown(ctx); err != nil {
+		s.logger.Error().Err(err).Msg("HTTP server did not shut down cleanly")
+		firstErr = err
+	}
+
+	// Background jobs submit cache tasks, so stop them before draining the queue
+	if err := s.warmup.stop(ctx); err != nil {
+		s.logger.Error().Err(err).Msg("Cache warm-up did not stop in time")
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := s.reaper.stop(ctx); err != nil {
+		s.logger.Error().Err(err).Msg("Trash reaper did not stop in time")
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := s.clickPruner.stop(ctx); err != nil {
+		s.logger.Error().Err(err).Msg("Click event pruner did not stop in time")
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := s.reconcileJob.stop(ctx); err != nil {
+		s.logger.Error().Err(err).Msg("Cache reconciler did not stop in time")
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := s.scheduler.stop(ctx); err != nil {
+		s.logger.Error().Err(err).Msg("Link scheduler did not stop in time")
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// No new tasks can be submitted once the handlers have returned
+	// Drain only returns once the worker has stopped, so the cache provider can be closed below
+	if err := s.cacheTaskHandler.Drain(ctx); err != nil {
+		s.logger.Error().Err(err).Msg("Cache task queue was not fully drained")
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if s.clickRecorder != nil {
+		if err := s.clickRecorder.Drain(ctx); err != nil {
+			s.logger.Error().Err(err).Msg("Click event queue was not fully flushed")
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if err := s.cacheProvider.Close(); err != nil {
+		s.logger.Error().Err(err).Msg("Could not close cache provider")
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr == nil {
+		s.logger.Info().Msg("Atlas API server stopped")
+	}
+	return firstErr
+}
+
+// newDatabaseProvider creates the database provider selected in the config
+func newDatabaseProvider(cfg *config.DatabaseConfig, logger *zerolog.Logger) (database.Provider, error) {
+	switch cfg.Provider {
+	case "memory":
+		return database.NewMemory(logger), nil
+	case "postgres":
+		return database.NewSQL(logger, database.DialectPostgres, cfg.DSN)
+	case "sqlite":
+		return database.NewSQL(logger, database.DialectSQLite, cfg.DSN)
+	default:
+		return database.NewDDB(logger, cfg.TableName)
+	}
+}
+
+// newCacheProvider creates the cache provider selected in the config
+func newCacheProvider(cfg *config.CacheConfig) (cache.Provider, error) {
+	switch cfg.Provider {
+	case "local":
+		return cache.NewLocalProvider(cfg.LocalExpirySec)
+	case "tiered":
+		opts, err := newRedisOptions(cfg)
+		if err != nil {
+			return nil, err
+		}
+		l2, err := cache.NewRedisProvider(opts)
+		if err != nil {
+			return nil, err
+		}
+		bus, err := l2.Invalidator(cfg.InvalidationChannel)
+		if err != nil {
+			l2.Close()
+			return nil, err
+		}
+		l1, err := cache.NewLocalProvider(cfg.LocalExpirySec)
+		if err != nil {
+			bus.Close()
+			l2.Close()
+			return nil, err
+		}
+		return cache.NewTieredProvider(l1, l2, bus), nil
+	default:
+		opts, err := newRedisOptions(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return cache.NewRedisProvider(opts)
+	}
+}
+
+// newRedisOptions converts the redis config into connection options, loading any TLS certificates
+func newRedisOptions(cfg *config.CacheConfig) (*cache.RedisOptions, error) {
+	rc := &cfg.Redis
+	opts := &cache.RedisOptions{
+		Mode:             rc.Mode,
+		Addrs:            rc.Addrs,
+		MasterName:       rc.MasterName,
+		Username:         rc.Username,
+		Password:         rc.Password,
+		SentinelUsername: rc.SentinelUsername,
+		SentinelPassword: rc.SentinelPassword,
+		DB:               rc.DB,
+		KeyPrefix:        rc.KeyPrefix,
+		PoolSize:         rc.PoolSize,
+		MinIdleConns:     rc.MinIdleConns,
+		MaxRetries:       rc.MaxRetries,
+		DialTimeout:      time.Duration(rc.DialTimeoutMs) * time.Millisecond,
+		ReadTimeout:      time.Duration(rc.ReadTimeoutMs) * time.Millisecond,
+		WriteTimeout:     time.Duration(rc.WriteTimeoutMs) * time.Millisecond,
+		PoolTimeout:      time.Duration(rc.PoolTimeoutMs) * time.Millisecond,
+	}
+	if rc.Mode == cache.RedisSingle {
+		opts.Addrs = []string{net.JoinHostPort(cfg.RedisHost, strconv.Itoa(cfg.RedisPort))}
+	}
+
+	if !rc.TLS.Enabled {
+		return opts, nil
+	}
+	opts.TLS = &tls.Config{
+		ServerName:         rc.TLS.ServerName,
+		InsecureSkipVerify: rc.TLS.InsecureSkipVerify,
+	}
+	if rc.TLS.CAFile != "" {
+		pem, err := ioutil.ReadFile(rc.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLS.RootCAs = x509.NewCertPool()
+		if !opts.TLS.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", rc.TLS.CAFile)
+		}
+	}
+	if rc.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(rc.TLS.CertFile, rc.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLS.Certificates = []tls.Certificate{cert}
+	}
+	return opts, nil
+}
+
+// getRequest takes in an arbitrary struct, attempts to read the request, marshal the request into the struct, and perform validation
+func (s *server) getRequest(w http.ResponseWriter, r *http.Request, model interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		io.WriteString(w, http.StatusText(400))
+		return err
+	}
+
+	if err := json.Unmarshal(body, model); err != nil {
+		util.SendGenericResponse(w, r, http.StatusText(400), "None", http.StatusBadRequest)
+		return err
+	}
+
+	errMsg, err := s.validateModel(model)
+	if err != nil {
+		util.SendGenericResponse(w, r, "InvalidParameters", errMsg, http.StatusBadRequest)
+		return err
+	}
+	return nil
+}
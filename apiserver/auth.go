@@ -0,0 +1,116 @@
+package apiserver
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/regalias/atlas-api/auth"
+	"github.com/regalias/atlas-api/config"
+	"github.com/regalias/atlas-api/util"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+)
+
+// anonymous is the principal used for every request when authentication is disabled
+// Without authentication there is nothing to authorize against, so it is granted every role
+var anonymous = &auth.Principal{Subject: "anonymous", Method: "none", Role: auth.RoleAdmin}
+
+// newAuthenticator builds the authenticator chain and role mapper from the config
+// Returns a nil authenticator if authentication is disabled
+func newAuthenticator(cfg *config.AuthConfig) (auth.Authenticator, *auth.RoleMapper, error) {
+	if !cfg.Enabled {
+		return nil, nil, nil
+	}
+
+	var chain auth.Chain
+	if len(cfg.APIKeys) > 0 {
+		keys := make(map[string]auth.Principal, len(cfg.APIKeys))
+		for _, k := range cfg.APIKeys {
+			p := auth.Principal{Subject: k.Subject}
+			if k.Role != "" {
+				role, err := auth.ParseRole(k.Role)
+				if err != nil {
+					return nil, nil, err
+				}
+				p.Role = role
+			}
+			keys[k.Key] = p
+		}
+		chain = append(chain, auth.NewAPIKeyAuthenticator(keys))
+	}
+	if cfg.JWT.JWKSFile != "" {
+		ks, err := auth.LoadKeySet(cfg.JWT.JWKSFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, auth.NewJWTAuthenticator(ks, auth.JWTOptions{
+			Issuer:       cfg.JWT.Issuer,
+			Audience:     cfg.JWT.Audience,
+			SubjectClaim: cfg.JWT.SubjectClaim,
+			RolesClaim:   cfg.JWT.RolesClaim,
+			ClockSkew:    time.Duration(cfg.JWT.ClockSkewSec) * time.Second,
+		}))
+	}
+
+	bindings := make(map[string]auth.Role, len(cfg.RoleBindings))
+	for sub, name := range cfg.RoleBindings {
+		role, err := auth.ParseRole(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		bindings[sub] = role
+	}
+	defaultRole, err := auth.ParseRole(cfg.DefaultRole)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return chain, auth.NewRoleMapper(bindings, defaultRole), nil
+}
+
+// authenticate is middleware that rejects requests without valid credentials
+// The authenticated principal is assigned its role, stored in the request context and added to the request logger
+func (s *server) authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := anonymous
+		if s.authenticator != nil {
+			var err error
+			p, err = s.authenticator.Authenticate(r)
+			if err != nil {
+				hlog.FromRequest(r).Debug().Str("Error", err.Error()).Msg("Authentication failed")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="atlas-api"`)
+				util.SendGenericResponse(w, r, "Unauthorized", http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			s.roleMapper.Apply(p)
+		}
+
+		hlog.FromRequest(r).UpdateContext(func(c zerolog.Context) zerolog.Context {
+			return c.Str("user", p.Subject)
+		})
+		h.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+	})
+}
+
+// requireRole returns middleware that rejects principals without the required role
+// Must be chained after authenticate
+func (s *server) requireRole(required auth.Role) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := auth.FromContext(r.Context())
+			if p == nil || !p.Role.Allows(required) {
+				util.SendGenericResponse(w, r, "Forbidden", "This operation requires the "+required.String()+" role", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// actor returns the subject of the authenticated principal for audit fields
+func actor(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil {
+		return p.Subject
+	}
+	return anonymous.Subject
+}
@@ -0,0 +1,182 @@
+package apiserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/regalias/atlas-api/auth"
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+)
+
+// batchOperation is a single create, update or delete in a batch request
+type batchOperation struct {
+	Op       string `json:"Op" validate:"required,oneof=create update delete"`
+	LinkPath string `json:"LinkPath" validate:"required,min=3,max=50,is-uri-path"`
+	// Version is checked like If-Match on updates and deletes, the check is skipped when it is omitted
+	Version *int64 `json:"Version" validate:"omitempty,min=1"`
+	// Link is the new state of the link, required for creates and updates
+	Link *batchLink `json:"Link"`
+}
+
+// batchLink holds the fields set by a create or update operation
+type batchLink struct {
+	CanonicalName string `json:"CanonicalName" validate:"required,min=3,max=50,alphanumunicode"`
+	TargetURL     string `json:"TargetURL" validate:"required,min=3,max=500,url,link-target"`
+	Enabled       bool   `json:"Enabled"`
+	scheduleFields
+}
+
+// batchResult reports the outcome of an operation in a committed batch
+type batchResult struct {
+	Op       string `json:"Op"`
+	LinkPath string `json:"LinkPath"`
+	// Version is the new version of created and updated links
+	Version int64 `json:"Version,omitempty"`
+}
+
+// batchFailure identifies the operation that stopped a batch
+type batchFailure struct {
+	Operation int    `json:"Operation"`
+	LinkPath  string `json:"LinkPath"`
+	Details   string `json:"Details"`
+}
+
+// handleBatchLinks applies a mix of creates, updates and deletes all or nothing
+// Deletes move links to the trash when it is enabled, and require the admin role
+// The cache is only updated once the whole batch has been committed
+func (s *server) handleBatchLinks() http.HandlerFunc {
+	type request struct {
+		Operations []*batchOperation `json:"Operations"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := s.getRequest(w, r, &req); err != nil {
+			return
+		}
+		if msgs := s.validateBatch(req.Operations); len(msgs) > 0 {
+			util.SendGenericResponse(w, r, "InvalidParameters", msgs, http.StatusBadRequest)
+			return
+		}
+
+		p := auth.FromContext(r.Context())
+		now := time.Now().Unix()
+		ops := make([]*database.LinkOp, len(req.Operations))
+		for i, bo := range req.Operations {
+			link := &models.LinkModel{
+				LinkPath:       bo.LinkPath,
+				Version:        database.AnyVersion,
+				LastModified:   now,
+				LastModifiedBy: actor(r),
+			}
+			if bo.Version != nil {
+				link.Version = *bo.Version
+			}
+
+			switch bo.Op {
+			case database.OpDelete:
+				if p == nil || !p.Role.Allows(auth.RoleAdmin) {
+					util.SendGenericResponse(w, r, "Forbidden", "Deleting links requires the "+auth.RoleAdmin.String()+" role", http.StatusForbidden)
+					return
+				}
+				ops[i] = &database.LinkOp{Op: database.OpDelete, Link: link}
+				if s.trash.Enabled {
+					ops[i].Op = database.OpTrash
+				}
+				continue
+			case database.OpCreate:
+				link.CreatedTime = now
+				link.Version = 0
+			}
+			link.CanonicalName = bo.Link.CanonicalName
+			link.TargetURL = bo.Link.TargetURL
+			link.Enabled = bo.Link.Enabled
+			bo.Link.scheduleFields.applyTo(link)
+			// A link in the trash at the path is purged by the create itself, so nothing changes if the batch fails
+			ops[i] = &database.LinkOp{Op: bo.Op, Link: link, PurgeTrashed: bo.Op == database.OpCreate && s.trash.ReusePaths}
+		}
+
+		if err := s.dataProvider.TransactLinks(ops); err != nil {
+			var terr *database.TransactError
+			if !errors.As(err, &terr) {
+				s.sendError(w, r, err)
+				return
+			}
+			m, ok := lookupError(terr.Err)
+			if !ok {
+				s.sendError(w, r, err)
+				return
+			}
+			if m.code == http.StatusNotModified {
+				// A no-op update in a batch is a conflict, there is no single resource to be unmodified
+				m.code, m.errMsg, m.details = http.StatusConflict, "NoChange", "The update would not modify the link"
+			}
+			util.SendGenericResponse(w, r, m.errMsg, &batchFailure{
+				Operation: terr.Index,
+				LinkPath:  ops[terr.Index].Link.LinkPath,
+				Details:   m.details,
+			}, m.code)
+			return
+		}
+
+		// Committed, now bring the cache in line
+		results := make([]*batchResult, len(ops))
+		for i, op := range ops {
+			results[i] = &batchResult{Op: req.Operations[i].Op, LinkPath: op.Link.LinkPath}
+			var err error
+			if op.Op == database.OpCreate || op.Op == database.OpUpdate {
+				results[i].Version = op.Link.Version
+				err = s.cacheLink(op.Link)
+			} else {
+				err = s.uncacheLink(op.Link.LinkPath)
+			}
+			if err != nil {
+				// The batch is already committed, so the failure is only logged
+				s.logger.Error().Msg("Couldn't submit cache task: " + err.Error())
+			}
+		}
+
+		s.logger.Info().Int("Operations", len(ops)).Msg("Applied link batch")
+		util.SendGenericResponse(w, r, "None", map[string]interface{}{"Results": results}, http.StatusOK)
+	}
+}
+
+// validateBatch checks every operation in a batch, returning messages prefixed with the operation index
+func (s *server) validateBatch(ops []*batchOperation) []string {
+	if len(ops) == 0 || len(ops) > database.MaxTransactOps {
+		return []string{"Operations must contain between 1 and " + strconv.Itoa(database.MaxTransactOps) + " operations"}
+	}
+
+	var msgs []string
+	seen := make(map[string]int, len(ops))
+	for i, op := range ops {
+		prefix := "Operations[" + strconv.Itoa(i) + "]."
+		if op == nil {
+			msgs = append(msgs, prefix+"Op is a required parameter")
+			continue
+		}
+		if errMsgs, err := s.validateModel(op); err != nil {
+			for _, m := range errMsgs {
+				msgs = append(msgs, prefix+m)
+			}
+			continue
+		}
+		// Existing links at reserved paths can still be updated and deleted, only new ones are refused
+		if op.Op == database.OpCreate && s.shortCodes.reservedPath(op.LinkPath) {
+			msgs = append(msgs, prefix+"LinkPath '"+op.LinkPath+"' is a reserved path")
+		}
+		if op.Op != database.OpDelete && op.Link == nil {
+			msgs = append(msgs, prefix+"Link is a required parameter for "+op.Op)
+		}
+		if first, ok := seen[op.LinkPath]; ok {
+			msgs = append(msgs, prefix+"LinkPath '"+op.LinkPath+"' is already used by operation "+strconv.Itoa(first))
+			continue
+		}
+		seen[op.LinkPath] = i
+	}
+	return msgs
+}
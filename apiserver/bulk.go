@@ -0,0 +1,498 @@
+package apiserver
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+)
+
+const (
+	// maxImportBytes bounds the size of an import request body
+	maxImportBytes = 64 << 20
+	// maxImportRows bounds the number of links in a single import
+	maxImportRows = 100000
+	// maxImportLine bounds a single NDJSON line
+	maxImportLine = 1 << 20
+)
+
+// Import formats
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// Import conflict policies, applied to rows whose LinkPath is already in use
+const (
+	conflictFail      = "fail"
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+)
+
+// Import row statuses
+const (
+	importCreated   = "created"
+	importUpdated   = "updated"
+	importUnchanged = "unchanged"
+	importSkipped   = "skipped"
+	importConflict  = "conflict"
+	importInvalid   = "invalid"
+	importFailed    = "failed"
+)
+
+// exportColumns are the CSV columns written by export, import reads the ones it needs and ignores the rest
+var exportColumns = []string{
+	"LinkPath", "CanonicalName", "TargetURL", "Enabled",
+	"ActivateAt", "ExpireAt", "ExpiryBehaviour", "FallbackURL",
+	"Version", "CreatedTime", "LastModified", "LastModifiedBy",
+}
+
+// importRow is a single link in an import file, validated like a create request
+type importRow struct {
+	LinkPath      string `json:"LinkPath" validate:"required,min=3,max=50,is-uri-path,not-reserved"`
+	CanonicalName string `json:"CanonicalName" validate:"required,min=3,max=50,alphanumunicode"`
+	TargetURL     string `json:"TargetURL" validate:"required,min=3,max=500,url,link-target"`
+	// Enabled defaults to true when the column or field is missing
+	Enabled *bool `json:"Enabled"`
+	scheduleFields
+}
+
+// importResult is the outcome of a single row
+type importResult struct {
+	Row      int      `json:"Row"`
+	LinkPath string   `json:"LinkPath"`
+	Status   string   `json:"Status"`
+	Errors   []string `json:"Errors,omitempty"`
+}
+
+// importReport is the response to an import, in dry run mode it describes what would have happened
+type importReport struct {
+	DryRun     bool            `json:"DryRun"`
+	OnConflict string          `json:"OnConflict"`
+	Counts     map[string]int  `json:"Counts"`
+	Rows       []*importResult `json:"Rows"`
+}
+
+// handleLinksAction dispatches custom methods on the link collection, e.g. /api/v1/links:import
+// The router captures everything after "links", including the colon
+func (s *server) handleLinksAction(actions map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := actions[httprouter.ParamsFromContext(r.Context()).ByName("action")]
+		if !ok {
+			util.SendGenericResponse(w, r, "NotFound", http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handleImportLinks creates or updates links from a CSV or NDJSON body
+// Accepts format, onConflict (fail, skip or overwrite) and dryRun query parameters
+// Rows that fail validation are reported and never written, the rest are written in batches
+func (s *server) handleImportLinks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		format := q.Get("format")
+		if format == "" {
+			format = formatFromContentType(r.Header.Get("Content-Type"))
+		}
+		if format != formatCSV && format != formatNDJSON {
+			util.SendGenericResponse(w, r, "UnsupportedMediaType", "Send text/csv or application/x-ndjson, or set format to csv or ndjson", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		report := &importReport{OnConflict: conflictFail, Counts: map[string]int{}}
+		if v := q.Get("onConflict"); v != "" {
+			if v != conflictFail && v != conflictSkip && v != conflictOverwrite {
+				util.SendGenericResponse(w, r, "InvalidParameters", "onConflict must be fail, skip or overwrite", http.StatusBadRequest)
+				return
+			}
+			report.OnConflict = v
+		}
+		if v := q.Get("dryRun"); v != "" {
+			dryRun, err := strconv.ParseBool(v)
+			if err != nil {
+				util.SendGenericResponse(w, r, "InvalidParameters", "dryRun must be true or false", http.StatusBadRequest)
+				return
+			}
+			report.DryRun = dryRun
+		}
+
+		body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+		var rows []*importRow
+		var err error
+		if format == formatCSV {
+			rows, report.Rows, err = readCSVImport(body)
+		} else {
+			rows, report.Rows, err = readNDJSONImport(body)
+		}
+		if err != nil {
+			util.SendGenericResponse(w, r, "InvalidParameters", err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(rows) > maxImportRows {
+			util.SendGenericResponse(w, r, "TooLarge", "An import may contain at most "+strconv.Itoa(maxImportRows)+" links", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// Validate every row, and reject paths that appear more than once
+		firstRow := map[string]int{}
+		var paths []string
+		for i, row := range rows {
+			res := report.Rows[i]
+			if res.Status != "" {
+				continue
+			}
+			if msgs, err := s.validateModel(row); err != nil {
+				res.Status, res.Errors = importInvalid, msgs
+				continue
+			}
+			if first, ok := firstRow[row.LinkPath]; ok {
+				res.Status, res.Errors = importInvalid, []string{"LinkPath is duplicated, first used on row " + strconv.Itoa(first)}
+				continue
+			}
+			firstRow[row.LinkPath] = res.Row
+			paths = append(paths, row.LinkPath)
+		}
+
+		existing, err := s.dataProvider.BatchGetLinks(paths)
+		if err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+
+		// Work out what happens to each valid row
+		now := time.Now().Unix()
+		var puts []*database.BatchPut
+		var putRows []*importResult
+		conflicts := false
+		for i, row := range rows {
+			res := report.Rows[i]
+			if res.Status != "" {
+				continue
+			}
+			link := row.toLink(now, actor(r))
+			prev := existing[row.LinkPath]
+
+			switch {
+			case prev == nil || (prev.DeletedAt != 0 && s.trash.ReusePaths):
+				res.Status = importCreated
+			case prev.DeletedAt != 0 && report.OnConflict != conflictSkip:
+				res.Status, res.Errors = importConflict, []string{"LinkPath is held by a link in the trash"}
+			case prev.AliasOf != "" && report.OnConflict != conflictSkip:
+				// Aliases are never overwritten by an import, they are removed through the alias endpoints
+				res.Status, res.Errors = importConflict, []string{"LinkPath is used by an alias"}
+			case report.OnConflict == conflictSkip:
+				res.Status = importSkipped
+			case report.OnConflict == conflictOverwrite && models.CheckLinkModelsAreEqual(link, prev):
+				res.Status = importUnchanged
+			case report.OnConflict == conflictOverwrite:
+				res.Status = importUpdated
+				link.CreatedTime = prev.CreatedTime
+			default:
+				res.Status, res.Errors = importConflict, []string{"LinkPath is already in use"}
+			}
+
+			switch res.Status {
+			case importConflict:
+				conflicts = true
+			case importCreated, importUpdated:
+				puts = append(puts, &database.BatchPut{Link: link, Previous: prev})
+				putRows = append(putRows, res)
+			}
+		}
+
+		status := http.StatusOK
+		if conflicts && report.OnConflict == conflictFail {
+			// All or nothing, report the conflicts and write nothing
+			for _, res := range putRows {
+				res.Status = importSkipped
+			}
+			puts = nil
+			status = http.StatusConflict
+		}
+
+		if !report.DryRun && len(puts) > 0 {
+			err := s.dataProvider.BatchPutLinks(puts)
+			var bwe *database.BatchWriteError
+			switch {
+			case errors.As(err, &bwe):
+				failed := map[string]bool{}
+				for _, p := range bwe.Failed {
+					failed[p] = true
+				}
+				for _, res := range putRows {
+					if failed[res.LinkPath] {
+						res.Status, res.Errors = importFailed, []string{"Changed during the import or could not be written, retry the import for this link"}
+					}
+				}
+			case err != nil:
+				s.sendError(w, r, err)
+				return
+			}
+
+			for i, put := range puts {
+				if putRows[i].Status == importFailed {
+					continue
+				}
+				if err := s.cacheLink(put.Link); err != nil {
+					s.logger.Error().Msg("Couldn't submit cache set task: " + err.Error())
+				}
+			}
+		}
+
+		for _, res := range report.Rows {
+			report.Counts[res.Status]++
+		}
+		s.logger.Info().Bool("DryRun", report.DryRun).Interface("Counts", report.Counts).Msg("Imported links")
+		util.SendGenericResponse(w, r, "None", report, status)
+	}
+}
+
+// handleExportLinks streams every live link as CSV or NDJSON
+// The format query parameter selects csv or ndjson, defaulting to ndjson
+func (s *server) handleExportLinks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = formatNDJSON
+		}
+
+		var contentType string
+		var write func(lm *models.LinkModel) error
+		flush := func() error { return nil }
+		cw := csv.NewWriter(w)
+		switch format {
+		case formatCSV:
+			contentType = "text/csv"
+			write = func(lm *models.LinkModel) error { return cw.Write(linkRecord(lm)) }
+			flush = func() error { cw.Flush(); return cw.Error() }
+		case formatNDJSON:
+			contentType = "application/x-ndjson"
+			enc := json.NewEncoder(w)
+			write = func(lm *models.LinkModel) error { return enc.Encode(lm) }
+		default:
+			util.SendGenericResponse(w, r, "InvalidParameters", "format must be csv or ndjson", http.StatusBadRequest)
+			return
+		}
+
+		filter := &database.ListFilter{Limit: database.MaxPageSize}
+		page, err := s.dataProvider.ListLinks(filter)
+		if err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+
+		w.Header().Set("content-type", contentType)
+		w.Header().Set("Content-Disposition", "attachment; filename=links."+format)
+		if format == formatCSV {
+			if err := cw.Write(exportColumns); err != nil {
+				s.logger.Error().Err(err).Msg("Could not write link export")
+				return
+			}
+		}
+		count := 0
+		for {
+			for _, lm := range page.Links {
+				if err := write(lm); err != nil {
+					// The response has started, all we can do is stop
+					s.logger.Error().Err(err).Msg("Could not write link export")
+					return
+				}
+				count++
+			}
+			if err := flush(); err != nil {
+				s.logger.Error().Err(err).Msg("Could not write link export")
+				return
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			filter.Cursor = page.NextCursor
+			if page, err = s.dataProvider.ListLinks(filter); err != nil {
+				s.logger.Error().Err(err).Msg("Link export failed part way through")
+				return
+			}
+		}
+		s.logger.Info().Int("Links", count).Msg("Exported links")
+	}
+}
+
+// toLink builds the model stored for a row
+func (row *importRow) toLink(now int64, actor string) *models.LinkModel {
+	lm := &models.LinkModel{
+		LinkPath:       row.LinkPath,
+		CanonicalName:  row.CanonicalName,
+		TargetURL:      row.TargetURL,
+		Enabled:        row.Enabled == nil || *row.Enabled,
+		CreatedTime:    now,
+		LastModified:   now,
+		LastModifiedBy: actor,
+	}
+	row.scheduleFields.applyTo(lm)
+	return lm
+}
+
+// formatFromContentType picks the import format from the request media type
+func formatFromContentType(contentType string) string {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch mt {
+	case "text/csv":
+		return formatCSV
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+		return formatNDJSON
+	}
+	return ""
+}
+
+// readCSVImport parses a CSV body with a header row naming the columns
+// Rows that can't be parsed get an invalid result, the other results are left for the caller to fill in
+func readCSVImport(body io.Reader) ([]*importRow, []*importResult, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, errors.New("CSV import must start with a header row")
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[name] = i
+	}
+	for _, name := range []string{"LinkPath", "CanonicalName", "TargetURL"} {
+		if _, ok := cols[name]; !ok {
+			return nil, nil, errors.New("CSV import is missing the " + name + " column")
+		}
+	}
+
+	var rows []*importRow
+	var results []*importResult
+	for n := 1; ; n++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var pe *csv.ParseError
+			if !errors.As(err, &pe) {
+				return nil, nil, err
+			}
+			rows = append(rows, &importRow{})
+			results = append(results, &importResult{Row: n, Status: importInvalid, Errors: []string{pe.Err.Error()}})
+			continue
+		}
+		if len(rows) > maxImportRows {
+			break
+		}
+
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		row := &importRow{
+			LinkPath:      field("LinkPath"),
+			CanonicalName: field("CanonicalName"),
+			TargetURL:     field("TargetURL"),
+		}
+		row.ExpiryBehaviour = field("ExpiryBehaviour")
+		row.FallbackURL = field("FallbackURL")
+
+		res := &importResult{Row: n, LinkPath: row.LinkPath}
+		if v := field("Enabled"); v != "" {
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				res.Errors = append(res.Errors, "Enabled '"+v+"' must be true or false")
+			}
+			row.Enabled = &enabled
+		}
+		for name, dst := range map[string]*int64{"ActivateAt": &row.ActivateAt, "ExpireAt": &row.ExpireAt} {
+			if v := field(name); v != "" {
+				t, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					res.Errors = append(res.Errors, name+" '"+v+"' must be a unix timestamp")
+				}
+				*dst = t
+			}
+		}
+		if len(res.Errors) > 0 {
+			res.Status = importInvalid
+		}
+		rows = append(rows, row)
+		results = append(results, res)
+	}
+	return rows, results, nil
+}
+
+// readNDJSONImport parses a body with one JSON link per line, blank lines are ignored
+func readNDJSONImport(body io.Reader) ([]*importRow, []*importResult, error) {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64*1024), maxImportLine)
+
+	var rows []*importRow
+	var results []*importResult
+	n := 0
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		n++
+		if len(rows) > maxImportRows {
+			break
+		}
+
+		row := &importRow{}
+		res := &importResult{Row: n}
+		if err := json.Unmarshal(line, row); err != nil {
+			res.Status, res.Errors = importInvalid, []string{"Invalid JSON: " + err.Error()}
+		}
+		res.LinkPath = row.LinkPath
+		rows = append(rows, row)
+		results = append(results, res)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, err
+	}
+	return rows, results, nil
+}
+
+// linkRecord formats a link as a CSV record in exportColumns order
+func linkRecord(lm *models.LinkModel) []string {
+	return []string{
+		lm.LinkPath,
+		lm.CanonicalName,
+		lm.TargetURL,
+		strconv.FormatBool(lm.Enabled),
+		optionalInt(lm.ActivateAt),
+		optionalInt(lm.ExpireAt),
+		lm.ExpiryBehaviour,
+		lm.FallbackURL,
+		strconv.FormatInt(lm.Version, 10),
+		strconv.FormatInt(lm.CreatedTime, 10),
+		strconv.FormatInt(lm.LastModified, 10),
+		lm.LastModifiedBy,
+	}
+}
+
+// optionalInt formats an optional timestamp, leaving unset values empty
+func optionalInt(v int64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatInt(v, 10)
+}
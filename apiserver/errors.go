@@ -0,0 +1,55 @@
+package apiserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/regalias/atlas-api/cache"
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/util"
+	"github.com/rs/zerolog/hlog"
+)
+
+// errorMapping describes how a provider error is presented to API clients
+type errorMapping struct {
+	err     error
+	code    int
+	errMsg  string
+	details string
+}
+
+// errorMappings is the single place provider errors are translated into HTTP statuses
+// Entries are checked in order with errors.Is
+var errorMappings = []errorMapping{
+	{database.ErrNotFound, http.StatusNotFound, "NotFound", http.StatusText(http.StatusNotFound)},
+	{database.ErrAlreadyExists, http.StatusConflict, "AlreadyExists", "Specified LinkPath is already in use"},
+	{database.ErrVersionMismatch, http.StatusPreconditionFailed, "PreconditionFailed", "The link has been modified since it was read"},
+	{database.ErrNoChange, http.StatusNotModified, "None", http.StatusText(http.StatusNotModified)},
+	{database.ErrTooManyAliases, http.StatusConflict, "TooManyAliases", "A link may have at most " + strconv.Itoa(database.MaxAliases) + " aliases"},
+	{database.ErrInvalidCursor, http.StatusBadRequest, "InvalidParameters", "cursor is not a valid continuation token"},
+	{database.ErrInvalidTransaction, http.StatusBadRequest, "InvalidParameters", "A batch needs between 1 and " + strconv.Itoa(database.MaxTransactOps) + " operations on distinct paths"},
+	{errShortCodesExhausted, http.StatusServiceUnavailable, "ShortCodeUnavailable", "Could not find a free short code, try again or choose a LinkPath"},
+	{cache.ErrNotFound, http.StatusNotFound, "NotFound", http.StatusText(http.StatusNotFound)},
+}
+
+// sendError writes the response matching a provider error
+// Unrecognised errors are logged and returned as a generic 500 ISE
+func (s *server) sendError(w http.ResponseWriter, r *http.Request, err error) {
+	if m, ok := lookupError(err); ok {
+		util.SendGenericResponse(w, r, m.errMsg, m.details, m.code)
+		return
+	}
+	hlog.FromRequest(r).Error().Str("Error", err.Error()).Msg("Unhandled provider error")
+	util.ThrowISE(w, r)
+}
+
+// lookupError finds the mapping for a provider error
+func lookupError(err error) (errorMapping, bool) {
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			return m, true
+		}
+	}
+	return errorMapping{}, false
+}
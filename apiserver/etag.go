@@ -0,0 +1,69 @@
+package apiserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/util"
+)
+
+// formatETag converts a link version into a strong entity tag
+func formatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseETag converts a strong entity tag back into a link version
+// Weak tags never match for If-Match, so they are rejected as well
+// "0" is accepted, as DynamoDB links written before versioning are served with it
+func parseETag(tag string) (int64, bool) {
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 3 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil || v < 0 {
+		return 0, false
+	}
+	return v, true
+}
+
+// etagListMatches checks an If-None-Match header against the current version using weak comparison
+func etagListMatches(header string, version int64) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if v, ok := parseETag(strings.TrimPrefix(tag, "W/")); ok && v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion reads the If-Match header into the version a write expects to replace
+// Returns database.AnyVersion if the header is absent or "*"
+// On failure the error response has already been written and ok is false
+func (s *server) ifMatchVersion(w http.ResponseWriter, r *http.Request) (version int64, ok bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch {
+	case header == "":
+		if s.requireIfMatch {
+			util.SendGenericResponse(w, r, "PreconditionRequired", "An If-Match header with the link's ETag is required", http.StatusPreconditionRequired)
+			return 0, false
+		}
+		return database.AnyVersion, true
+	case header == "*":
+		return database.AnyVersion, true
+	}
+
+	v, valid := parseETag(header)
+	if !valid {
+		// Weak or unknown tags can never match one of our strong tags
+		util.SendGenericResponse(w, r, "PreconditionFailed", "If-Match must be a single ETag returned by this API", http.StatusPreconditionFailed)
+		return 0, false
+	}
+	return v, true
+}
@@ -0,0 +1,107 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/regalias/atlas-api/database"
+)
+
+func TestParseETag(t *testing.T) {
+	tests := []struct {
+		tag     string
+		version int64
+		ok      bool
+	}{
+		{tag: `"1"`, version: 1, ok: true},
+		{tag: ` "42" `, version: 42, ok: true},
+		{tag: formatETag(9), version: 9, ok: true},
+		// Links written before versioning are at version 0
+		{tag: `"0"`, version: 0, ok: true},
+		{tag: `"-1"`},
+		{tag: `W/"1"`},
+		{tag: `1`},
+		{tag: `""`},
+		{tag: `"1`},
+		{tag: `"abc"`},
+		{tag: `"1", "2"`},
+		{tag: `*`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			version, ok := parseETag(tt.tag)
+			if version != tt.version || ok != tt.ok {
+				t.Fatalf("got (%d, %v), want (%d, %v)", version, ok, tt.version, tt.ok)
+			}
+		})
+	}
+}
+
+func TestETagListMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		match  bool
+	}{
+		{header: `"3"`, match: true},
+		{header: `*`, match: true},
+		{header: `"1", "3"`, match: true},
+		// If-None-Match uses weak comparison
+		{header: `W/"3"`, match: true},
+		{header: `"2"`},
+		{header: `"1","2"`},
+		{header: `W/"2"`},
+		{header: `garbage`},
+		{header: ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := etagListMatches(tt.header, 3); got != tt.match {
+				t.Fatalf("got %v, want %v", got, tt.match)
+			}
+		})
+	}
+}
+
+func TestIfMatchVersion(t *testing.T) {
+	tests := []struct {
+		name           string
+		requireIfMatch bool
+		header         string
+		version        int64
+		// status is the response written when the header is rejected
+		status int
+	}{
+		{name: "absent", version: database.AnyVersion},
+		{name: "absent when required", requireIfMatch: true, status: http.StatusPreconditionRequired},
+		{name: "any", header: "*", version: database.AnyVersion},
+		{name: "any when required", requireIfMatch: true, header: "*", version: database.AnyVersion},
+		{name: "strong tag", header: `"5"`, version: 5},
+		{name: "strong tag when required", requireIfMatch: true, header: `"5"`, version: 5},
+		{name: "weak tag", header: `W/"5"`, status: http.StatusPreconditionFailed},
+		{name: "tag list", header: `"4", "5"`, status: http.StatusPreconditionFailed},
+		{name: "zero", header: `"0"`, version: 0},
+		{name: "garbage", header: "5", status: http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{requireIfMatch: tt.requireIfMatch}
+			r := httptest.NewRequest(http.MethodPut, "/api/v1/links/docs", nil)
+			if tt.header != "" {
+				r.Header.Set("If-Match", tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			version, ok := s.ifMatchVersion(w, r)
+			if tt.status != 0 {
+				if ok || w.Code != tt.status {
+					t.Fatalf("got ok %v and status %d, want status %d", ok, w.Code, tt.status)
+				}
+				return
+			}
+			if !ok || version != tt.version {
+				t.Fatalf("got (%d, %v), want (%d, true)", version, ok, tt.version)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package apiserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+)
+
+// handleGetLinkHistory returns a page of a link's revisions, newest first
+// Accepts optional limit and before query parameters, before being the NextBefore of the previous page
+func (s *server) handleGetLinkHistory() http.HandlerFunc {
+	type responseModel struct {
+		Revisions  []*models.Revision `json:"Revisions"`
+		NextBefore int64              `json:"NextBefore,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		linkPath := httprouter.ParamsFromContext(r.Context()).ByName("linkpath")
+
+		q := r.URL.Query()
+		limit := database.DefaultPageSize
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 || n > database.MaxPageSize {
+				util.SendGenericResponse(w, r, "InvalidParameters", "limit must be between 1 and "+strconv.FormatInt(database.MaxPageSize, 10), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		var before int64
+		if v := q.Get("before"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				util.SendGenericResponse(w, r, "InvalidParameters", "before must be a revision number", http.StatusBadRequest)
+				return
+			}
+			before = n
+		}
+
+		revs, err := s.dataProvider.ListRevisions(linkPath, before, limit)
+		if err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+		if len(revs) == 0 && before == 0 {
+			// Links created before revisions were recorded have an empty history, anything else never existed
+			if _, err := s.dataProvider.GetLinkDetails(linkPath); err != nil {
+				s.sendError(w, r, err)
+				return
+			}
+		}
+
+		resp := &responseModel{Revisions: revs}
+		if int64(len(revs)) == limit && revs[len(revs)-1].Revision > 1 {
+			resp.NextBefore = revs[len(revs)-1].Revision
+		}
+		util.SendGenericResponse(w, r, "None", resp, http.StatusOK)
+	}
+}
+
+// handleRollbackLink restores the link to its state as of a revision
+// The restore is recorded as a new revision, and re-creates the link if it has since been deleted
+// An If-Match header is always required, and checked against the current version like an update
+// If-Match: * restores unconditionally, which is the only way to re-create a link that has since been deleted
+func (s *server) handleRollbackLink() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		linkPath := params.ByName("linkpath")
+
+		revision, err := strconv.ParseInt(params.ByName("revision"), 10, 64)
+		if err != nil || revision <= 0 {
+			util.SendGenericResponse(w, r, "InvalidParameters", "revision must be a revision number", http.StatusBadRequest)
+			return
+		}
+
+		if r.Header.Get("If-Match") == "" {
+			util.SendGenericResponse(w, r, "PreconditionRequired", "An If-Match header with the link's ETag, or *, is required to roll back", http.StatusPreconditionRequired)
+			return
+		}
+		version, ok := s.ifMatchVersion(w, r)
+		if !ok {
+			return
+		}
+
+		rev, err := s.dataProvider.GetRevision(linkPath, revision)
+		if err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+		if rev.Link == nil {
+			util.SendGenericResponse(w, r, "InvalidRevision", "Revision "+strconv.FormatInt(revision, 10)+" removed the link, roll back to an earlier revision instead", http.StatusBadRequest)
+			return
+		}
+
+		restored := *rev.Link
+		restored.LastModified = time.Now().Unix()
+		restored.LastModifiedBy = actor(r)
+		restored.Version = version
+
+		_, err = s.dataProvider.GetLinkDetails(linkPath)
+		switch {
+		case err == nil:
+			err = s.dataProvider.UpdateLink(&restored)
+		case errors.Is(err, database.ErrNotFound) && version == database.AnyVersion:
+			// Deleted since, bring it back with the original creation time
+			err = s.dataProvider.CreateLink(&restored)
+		case errors.Is(err, database.ErrNotFound):
+			err = database.ErrVersionMismatch
+		}
+		if err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+
+		if err := s.cacheLink(&restored); err != nil {
+			s.logger.Error().Msg("Couldn't submit cache set task: " + err.Error())
+			util.ThrowISE(w, r)
+			return
+		}
+
+		w.Header().Set("ETag", formatETag(restored.Version))
+		util.SendGenericResponse(w, r, "None", &restored, http.StatusOK)
+	}
+}
@@ -0,0 +1,37 @@
+package apiserver
+
+import "context"
+
+// job is a background goroutine that runs until it is stopped
+type job struct {
+	quit chan struct{}
+	done chan struct{}
+}
+
+// startJob runs fn in a new goroutine, fn must return once quit is closed
+func startJob(fn func(quit <-chan struct{})) *job {
+	j := &job{
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(j.done)
+		fn(j.quit)
+	}()
+	return j
+}
+
+// stop signals the job to return and waits for it until the context is done
+// Safe to call on a nil job
+func (j *job) stop(ctx context.Context) error {
+	if j == nil {
+		return nil
+	}
+	close(j.quit)
+	select {
+	case <-j.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,265 @@
+package apiserver
+
+import (
+	"errors"
+	"expvar"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/regalias/atlas-api/cache"
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/util"
+)
+
+// errReconcileStopped is returned when the server shuts down part way through a pass
+var errReconcileStopped = errors.New("Reconciliation was stopped")
+
+// reconcileMetrics holds the running totals of every reconciliation
+// It is left unpublished, and only served by handleMetrics
+var reconcileMetrics = new(expvar.Map).Init()
+
+// reconcileReport describes a single reconciliation pass
+type reconcileReport struct {
+	StartedAt  int64 `json:"StartedAt"`
+	FinishedAt int64 `json:"FinishedAt,omitempty"`
+	// Checked counts the link and alias paths compared with the cache
+	Checked int64 `json:"Checked"`
+	// Missing counts paths that should have been cached but were not
+	Missing int64 `json:"Missing"`
+	// Stale counts cached paths holding an entry that differs from the link, e.g. the wrong destination or version
+	Stale int64 `json:"Stale"`
+	// Orphaned counts cached paths with no link or alias behind them
+	Orphaned int64  `json:"Orphaned"`
+	Failed   int64  `json:"Failed"`
+	Error    string `json:"Error,omitempty"`
+}
+
+// reconciler repairs drift between the cache and the database, on an interval and on demand
+type reconciler struct {
+	trigger       chan struct{}
+	removeOrphans bool
+
+	mu      sync.Mutex
+	running bool
+	last    *reconcileReport
+}
+
+func newReconciler(removeOrphans bool) *reconciler {
+	return &reconciler{
+		// A single pending trigger is enough, as a pass started after it covers every earlier request
+		trigger:       make(chan struct{}, 1),
+		removeOrphans: removeOrphans,
+	}
+}
+
+// runReconciler runs a reconciliation every interval, or only when triggered if interval is 0
+func (s *server) runReconciler(interval time.Duration) func(quit <-chan struct{}) {
+	return func(quit <-chan struct{}) {
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-tick:
+			case <-s.reconciler.trigger:
+			case <-quit:
+				return
+			}
+			s.reconcile(quit)
+		}
+	}
+}
+
+// reconcile makes a single pass comparing every live link and alias with the cache, then looks for orphaned keys
+// Suspect entries are read again from the database and repaired through the cache task queue,
+// behind the cache writes of any edits made while the pass was running
+func (s *server) reconcile(quit <-chan struct{}) {
+	rep := &reconcileReport{StartedAt: time.Now().Unix()}
+	s.reconciler.mu.Lock()
+	s.reconciler.running = true
+	s.reconciler.mu.Unlock()
+
+	known, err := s.reconcileLinks(rep, quit)
+	if err == nil && s.reconciler.removeOrphans {
+		err = s.reconcileOrphans(known, rep, quit)
+	}
+	if err != nil {
+		rep.Error = err.Error()
+	}
+	rep.FinishedAt = time.Now().Unix()
+
+	s.reconciler.mu.Lock()
+	s.reconciler.running = false
+	s.reconciler.last = rep
+	s.reconciler.mu.Unlock()
+
+	reconcileMetrics.Add("Runs", 1)
+	reconcileMetrics.Add("Checked", rep.Checked)
+	reconcileMetrics.Add("Missing", rep.Missing)
+	reconcileMetrics.Add("Stale", rep.Stale)
+	reconcileMetrics.Add("Orphaned", rep.Orphaned)
+	reconcileMetrics.Add("Failed", rep.Failed)
+	if err != nil {
+		reconcileMetrics.Add("Errors", 1)
+	}
+
+	log := s.logger.Info()
+	if err != nil {
+		log = s.logger.Error().Err(err)
+	}
+	log.Int64("Checked", rep.Checked).
+		Int64("Missing", rep.Missing).
+		Int64("Stale", rep.Stale).
+		Int64("Orphaned", rep.Orphaned).
+		Int64("Failed", rep.Failed).
+		Int64("Duration", rep.FinishedAt-rep.StartedAt).
+		Msg("Cache reconciliation finished")
+}
+
+// reconcileLinks checks the cache entry of every live link and its aliases
+// Returns the set of paths seen, which are never orphans
+func (s *server) reconcileLinks(rep *reconcileReport, quit <-chan struct{}) (map[string]bool, error) {
+	known := map[string]bool{}
+	aliases, err := s.aliasesByLink()
+	if err != nil {
+		return known, err
+	}
+	filter := &database.ListFilter{Limit: database.MaxPageSize}
+	for {
+		page, err := s.dataProvider.ListLinks(filter)
+		if err != nil {
+			return known, err
+		}
+		for _, lm := range page.Links {
+			known[lm.LinkPath] = true
+			s.reconcilePath(lm.LinkPath, s.cacheTaskFor(lm), rep)
+
+			for _, aliaspath := range aliases[lm.LinkPath] {
+				known[aliaspath] = true
+				s.reconcilePath(aliaspath, s.aliasCacheTask(lm, aliaspath), rep)
+			}
+		}
+
+		if page.NextCursor == "" {
+			return known, nil
+		}
+		select {
+		case <-quit:
+			return known, errReconcileStopped
+		default:
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
+
+// reconcilePath compares the cache entry for a path with the task that would have written it
+func (s *server) reconcilePath(linkpath string, want *cache.Task, rep *reconcileReport) {
+	rep.Checked++
+	entry, err := s.cacheProvider.FetchLink(linkpath)
+	cached := err == nil
+	if err != nil && !errors.Is(err, cache.ErrNotFound) {
+		s.logger.Error().Err(err).Str("LinkPath", linkpath).Msg("Could not read cache to reconcile")
+		rep.Failed++
+		return
+	}
+
+	switch {
+	case want.Operation == cache.SetLink && !cached:
+		rep.Missing++
+	case want.Operation == cache.SetLink && *entry != *want.Entry, want.Operation == cache.RemoveLink && cached:
+		rep.Stale++
+	default:
+		return
+	}
+	s.repairPath(linkpath, rep)
+}
+
+// reconcileOrphans removes cached paths that no link or alias was seen for
+func (s *server) reconcileOrphans(known map[string]bool, rep *reconcileReport, quit <-chan struct{}) error {
+	var orphans []string
+	err := s.cacheProvider.ScanLinks(func(linkpath string) error {
+		select {
+		case <-quit:
+			return errReconcileStopped
+		default:
+		}
+		if !known[linkpath] {
+			orphans = append(orphans, linkpath)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, linkpath := range orphans {
+		// Only counted if the path really has nothing behind it by now
+		if s.repairPath(linkpath, rep) {
+			rep.Orphaned++
+		}
+	}
+	return nil
+}
+
+// repairPath reads the current state of a path from the database and queues the matching cache write
+// Returns true if the path was queued for removal
+func (s *server) repairPath(linkpath string, rep *reconcileReport) bool {
+	var task *cache.Task
+	lm, err := s.resolvePath(linkpath)
+	switch {
+	case err == nil:
+		task = s.aliasCacheTask(lm, linkpath)
+	case errors.Is(err, database.ErrNotFound):
+		task = &cache.Task{Operation: cache.RemoveLink, Linkpath: linkpath}
+	default:
+		s.logger.Error().Err(err).Str("LinkPath", linkpath).Msg("Could not read link to reconcile")
+		rep.Failed++
+		return false
+	}
+	if err := s.cacheTaskHandler.SubmitTask(task); err != nil {
+		s.logger.Error().Err(err).Str("LinkPath", linkpath).Msg("Couldn't submit cache repair task")
+		rep.Failed++
+		return false
+	}
+	return task.Operation == cache.RemoveLink
+}
+
+// handleTriggerReconcile queues a reconciliation pass, which starts once any running pass is done
+func (s *server) handleTriggerReconcile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case s.reconciler.trigger <- struct{}{}:
+		default:
+			// Already pending
+		}
+		util.SendGenericResponse(w, r, "None", http.StatusText(http.StatusAccepted), http.StatusAccepted)
+	}
+}
+
+// handleGetReconcile reports whether a reconciliation is running and the result of the last one
+func (s *server) handleGetReconcile() http.HandlerFunc {
+	type responseModel struct {
+		Running bool             `json:"Running"`
+		Last    *reconcileReport `json:"Last"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.reconciler.mu.Lock()
+		resp := &responseModel{Running: s.reconciler.running, Last: s.reconciler.last}
+		s.reconciler.mu.Unlock()
+		util.SendGenericResponse(w, r, "None", resp, http.StatusOK)
+	}
+}
+
+// handleMetrics serves the reconciler totals under cacheReconcile, in the layout of expvar.Handler
+// The process wide expvars aren't served, as they include the command line and so any secrets passed as flags
+func (s *server) handleMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		io.WriteString(w, `{"cacheReconcile": `+reconcileMetrics.String()+"}\n")
+	}
+}
@@ -0,0 +1,27 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	s := &server{}
+	w := httptest.NewRecorder()
+	s.handleMetrics()(w, httptest.NewRequest(http.MethodGet, "/api/v1/metrics", nil))
+
+	if got := w.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+		t.Fatalf("got Content-Type %q, want JSON", got)
+	}
+	var body struct {
+		CacheReconcile map[string]int64 `json:"cacheReconcile"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q is not JSON: %v", w.Body.String(), err)
+	}
+	if body.CacheReconcile == nil {
+		t.Fatalf("body %q has no cacheReconcile totals", w.Body.String())
+	}
+}
@@ -0,0 +1,143 @@
+package apiserver
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/regalias/atlas-api/analytics"
+	"github.com/regalias/atlas-api/cache"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+	"github.com/rs/zerolog"
+)
+
+// redirectRoutes builds the public link resolution router
+// This is kept separate from the API router so it can be mounted on its own path or listener
+func (s *server) redirectRoutes(appLogger *zerolog.Logger) http.Handler {
+	r := httprouter.New()
+	c := s.baseChain(appLogger)
+
+	r.Handler("GET", "/:linkpath", c.ThenFunc(s.handleRedirect()))
+	r.Handler("HEAD", "/:linkpath", c.ThenFunc(s.handleRedirect()))
+	// Trailing segments are only matched by template links, see models.ExpandTemplate
+	r.Handler("GET", "/:linkpath/*rest", c.ThenFunc(s.handleRedirect()))
+	r.Handler("HEAD", "/:linkpath/*rest", c.ThenFunc(s.handleRedirect()))
+	return r
+}
+
+// handleRedirect resolves a link path to its target, preferring the cache and falling back to the database
+// Template targets are then filled in from the rest of the request path and its query string
+func (s *server) handleRedirect() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		linkPath := httprouter.ParamsFromContext(r.Context()).ByName("linkpath")
+
+		segments, err := trailingSegments(r)
+		if err != nil {
+			util.SendGenericResponse(w, r, "InvalidParameters", err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entry, err := s.cacheProvider.FetchLink(linkPath)
+		if err == nil && entry.Expired(time.Now().Unix()) {
+			// The link's schedule has moved on since it was cached
+			err = cache.ErrNotFound
+		}
+		if err != nil {
+			if !errors.Is(err, cache.ErrNotFound) {
+				// Cache is unhealthy, the database is still authoritative
+				s.logger.Warn().Str("Error", err.Error()).Msg("Cache lookup failed, falling back to database")
+			}
+
+			m, err := s.resolvePath(linkPath)
+			if err != nil {
+				s.sendError(w, r, err)
+				return
+			}
+
+			// Repopulate the cache without holding up the redirect, under the requested path in case it is an alias
+			task := s.aliasCacheTask(m, linkPath)
+			if !s.cacheTaskHandler.TrySubmitTask(task) {
+				s.logger.Warn().Str("LinkPath", linkPath).Msg("Cache queue is full, skipped repopulating link")
+			}
+			entry = task.Entry
+		}
+
+		if !entry.Enabled {
+			util.SendGenericResponse(w, r, "NotFound", http.StatusText(404), 404)
+			return
+		}
+
+		dest, err := models.ExpandTemplate(entry.Target, segments, r.URL.Query())
+		switch {
+		case errors.Is(err, models.ErrTemplateMismatch):
+			util.SendGenericResponse(w, r, "NotFound", http.StatusText(404), 404)
+			return
+		case err != nil:
+			util.SendGenericResponse(w, r, "InvalidParameters", err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if s.clickRecorder != nil {
+			s.clickRecorder.Record(analytics.NewEvent(linkPath, r))
+		}
+
+		code := entry.Code
+		if code == 0 {
+			// Entries built without a code, redirect with the configured one
+			code = s.redirectCode
+		}
+		w.Header().Set("Location", dest)
+		w.WriteHeader(code)
+	}
+}
+
+// cacheTaskFor builds the cache operation that reflects the current state of the link
+// Links that don't resolve right now are cached as disabled so they 404 without a database lookup,
+// and expired links with a fallback are cached with the fallback URL
+// The entry carries the configured redirect code, so a cached redirect is answered the same way as one from the database
+// The entry expires at the link's next activation or expiry time, and after the configured TTL at the latest
+func (s *server) cacheTaskFor(link *models.LinkModel) *cache.Task {
+	now := time.Now().Unix()
+	dest, ok := models.ResolveLink(link, now)
+	entry := &cache.Entry{
+		Target:  dest,
+		Enabled: ok,
+		Code:    s.redirectCode,
+		Version: link.Version,
+	}
+	if link.Enabled {
+		entry.ExpireAt = models.NextScheduleChange(link, now)
+	}
+
+	ttl := s.cacheTTL
+	if entry.ExpireAt != 0 {
+		if d := time.Duration(entry.ExpireAt-now) * time.Second; ttl == 0 || d < ttl {
+			ttl = d
+		}
+	}
+	return &cache.Task{
+		Operation: cache.SetLink,
+		Linkpath:  link.LinkPath,
+		Entry:     entry,
+		TTL:       ttl,
+	}
+}
+
+// trailingSegments splits the request path after the link path into unescaped segments
+// The escaped path is split, so an encoded slash stays part of its segment
+func trailingSegments(r *http.Request) ([]string, error) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	segments := make([]string, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		seg, err := url.PathUnescape(p)
+		if err != nil {
+			return nil, models.ErrInvalidSegment
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
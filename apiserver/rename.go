@@ -0,0 +1,64 @@
+package apiserver
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/regalias/atlas-api/cache"
+	"github.com/regalias/atlas-api/util"
+)
+
+// handleRenameLink moves a link to a new path, keeping its CreatedTime and history
+// The link's aliases move with it, and the old path is removed unless KeepRedirect turns it into an alias
+// Accepts an If-Match header like an update
+func (s *server) handleRenameLink() http.HandlerFunc {
+	type request struct {
+		NewLinkPath string `json:"NewLinkPath" validate:"required,min=3,max=50,is-uri-path,not-reserved"`
+		// KeepRedirect leaves the old path as an alias so links that were already shared keep working
+		KeepRedirect bool `json:"KeepRedirect"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		linkPath := httprouter.ParamsFromContext(r.Context()).ByName("linkpath")
+
+		var req request
+		if err := s.getRequest(w, r, &req); err != nil {
+			return
+		}
+		if req.NewLinkPath == linkPath {
+			util.SendGenericResponse(w, r, "InvalidParameters", []string{"NewLinkPath must differ from the current LinkPath"}, http.StatusBadRequest)
+			return
+		}
+
+		version, ok := s.ifMatchVersion(w, r)
+		if !ok {
+			return
+		}
+
+		renamed, err := s.dataProvider.RenameLink(linkPath, req.NewLinkPath, version, req.KeepRedirect, s.trash.ReusePaths, actor(r))
+		if err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+
+		// Covers the old path too when it was kept, as it is now one of the aliases
+		if err := s.cacheLink(renamed); err != nil {
+			s.logger.Error().Msg("Couldn't submit cache set task: " + err.Error())
+			util.ThrowISE(w, r)
+			return
+		}
+		if !req.KeepRedirect {
+			if err := s.cacheTaskHandler.SubmitTask(&cache.Task{
+				Operation: cache.RemoveLink,
+				Linkpath:  linkPath,
+			}); err != nil {
+				s.logger.Error().Msg("Couldn't submit cache deletion task: " + err.Error())
+				util.ThrowISE(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("ETag", formatETag(renamed.Version))
+		util.SendGenericResponse(w, r, "None", renamed, http.StatusOK)
+	}
+}
@@ -1,49 +1,87 @@
-package apiserver
-
-import (
-	"net/http"
-	"time"
-
-	"github.com/justinas/alice"
-	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/hlog"
-)
-
-// appHeaders is middleware that adds application headers
-func appHeaders(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		w.Header().Set("Server", "atlas-api")
-		w.Header().Set("content-type", "application/json")
-		h.ServeHTTP(w, r)
-	})
-}
-
-func (s *server) routes(appLogger *zerolog.Logger) {
-
-	// Setup middleware chain
-	// Build middleware chains from base logger
-	c := alice.New().Append(hlog.NewHandler(*appLogger))
-	c = c.Append(hlog.AccessHandler(func(r *http.Request, status, size int, duration time.Duration) {
-		hlog.FromRequest(r).Info().
-			Str("method", r.Method).
-			Str("url", r.URL.String()).
-			Int("status", status).
-			Int("size", size).
-			Dur("duration", duration).
-			Msg("")
-	}))
-	c = c.Append(hlog.RemoteAddrHandler("ip"))
-	c = c.Append(hlog.UserAgentHandler("user_agent"))
-	c = c.Append(hlog.RefererHandler("referer"))
-	// c = c.Append(hlog.RequestIDHandler("req_id", "Request-Id"))
-	c = c.Append(appHeaders)
-
-	// API Routes
-	s.router.Handler("GET", "/api/v1/link", c.ThenFunc(s.handleListLinks()))
-	s.router.Handler("GET", "/api/v1/link/:linkpath", c.ThenFunc(s.handleGetLink()))
-	s.router.Handler("PUT", "/api/v1/link", c.ThenFunc(s.handleUpdateLink()))
-	s.router.Handler("POST", "/api/v1/link", c.ThenFunc(s.handleCreateLink()))
-	s.router.Handler("DELETE", "/api/v1/link/:linkpath", c.ThenFunc(s.handleDeleteLink()))
-
-}
+package apiserver
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/regalias/atlas-api/auth"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+)
+
+// appHeaders is middleware that adds application headers
+func appHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.Header().Set("Server", "atlas-api")
+		w.Header().Set("content-type", "application/json")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// baseChain builds the middleware chain shared by every router from the base logger
+func (s *server) baseChain(appLogger *zerolog.Logger) alice.Chain {
+	c := alice.New().Append(hlog.NewHandler(*appLogger))
+	c = c.Append(hlog.AccessHandler(func(r *http.Request, status, size int, duration time.Duration) {
+		hlog.FromRequest(r).Info().
+			Str("method", r.Method).
+			Str("url", r.URL.String()).
+			Int("status", status).
+			Int("size", size).
+			Dur("duration", duration).
+			Msg("")
+	}))
+	c = c.Append(hlog.RemoteAddrHandler("ip"))
+	c = c.Append(hlog.UserAgentHandler("user_agent"))
+	c = c.Append(hlog.RefererHandler("referer"))
+	// c = c.Append(hlog.RequestIDHandler("req_id", "Request-Id"))
+	c = c.Append(appHeaders)
+	return c
+}
+
+func (s *server) routes(appLogger *zerolog.Logger) {
+
+	// Setup middleware chain
+	c := s.baseChain(appLogger)
+
+	// Every API route requires an authenticated principal with at least the given role
+	ac := c.Append(s.authenticate)
+	viewer := ac.Append(s.requireRole(auth.RoleViewer))
+	editor := ac.Append(s.requireRole(auth.RoleEditor))
+	admin := ac.Append(s.requireRole(auth.RoleAdmin))
+
+	// Probes are left open so orchestrators don't need credentials
+	s.router.Handler("GET", "/api/v1/ready", c.ThenFunc(s.handleReady()))
+
+	// API Routes
+	s.router.Handler("GET", "/api/v1/link", viewer.ThenFunc(s.handleListLinks(false)))
+	s.router.Handler("GET", "/api/v1/link/:linkpath", viewer.ThenFunc(s.handleGetLink()))
+	s.router.Handler("GET", "/api/v1/link/:linkpath/stats", viewer.ThenFunc(s.handleGetLinkStats()))
+	s.router.Handler("GET", "/api/v1/link/:linkpath/history", viewer.ThenFunc(s.handleGetLinkHistory()))
+	s.router.Handler("POST", "/api/v1/link/:linkpath/rollback/:revision", editor.ThenFunc(s.handleRollbackLink()))
+	s.router.Handler("POST", "/api/v1/link/:linkpath/rename", editor.ThenFunc(s.handleRenameLink()))
+	s.router.Handler("GET", "/api/v1/link/:linkpath/aliases", viewer.ThenFunc(s.handleListAliases()))
+	s.router.Handler("POST", "/api/v1/link/:linkpath/aliases", editor.ThenFunc(s.handleCreateAlias()))
+	s.router.Handler("PUT", "/api/v1/link", editor.ThenFunc(s.handleUpdateLink()))
+	s.router.Handler("POST", "/api/v1/link", editor.ThenFunc(s.handleCreateLink()))
+	s.router.Handler("DELETE", "/api/v1/link/:linkpath", admin.ThenFunc(s.handleDeleteLink()))
+	s.router.Handler("GET", "/api/v1/trash", viewer.ThenFunc(s.handleListLinks(true)))
+	s.router.Handler("POST", "/api/v1/trash/:linkpath/restore", editor.ThenFunc(s.handleRestoreLink()))
+	s.router.Handler("DELETE", "/api/v1/trash/:linkpath", admin.ThenFunc(s.handlePurgeLink()))
+	s.router.Handler("GET", "/api/v1/cache/reconcile", admin.ThenFunc(s.handleGetReconcile()))
+	s.router.Handler("POST", "/api/v1/cache/reconcile", admin.ThenFunc(s.handleTriggerReconcile()))
+	s.router.Handler("GET", "/api/v1/metrics", admin.ThenFunc(s.handleMetrics()))
+	s.router.Handler("GET", "/api/v1/alias/:aliaspath", viewer.ThenFunc(s.handleGetAlias()))
+	s.router.Handler("DELETE", "/api/v1/alias/:aliaspath", editor.ThenFunc(s.handleDeleteAlias()))
+
+	// Custom methods on the link collection, e.g. POST /api/v1/links:import
+	s.router.Handler("GET", "/api/v1/links:action", viewer.ThenFunc(s.handleLinksAction(map[string]http.HandlerFunc{
+		":export": s.handleExportLinks(),
+	})))
+	s.router.Handler("POST", "/api/v1/links:action", editor.ThenFunc(s.handleLinksAction(map[string]http.HandlerFunc{
+		":import": s.handleImportLinks(),
+		":batch":  s.handleBatchLinks(),
+	})))
+
+}
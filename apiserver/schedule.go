@@ -0,0 +1,111 @@
+package apiserver
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/models"
+)
+
+// scheduleFields are the optional activation window fields shared by the create and update request models
+type scheduleFields struct {
+	ActivateAt      int64  `json:"ActivateAt,omitempty" validate:"min=0"`
+	ExpireAt        int64  `json:"ExpireAt,omitempty" validate:"min=0"`
+	ExpiryBehaviour string `json:"ExpiryBehaviour,omitempty" validate:"omitempty,oneof=notfound fallback"`
+	FallbackURL     string `json:"FallbackURL,omitempty" validate:"omitempty,max=500,url,link-target"`
+}
+
+// validateSchedule checks the rules spanning several schedule fields
+func validateSchedule(sl validator.StructLevel) {
+	// The struct is embedded unexported, so read the fields without calling Interface on it
+	cur := sl.Current()
+	activateAt := cur.FieldByName("ActivateAt").Int()
+	expireAt := cur.FieldByName("ExpireAt").Int()
+	if expireAt != 0 && expireAt <= activateAt {
+		sl.ReportError(expireAt, "ExpireAt", "ExpireAt", "after-activate", "")
+	}
+	if cur.FieldByName("ExpiryBehaviour").String() == models.ExpiryFallback && cur.FieldByName("FallbackURL").String() == "" {
+		sl.ReportError("", "FallbackURL", "FallbackURL", "required", "")
+	}
+}
+
+// applyTo copies the schedule onto a link model
+func (sf *scheduleFields) applyTo(lm *models.LinkModel) {
+	lm.ActivateAt = sf.ActivateAt
+	lm.ExpireAt = sf.ExpireAt
+	lm.ExpiryBehaviour = sf.ExpiryBehaviour
+	lm.FallbackURL = sf.FallbackURL
+}
+
+// runScheduler keeps the cache in step with link activation and expiry times
+// It wakes at the next boundary, or after interval to pick up schedules added since the last pass
+// The interval is jittered so the nodes sharing a database don't all list the scheduled links together
+func (s *server) runScheduler(interval time.Duration) func(quit <-chan struct{}) {
+	return func(quit <-chan struct{}) {
+		// The first pass treats every boundary in the past as new, fixing up the cache after a restart
+		var since int64
+		// Seeded per node, the default source would give every node the same jitter
+		jitter := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for {
+			now := time.Now().Unix()
+			next := s.syncSchedules(since, now, quit)
+			since = now
+
+			wait := interval + time.Duration(jitter.Int63n(int64(interval)/5+1))
+			if next != 0 {
+				if d := time.Until(time.Unix(next, 0)); d < wait {
+					wait = d
+				}
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-quit:
+				timer.Stop()
+				return
+			}
+		}
+	}
+}
+
+// syncSchedules refreshes the cache entries of links activated or expired after since and up to now
+// Returns the next activation or expiry time of any scheduled link, or 0 if there is none
+func (s *server) syncSchedules(since int64, now int64, quit <-chan struct{}) int64 {
+	filter := &database.ListFilter{Scheduled: true, Limit: database.MaxPageSize}
+	var next int64
+	synced := 0
+	for {
+		page, err := s.dataProvider.ListLinks(filter)
+		if err != nil {
+			s.logger.Error().Err(err).Msg("Could not list scheduled links")
+			return next
+		}
+		for _, lm := range page.Links {
+			if models.ScheduleChangedBetween(lm, since, now) {
+				if err := s.cacheLink(lm); err != nil {
+					s.logger.Error().Err(err).Str("LinkPath", lm.LinkPath).Msg("Couldn't submit scheduled cache task")
+				}
+				synced++
+			}
+			if t := models.NextScheduleChange(lm, now); t != 0 && (next == 0 || t < next) {
+				next = t
+			}
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		select {
+		case <-quit:
+			return next
+		default:
+		}
+		filter.Cursor = page.NextCursor
+	}
+	if synced > 0 {
+		s.logger.Info().Int("Links", synced).Msg("Updated cache for scheduled link changes")
+	}
+	return next
+}
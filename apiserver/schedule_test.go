@@ -0,0 +1,125 @@
+package apiserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/regalias/atlas-api/cache"
+	"github.com/regalias/atlas-api/config"
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/models"
+	"github.com/rs/zerolog"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	v := newValidator(newShortCoder(&config.ShortCodeConfig{}))
+	type request struct {
+		scheduleFields
+	}
+	tests := []struct {
+		name     string
+		schedule scheduleFields
+		valid    bool
+	}{
+		{name: "unscheduled", valid: true},
+		{name: "activation only", schedule: scheduleFields{ActivateAt: 100}, valid: true},
+		{name: "expiry only", schedule: scheduleFields{ExpireAt: 100}, valid: true},
+		{name: "window", schedule: scheduleFields{ActivateAt: 100, ExpireAt: 200}, valid: true},
+		{name: "empty window", schedule: scheduleFields{ActivateAt: 100, ExpireAt: 100}},
+		{name: "expires before activating", schedule: scheduleFields{ActivateAt: 200, ExpireAt: 100}},
+		{name: "negative time", schedule: scheduleFields{ActivateAt: -1}},
+		{
+			name:     "fallback",
+			schedule: scheduleFields{ExpireAt: 100, ExpiryBehaviour: models.ExpiryFallback, FallbackURL: "https://example.com"},
+			valid:    true,
+		},
+		{name: "fallback without a URL", schedule: scheduleFields{ExpireAt: 100, ExpiryBehaviour: models.ExpiryFallback}},
+		{name: "unknown behaviour", schedule: scheduleFields{ExpiryBehaviour: "redirect"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.Struct(&request{tt.schedule})
+			if tt.valid && err != nil {
+				t.Fatalf("got error %v, want valid", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("got valid, want an error")
+			}
+		})
+	}
+}
+
+func TestSyncSchedules(t *testing.T) {
+	now := time.Now().Unix()
+	since := now - 60
+	links := []struct {
+		path       string
+		activateAt int64
+		expireAt   int64
+		// synced is whether the pass rewrites the path's cache entry
+		synced bool
+	}{
+		{path: "activated", activateAt: now - 30, synced: true},
+		{path: "expired", expireAt: now - 10, synced: true},
+		{path: "activated-earlier", activateAt: now - 600},
+		{path: "activated-at-since", activateAt: since},
+		{path: "activates-later", activateAt: now + 600},
+		{path: "expires-later", activateAt: now - 600, expireAt: now + 300},
+		{path: "unscheduled"},
+	}
+
+	logger := zerolog.Nop()
+	dp := database.NewMemory(&logger)
+	cp, err := cache.NewLocalProvider(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	for _, l := range links {
+		lm := &models.LinkModel{
+			LinkPath:      l.path,
+			CanonicalName: l.path,
+			TargetURL:     "https://example.com/" + l.path,
+			Enabled:       true,
+			ActivateAt:    l.activateAt,
+			ExpireAt:      l.expireAt,
+		}
+		if err := dp.CreateLink(lm); err != nil {
+			t.Fatal(err)
+		}
+		// Only the links whose schedule changed in the window may be touched
+		if err := cp.UpsertLink(l.path, &cache.Entry{Target: "stale", Enabled: true}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tasks := cache.NewAsyncQueue(len(links), &logger, cp)
+	go tasks.RunWorker()
+	s := &server{logger: &logger, dataProvider: dp, cacheTaskHandler: tasks}
+
+	// The earliest upcoming boundary is the expiry of expires-later
+	if next := s.syncSchedules(since, now, make(chan struct{})); next != now+300 {
+		t.Fatalf("got next change %d, want %d", next, now+300)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tasks.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range links {
+		t.Run(l.path, func(t *testing.T) {
+			entry, err := cp.FetchLink(l.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if synced := entry.Target != "stale"; synced != l.synced {
+				t.Fatalf("got entry %+v, want synced %v", entry, l.synced)
+			}
+			// Links that expired without a fallback are cached as not redirecting
+			if want := l.expireAt == 0 || !l.synced; entry.Enabled != want {
+				t.Fatalf("got Enabled %v, want %v", entry.Enabled, want)
+			}
+		})
+	}
+}
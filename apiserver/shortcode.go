@@ -0,0 +1,109 @@
+package apiserver
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/regalias/atlas-api/config"
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/models"
+)
+
+// errShortCodesExhausted is returned when every generated code tried for a new link was already taken
+var errShortCodesExhausted = errors.New("Could not find a free short code")
+
+// reservedPaths can never be generated or chosen as they are routed elsewhere
+var reservedPaths = []string{"api"}
+
+// shortCoder generates random link paths for links created without one
+type shortCoder struct {
+	alphabet string
+	length   int
+	attempts int
+	// reserved holds lower cased words that generated codes may not contain
+	reserved []string
+}
+
+func newShortCoder(cfg *config.ShortCodeConfig) *shortCoder {
+	reserved := make([]string, len(cfg.Reserved))
+	for i, word := range cfg.Reserved {
+		reserved[i] = strings.ToLower(word)
+	}
+	return &shortCoder{
+		alphabet: cfg.Alphabet,
+		length:   cfg.Length,
+		attempts: cfg.Attempts,
+		reserved: reserved,
+	}
+}
+
+// generate draws a random code with every character of the alphabet equally likely
+func (sc *shortCoder) generate() (string, error) {
+	max := big.NewInt(int64(len(sc.alphabet)))
+	code := make([]byte, sc.length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = sc.alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// reservedPath checks a caller chosen path against the reserved paths and the configured word list
+// Unlike generated codes, chosen paths are only rejected when they match a word exactly
+func (sc *shortCoder) reservedPath(path string) bool {
+	lower := strings.ToLower(path)
+	for _, p := range reservedPaths {
+		if lower == p {
+			return true
+		}
+	}
+	for _, word := range sc.reserved {
+		if lower == word {
+			return true
+		}
+	}
+	return false
+}
+
+// blocked checks a code against the reserved paths and the configured word list
+func (sc *shortCoder) blocked(code string) bool {
+	lower := strings.ToLower(code)
+	for _, p := range reservedPaths {
+		if lower == p {
+			return true
+		}
+	}
+	for _, word := range sc.reserved {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// createWithShortCode creates the link under a generated path, drawing a new code whenever one is taken
+// Blocked codes use up an attempt like taken ones, and codes held by links in the trash are skipped rather than purged
+func (s *server) createWithShortCode(link *models.LinkModel) error {
+	for i := 0; i < s.shortCodes.attempts; i++ {
+		code, err := s.shortCodes.generate()
+		if err != nil {
+			return err
+		}
+		if s.shortCodes.blocked(code) {
+			continue
+		}
+		link.LinkPath = code
+		err = s.dataProvider.CreateLink(link)
+		if !errors.Is(err, database.ErrAlreadyExists) {
+			return err
+		}
+		s.logger.Debug().Str("LinkPath", code).Msg("Generated short code is taken, retrying")
+	}
+	link.LinkPath = ""
+	return errShortCodesExhausted
+}
@@ -0,0 +1,123 @@
+package apiserver
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/regalias/atlas-api/analytics"
+	"github.com/regalias/atlas-api/config"
+	"github.com/regalias/atlas-api/util"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// defaultStatsRange is the window returned when no from parameter is supplied
+	defaultStatsRange = 7 * 24 * time.Hour
+	// maxStatsRange bounds the window a single stats request may cover
+	maxStatsRange = 366 * 24 * time.Hour
+)
+
+// newClickRecorder creates the analytics store and recorder selected in the config
+// Returns nil if analytics are disabled
+func newClickRecorder(cfg *config.AnalyticsConfig, logger *zerolog.Logger) (analytics.Store, *analytics.Recorder, error) {
+	if !cfg.Enabled {
+		return nil, nil, nil
+	}
+
+	var store analytics.Store
+	var err error
+	switch cfg.Provider {
+	case "postgres":
+		store, err = analytics.NewSQLStore("postgres", cfg.DSN)
+	case "sqlite":
+		store, err = analytics.NewSQLStore("sqlite", cfg.DSN)
+	default:
+		store = analytics.NewMemoryStore()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := store.Init(); err != nil {
+		return nil, nil, err
+	}
+
+	rec := analytics.NewRecorder(store, cfg.QueueSize, cfg.BatchSize, time.Duration(cfg.FlushIntervalSec)*time.Second, logger)
+	return store, rec, nil
+}
+
+// handleGetLinkStats returns the click counters for a link
+// Accepts optional from and to query parameters as unix seconds, defaulting to the last 7 days
+func (s *server) handleGetLinkStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.clickStore == nil {
+			util.SendGenericResponse(w, r, "NotImplemented", "Click analytics are disabled", http.StatusNotImplemented)
+			return
+		}
+
+		linkPath := httprouter.ParamsFromContext(r.Context()).ByName("linkpath")
+
+		now := time.Now()
+		to, err := unixParam(r, "to", now.Unix())
+		if err != nil {
+			util.SendGenericResponse(w, r, "InvalidParameters", "to must be a unix timestamp", http.StatusBadRequest)
+			return
+		}
+		from, err := unixParam(r, "from", to-int64(defaultStatsRange/time.Second))
+		if err != nil {
+			util.SendGenericResponse(w, r, "InvalidParameters", "from must be a unix timestamp", http.StatusBadRequest)
+			return
+		}
+		if from >= to || to-from > int64(maxStatsRange/time.Second) {
+			util.SendGenericResponse(w, r, "InvalidParameters", "from must be before to and the range at most 366 days", http.StatusBadRequest)
+			return
+		}
+
+		// Only report on links that exist
+		if _, err := s.dataProvider.GetLinkDetails(linkPath); err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+
+		st, err := s.clickStore.GetStats(linkPath, from, to)
+		if err != nil {
+			s.logger.Error().Str("Error", err.Error()).Msg("Could not fetch link stats")
+			util.ThrowISE(w, r)
+			return
+		}
+		util.SendGenericResponse(w, r, "None", st, http.StatusOK)
+	}
+}
+
+// unixParam parses an optional unix timestamp query parameter
+func unixParam(r *http.Request, name string, def int64) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// clickPruneInterval is how often raw click events past the retention window are deleted
+const clickPruneInterval = time.Hour
+
+// runClickPruner deletes raw click events older than retention, on startup and then every clickPruneInterval
+func (s *server) runClickPruner(retention time.Duration) func(quit <-chan struct{}) {
+	return func(quit <-chan struct{}) {
+		ticker := time.NewTicker(clickPruneInterval)
+		defer ticker.Stop()
+
+		for {
+			before := time.Now().Add(-retention).Unix()
+			if err := s.clickStore.Prune(before); err != nil {
+				s.logger.Error().Err(err).Msg("Could not prune click events")
+			}
+			select {
+			case <-ticker.C:
+			case <-quit:
+				return
+			}
+		}
+	}
+}
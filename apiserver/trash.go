@@ -0,0 +1,126 @@
+package apiserver
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+)
+
+// trashCutoff returns the oldest deletion time that can still be restored
+func (s *server) trashCutoff() int64 {
+	return time.Now().Add(-time.Duration(s.trash.RetentionHours) * time.Hour).Unix()
+}
+
+// handleRestoreLink brings a link back from the trash if it is still within the retention window
+func (s *server) handleRestoreLink() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		linkPath := httprouter.ParamsFromContext(r.Context()).ByName("linkpath")
+
+		m, err := s.dataProvider.RestoreLink(linkPath, s.trashCutoff(), actor(r))
+		if err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+
+		if err := s.cacheLink(m); err != nil {
+			s.logger.Error().Msg("Couldn't submit cache set task: " + err.Error())
+			util.ThrowISE(w, r)
+			return
+		}
+
+		w.Header().Set("ETag", formatETag(m.Version))
+		util.SendGenericResponse(w, r, "None", m, http.StatusOK)
+	}
+}
+
+// handlePurgeLink permanently removes a link from the trash without waiting for the reaper
+func (s *server) handlePurgeLink() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		linkPath := httprouter.ParamsFromContext(r.Context()).ByName("linkpath")
+
+		if err := s.dataProvider.PurgeLink(linkPath, time.Now().Unix()); err != nil {
+			s.sendError(w, r, err)
+			return
+		}
+		util.SendGenericResponse(w, r, "None", http.StatusText(http.StatusOK), http.StatusOK)
+	}
+}
+
+// createReusingTrash creates the link, purging a link in the trash that holds the same path if reuse is enabled
+// Without reuse it is a plain create, otherwise it goes through the same conditional write as a batch create
+// so the trashed link is only purged if the create succeeds
+func (s *server) createReusingTrash(link *models.LinkModel) error {
+	if !s.trash.ReusePaths {
+		return s.dataProvider.CreateLink(link)
+	}
+	err := s.dataProvider.TransactLinks([]*database.LinkOp{{Op: database.OpCreate, Link: link, PurgeTrashed: true}})
+	var te *database.TransactError
+	if errors.As(err, &te) {
+		return te.Err
+	}
+	return err
+}
+
+// runTrashReaper periodically purges links that have been in the trash longer than the retention window
+func (s *server) runTrashReaper(interval time.Duration) func(quit <-chan struct{}) {
+	return func(quit <-chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		s.reapTrash(quit)
+		for {
+			select {
+			case <-ticker.C:
+				s.reapTrash(quit)
+			case <-quit:
+				return
+			}
+		}
+	}
+}
+
+// reapTrash makes a single pass over the trash, purging expired links
+func (s *server) reapTrash(quit <-chan struct{}) {
+	cutoff := s.trashCutoff()
+	filter := &database.ListFilter{Trashed: true, Limit: database.MaxPageSize}
+	purged := 0
+pages:
+	for {
+		page, err := s.dataProvider.ListLinks(filter)
+		if err != nil {
+			s.logger.Error().Err(err).Msg("Could not list the trash")
+			return
+		}
+		for _, lm := range page.Links {
+			if lm.DeletedAt > cutoff {
+				continue
+			}
+			if err := s.dataProvider.PurgeLink(lm.LinkPath, cutoff); err != nil {
+				if !errors.Is(err, database.ErrNotFound) {
+					s.logger.Error().Err(err).Str("LinkPath", lm.LinkPath).Msg("Could not purge link from the trash")
+				}
+				continue
+			}
+			purged++
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		select {
+		case <-quit:
+			// Shutting down, the next pass starts again from the beginning of the trash
+			break pages
+		default:
+		}
+		filter.Cursor = page.NextCursor
+	}
+	if purged > 0 {
+		s.logger.Info().Int("Purged", purged).Msg("Purged expired links from the trash")
+	}
+}
@@ -1,71 +1,94 @@
-package apiserver
-
-import (
-	"regexp"
-
-	"github.com/go-playground/validator/v10"
-)
-
-// use a single instance of Validate, as it caches struct info
-var validate *validator.Validate
-
-// use builtin url validation instead
-// func validateURL(fl validator.FieldLevel) bool {
-// 	return isURL(fl.Field().String())
-// }
-
-func validateURI(fl validator.FieldLevel) bool {
-	re := regexp.MustCompile("^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?$")
-	return re.MatchString(fl.Field().String())
-}
-
-func newValidator() *validator.Validate {
-	validate = validator.New()
-	validate.RegisterValidation("is-uri-path", validateURI)
-	//validate.RegisterValidation("is-url", validateURL)
-	return validate
-}
-
-// validateModel attempts to validate a model
-// Returns the upstream validation error if any, as well as a slice of formatted error messages
-func (s *server) validateModel(m interface{}) ([]string, error) {
-
-	err := s.validator.Struct(m)
-	if err != nil {
-
-		switch err.(type) {
-		case *validator.InvalidValidationError:
-			// err = err.(*validator.InvalidValidationError)
-			errMsgs := make([]string, 1)
-			errMsgs[0] = "Internal Error"
-			// s.logger.Debug().Msg(err.Error())
-			s.logger.Error().Msg("validateModel: Internal validation failure")
-			return errMsgs, err
-
-		case validator.ValidationErrors:
-			validationErrors := err.(validator.ValidationErrors)
-			errMsgs := make([]string, len(validationErrors))
-
-			for i, s := range validationErrors {
-				var validationFailureReason string
-				switch s.Tag() {
-				case "max":
-					validationFailureReason = " '" + s.Value().(string) + "' is too large or long"
-				case "min":
-					validationFailureReason = " '" + s.Value().(string) + "' is too small or short"
-				case "is-uri":
-					validationFailureReason = " '" + s.Value().(string) + "' is not a valid URI"
-				case "url":
-					validationFailureReason = " '" + s.Value().(string) + "' is not a valid URL"
-				case "required":
-					validationFailureReason = " is a required parameter"
-				default:
-					validationFailureReason = " '" + s.Value().(string) + "' has an unspecified error"
-				}
-				errMsgs[i] = s.Field() + validationFailureReason
-			}
-			return errMsgs, err
-		}
-	}
-	return nil, nil
-}
+package apiserver
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/regalias/atlas-api/models"
+)
+
+// use a single instance of Validate, as it caches struct info
+var validate *validator.Validate
+
+// use builtin url validation instead
+// func validateURL(fl validator.FieldLevel) bool {
+// 	return isURL(fl.Field().String())
+// }
+
+func validateURI(fl validator.FieldLevel) bool {
+	re := regexp.MustCompile("^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?$")
+	return re.MatchString(fl.Field().String())
+}
+
+// validateLinkTarget checks the placeholders of a template TargetURL, URLs without placeholders always pass
+func validateLinkTarget(fl validator.FieldLevel) bool {
+	return models.ValidateTemplate(fl.Field().String()) == nil
+}
+
+// newValidator builds the validator, rejecting paths the short coder reserves through the not-reserved tag
+func newValidator(sc *shortCoder) *validator.Validate {
+	validate = validator.New()
+	validate.RegisterValidation("is-uri-path", validateURI)
+	validate.RegisterValidation("not-reserved", func(fl validator.FieldLevel) bool {
+		return !sc.reservedPath(fl.Field().String())
+	})
+	validate.RegisterValidation("link-target", validateLinkTarget)
+	validate.RegisterStructValidation(validateSchedule, scheduleFields{})
+	//validate.RegisterValidation("is-url", validateURL)
+	return validate
+}
+
+// validateModel attempts to validate a model
+// Returns the upstream validation error if any, as well as a slice of formatted error messages
+func (s *server) validateModel(m interface{}) ([]string, error) {
+
+	err := s.validator.Struct(m)
+	if err != nil {
+
+		switch err.(type) {
+		case *validator.InvalidValidationError:
+			// err = err.(*validator.InvalidValidationError)
+			errMsgs := make([]string, 1)
+			errMsgs[0] = "Internal Error"
+			// s.logger.Debug().Msg(err.Error())
+			s.logger.Error().Msg("validateModel: Internal validation failure")
+			return errMsgs, err
+
+		case validator.ValidationErrors:
+			validationErrors := err.(validator.ValidationErrors)
+			errMsgs := make([]string, len(validationErrors))
+
+			for i, s := range validationErrors {
+				var validationFailureReason string
+				// Not every field is a string
+				value := fmt.Sprint(s.Value())
+				switch s.Tag() {
+				case "max":
+					validationFailureReason = " '" + value + "' is too large or long"
+				case "min":
+					validationFailureReason = " '" + value + "' is too small or short"
+				case "is-uri":
+					validationFailureReason = " '" + value + "' is not a valid URI"
+				case "url":
+					validationFailureReason = " '" + value + "' is not a valid URL"
+				case "link-target":
+					validationFailureReason = " '" + value + "' has unknown or misplaced placeholders"
+				case "not-reserved":
+					validationFailureReason = " '" + value + "' is a reserved path"
+				case "required":
+					validationFailureReason = " is a required parameter"
+				case "oneof":
+					validationFailureReason = " '" + value + "' must be one of: " + s.Param()
+				case "after-activate":
+					validationFailureReason = " '" + value + "' must be after ActivateAt"
+				default:
+					validationFailureReason = " '" + value + "' has an unspecified error"
+				}
+				errMsgs[i] = s.Field() + validationFailureReason
+			}
+			return errMsgs, err
+		}
+	}
+	return nil, nil
+}
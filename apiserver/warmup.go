@@ -0,0 +1,147 @@
+package apiserver
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/regalias/atlas-api/cache"
+	"github.com/regalias/atlas-api/config"
+	"github.com/regalias/atlas-api/database"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+)
+
+// warmupCounts tallies the cache writes made by a warm-up
+type warmupCounts struct {
+	links   int64
+	aliases int64
+	// skipped counts paths edited while the warm-up ran, which the task queue caches instead
+	skipped int64
+	failed  int64
+}
+
+// runCacheWarmup streams every enabled link and its aliases from the database into the cache
+// Writes skip the task queue, spread over cfg.Concurrency workers, but never overwrite a path a task was queued for since the warm-up started
+// The server is marked ready once the warm-up finishes, fails or is stopped
+func (s *server) runCacheWarmup(cfg config.CacheWarmupConfig) func(quit <-chan struct{}) {
+	return func(quit <-chan struct{}) {
+		defer s.setReady()
+
+		// Taken before the first page is read, so every edit the pages might miss is queued after it
+		since := s.cacheTaskHandler.Track()
+		defer s.cacheTaskHandler.Untrack()
+
+		start := time.Now()
+		// Listed once for the whole pass, an alias edited after this is left to its queued task like any other path
+		aliases, err := s.aliasesByLink()
+		if err != nil {
+			s.logger.Error().Err(err).Msg("Could not list aliases to warm up the cache")
+			return
+		}
+		var counts warmupCounts
+		links := make(chan *models.LinkModel)
+		var wg sync.WaitGroup
+		for i := 0; i < cfg.Concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for lm := range links {
+					s.warmLink(since, lm, aliases[lm.LinkPath], &counts)
+				}
+			}()
+		}
+
+		enabled := true
+		filter := &database.ListFilter{Enabled: &enabled, Limit: int64(cfg.PageSize)}
+		complete := s.feedWarmup(filter, links, &counts, quit)
+		close(links)
+		wg.Wait()
+
+		log := s.logger.Info()
+		if !complete {
+			log = s.logger.Warn()
+		}
+		log.Int64("Links", atomic.LoadInt64(&counts.links)).
+			Int64("Aliases", atomic.LoadInt64(&counts.aliases)).
+			Int64("Skipped", atomic.LoadInt64(&counts.skipped)).
+			Int64("Failed", atomic.LoadInt64(&counts.failed)).
+			Dur("Duration", time.Since(start)).
+			Bool("Complete", complete).
+			Msg("Cache warm-up finished")
+	}
+}
+
+// feedWarmup pages through the links matching filter, handing each to the workers and logging progress per page
+// Returns false if listing failed or the warm-up was stopped before the last page
+func (s *server) feedWarmup(filter *database.ListFilter, links chan<- *models.LinkModel, counts *warmupCounts, quit <-chan struct{}) bool {
+	for {
+		page, err := s.dataProvider.ListLinks(filter)
+		if err != nil {
+			s.logger.Error().Err(err).Msg("Could not list links to warm up the cache")
+			return false
+		}
+		for _, lm := range page.Links {
+			select {
+			case links <- lm:
+			case <-quit:
+				return false
+			}
+		}
+		s.logger.Info().Int64("Links", atomic.LoadInt64(&counts.links)).Msg("Warming up cache...")
+
+		if page.NextCursor == "" {
+			return true
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
+
+// warmLink writes the cache entries of a link and its aliases
+// Paths edited, deleted or disabled since the warm-up started are skipped, as the state read here may be older than the queued task
+func (s *server) warmLink(since uint64, lm *models.LinkModel, aliases []string, counts *warmupCounts) {
+	task := s.cacheTaskFor(lm)
+	if !s.warmPath(since, task, counts) {
+		return
+	}
+	atomic.AddInt64(&counts.links, 1)
+
+	for _, aliaspath := range aliases {
+		if s.warmPath(since, s.aliasCacheTask(lm, aliaspath), counts) {
+			atomic.AddInt64(&counts.aliases, 1)
+		}
+	}
+}
+
+// warmPath writes a single cache entry unless its path was edited since the warm-up started
+// Returns true if the entry was written
+func (s *server) warmPath(since uint64, task *cache.Task, counts *warmupCounts) bool {
+	written, err := s.cacheTaskHandler.WarmLink(since, task.Linkpath, task.Entry, task.TTL)
+	if err != nil {
+		s.logger.Error().Err(err).Str("LinkPath", task.Linkpath).Msg("Could not warm up cached path")
+		atomic.AddInt64(&counts.failed, 1)
+		return false
+	}
+	if !written {
+		atomic.AddInt64(&counts.skipped, 1)
+	}
+	return written
+}
+
+// setReady marks the server as ready to take traffic
+func (s *server) setReady() {
+	atomic.StoreInt32(&s.ready, 1)
+}
+
+// handleReady reports whether the server is ready to take traffic, for load balancer and orchestrator probes
+// The server is not ready while a blocking cache warm-up is running
+func (s *server) handleReady() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&s.ready) == 0 {
+			util.SendGenericResponse(w, r, "NotReady", "Cache warm-up is in progress", http.StatusServiceUnavailable)
+			return
+		}
+		util.SendGenericResponse(w, r, "None", http.StatusText(http.StatusOK), http.StatusOK)
+	}
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// APIKeyAuthenticator authenticates requests against a static set of API keys
+// Keys are read from the X-API-Key header or an "Authorization: ApiKey <key>" header
+type APIKeyAuthenticator struct {
+	// Keys are stored hashed so lookups do not leak key contents through timing
+	principals map[[sha256.Size]byte]Principal
+}
+
+// NewAPIKeyAuthenticator creates an authenticator from a key -> principal mapping
+func NewAPIKeyAuthenticator(keys map[string]Principal) *APIKeyAuthenticator {
+	a := &APIKeyAuthenticator{
+		principals: make(map[[sha256.Size]byte]Principal, len(keys)),
+	}
+	for k, p := range keys {
+		p.Method = "apikey"
+		a.principals[sha256.Sum256([]byte(k))] = p
+	}
+	return a
+}
+
+// Authenticate implements Authenticator
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		scheme, cred := splitAuthorization(r)
+		if !strings.EqualFold(scheme, "ApiKey") {
+			return nil, ErrNoCredentials
+		}
+		key = cred
+	}
+	if key == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	for h, p := range a.principals {
+		if subtle.ConstantTimeCompare(h[:], sum[:]) == 1 {
+			// Hand out a copy so callers can't modify the configured principal
+			return &p, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// splitAuthorization splits the Authorization header into its scheme and credentials
+func splitAuthorization(r *http.Request) (string, string) {
+	h := strings.TrimSpace(r.Header.Get("Authorization"))
+	i := strings.IndexByte(h, ' ')
+	if i < 0 {
+		return h, ""
+	}
+	return h[:i], strings.TrimSpace(h[i+1:])
+}
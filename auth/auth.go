@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrNoCredentials is returned when a request carries no credentials the authenticator understands
+	ErrNoCredentials = errors.New("NoCredentials")
+	// ErrInvalidCredentials is returned when credentials were supplied but could not be verified
+	ErrInvalidCredentials = errors.New("InvalidCredentials")
+)
+
+// Principal is the authenticated identity behind a request
+type Principal struct {
+	Subject string
+	Method  string
+	Role    Role
+}
+
+// Authenticator verifies the credentials on a request
+type Authenticator interface {
+	// Authenticate returns the principal for the request
+	// Returns ErrNoCredentials if the request has no credentials for this authenticator,
+	// or ErrInvalidCredentials if they could not be verified
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Chain tries each authenticator in order until one recognises the request's credentials
+type Chain []Authenticator
+
+// Authenticate implements Authenticator
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return nil, ErrNoCredentials
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of the context carrying the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal stored in the context, or nil if there is none
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// MaxClockSkew is the largest allowance for clock differences accepted when checking token times
+const MaxClockSkew = 5 * time.Minute
+
+// JWTAuthenticator authenticates "Authorization: Bearer <token>" requests
+// Tokens must be signed with HS256 or RS256 by a key in the configured key set, and must carry an exp claim
+type JWTAuthenticator struct {
+	keys *KeySet
+	opts JWTOptions
+}
+
+// JWTOptions contains the claim checks and mappings for bearer tokens
+// Issuer and audience are only checked when non-empty, SubjectClaim defaults to "sub"
+// Roles are only read from the token when RolesClaim is set
+// ClockSkew is allowed either side of the exp, nbf and iat times, and is capped at MaxClockSkew
+type JWTOptions struct {
+	Issuer       string
+	Audience     string
+	SubjectClaim string
+	RolesClaim   string
+	ClockSkew    time.Duration
+}
+
+// NewJWTAuthenticator creates a bearer token authenticator
+func NewJWTAuthenticator(keys *KeySet, opts JWTOptions) *JWTAuthenticator {
+	if opts.SubjectClaim == "" {
+		opts.SubjectClaim = "sub"
+	}
+	if opts.ClockSkew > MaxClockSkew {
+		opts.ClockSkew = MaxClockSkew
+	}
+	return &JWTAuthenticator{
+		keys: keys,
+		opts: opts,
+	}
+}
+
+// Authenticate implements Authenticator
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, raw := splitAuthorization(r)
+	if !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := a.verify(raw)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	sub, _ := claims[a.opts.SubjectClaim].(string)
+	if sub == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	p := &Principal{Subject: sub, Method: "jwt"}
+	if a.opts.RolesClaim != "" {
+		p.Role = highestRole(claimStrings(claims[a.opts.RolesClaim]))
+	}
+	return p, nil
+}
+
+// verify checks the token signature and registered claims, returning the token claims
+// The time claims are checked here rather than by the parser so the clock skew allowance applies
+func (a *JWTAuthenticator) verify(raw string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+	if _, err := parser.ParseWithClaims(raw, claims, a.keyFunc); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Add(-a.opts.ClockSkew).Unix(), true) {
+		return nil, errors.New("token is expired or has no exp claim")
+	}
+	if !claims.VerifyNotBefore(now.Add(a.opts.ClockSkew).Unix(), false) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now.Add(a.opts.ClockSkew).Unix(), false) {
+		return nil, errors.New("token was issued in the future")
+	}
+
+	if a.opts.Issuer != "" && !claims.VerifyIssuer(a.opts.Issuer, true) {
+		return nil, errors.New("unexpected issuer")
+	}
+	if a.opts.Audience != "" && !verifyAudience(claims, a.opts.Audience) {
+		return nil, errors.New("unexpected audience")
+	}
+	return claims, nil
+}
+
+// keyFunc picks the verification key, making sure the key type matches the signing method
+// so that an RSA public key can never be used as an HMAC secret
+func (a *JWTAuthenticator) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	switch t.Method {
+	case jwt.SigningMethodRS256:
+		if k, ok := a.keys.rsaKey(kid); ok {
+			return k, nil
+		}
+	case jwt.SigningMethodHS256:
+		if k, ok := a.keys.hmacKey(kid); ok {
+			return k, nil
+		}
+	default:
+		return nil, errors.New("unsupported signing method " + t.Method.Alg())
+	}
+	return nil, errors.New("no key found for kid " + kid)
+}
+
+// verifyAudience checks the aud claim, which may be a string or an array of strings
+func verifyAudience(claims jwt.MapClaims, audience string) bool {
+	for _, aud := range claimStrings(claims["aud"]) {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// claimStrings reads a claim that may be a single string or an array of strings
+func claimStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		s := make([]string, 0, len(v))
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				s = append(s, str)
+			}
+		}
+		return s
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+)
+
+// Role is a permission level, each role includes the permissions of the roles below it
+type Role int
+
+const (
+	// RoleNone grants no access
+	RoleNone Role = iota
+	// RoleViewer may read links
+	RoleViewer
+	// RoleEditor may also create and update links
+	RoleEditor
+	// RoleAdmin may also delete links
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleNone:   "none",
+	RoleViewer: "viewer",
+	RoleEditor: "editor",
+	RoleAdmin:  "admin",
+}
+
+// String returns the configuration name of the role
+func (r Role) String() string {
+	if n, ok := roleNames[r]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// ParseRole converts a role name into a Role, case insensitively
+func ParseRole(name string) (Role, error) {
+	for r, n := range roleNames {
+		if strings.EqualFold(n, name) {
+			return r, nil
+		}
+	}
+	return RoleNone, errors.New("unknown role " + name)
+}
+
+// Allows checks if the role includes the permissions of the required role
+func (r Role) Allows(required Role) bool {
+	return r >= required
+}
+
+// RoleMapper assigns roles to authenticated principals from static subject bindings
+// A principal ends up with the highest of its own role, its binding and the default role
+type RoleMapper struct {
+	bindings    map[string]Role
+	defaultRole Role
+}
+
+// NewRoleMapper creates a mapper from subject -> role bindings
+func NewRoleMapper(bindings map[string]Role, defaultRole Role) *RoleMapper {
+	return &RoleMapper{
+		bindings:    bindings,
+		defaultRole: defaultRole,
+	}
+}
+
+// Apply updates the principal's role in place
+func (m *RoleMapper) Apply(p *Principal) {
+	if r, ok := m.bindings[p.Subject]; ok && r > p.Role {
+		p.Role = r
+	}
+	if m.defaultRole > p.Role {
+		p.Role = m.defaultRole
+	}
+}
+
+// highestRole returns the highest recognised role out of a list of role names
+// Unknown names are ignored
+func highestRole(names []string) Role {
+	best := RoleNone
+	for _, n := range names {
+		if r, err := ParseRole(n); err == nil && r > best {
+			best = r
+		}
+	}
+	return best
+}
@@ -1,88 +1,215 @@
-package cache
-
-import (
-	"errors"
-	"strconv"
-	"time"
-
-	"github.com/rs/zerolog"
-)
-
-type taskop int
-
-const maxRetryCount = 3
-
-const (
-	SetLink taskop = iota
-	RemoveLink
-)
-
-// Task containers the cache operation request details
-type Task struct {
-	Operation taskop
-	Linkpath  string
-	Linkdest  string
-}
-
-// AsyncHandler contains the context for the queue and worker
-type AsyncHandler struct {
-	taskQueue chan *Task
-	logger    *zerolog.Logger
-	cache     Provider
-}
-
-// NewAsyncQueue creates a new task queue with the specified queue size
-func NewAsyncQueue(queueSize int, logger *zerolog.Logger, cacheprov Provider) *AsyncHandler {
-	ch := make(chan *Task, queueSize)
-	return &AsyncHandler{
-		taskQueue: ch,
-		logger:    logger,
-		cache:     cacheprov,
-	}
-}
-
-// SubmitTask puts a new cache operation request into the queue
-func (th *AsyncHandler) SubmitTask(ct *Task) error {
-
-	for retryCount := 0; retryCount < maxRetryCount; retryCount++ {
-		select {
-		case th.taskQueue <- ct:
-			// do something
-			return nil
-		default:
-			// Queue is full!
-			// Retry after a second
-			time.Sleep(time.Second)
-		}
-	}
-	return errors.New("TaskSubmitFailed")
-}
-
-// TODO: retry?
-
-// RunWorker runs the async worker thread
-func (th *AsyncHandler) RunWorker() {
-	for {
-		t := <-th.taskQueue
-		// th.logger.Debug().Msg("Got task: " + strconv.Itoa(int(t.operation)) + t.linkpath + t.linkdest)
-		switch t.Operation {
-		case SetLink:
-			// TODO: query the link to check if it exists before setting?
-			if err := th.cache.UpsertLink(t.Linkpath, t.Linkdest); err != nil {
-				th.logError("Couldn't set link in cache: " + err.Error())
-			}
-		case RemoveLink:
-			// remove the link from cache
-			if err := th.cache.DeleteLink(t.Linkpath); err != nil {
-				th.logError("Couldn't delete link in cache: " + err.Error())
-			}
-		default:
-			// unknown op
-			th.logError("Unknown operation: " + strconv.Itoa(int(t.Operation)))
-		}
-	}
-}
-
-func (th *AsyncHandler) logError(errmsg string) {
-	th.logger.Error().Str("Segment", "TaskWorker").Msg(errmsg)
-}
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type taskop int
+
+const maxRetryCount = 3
+
+const (
+	SetLink taskop = iota
+	RemoveLink
+)
+
+// Task containers the cache operation request details
+type Task struct {
+	Operation taskop
+	Linkpath  string
+	// Entry and TTL are only used by SetLink
+	Entry *Entry
+	TTL   time.Duration
+}
+
+// AsyncHandler contains the context for the queue and worker
+type AsyncHandler struct {
+	taskQueue chan *Task
+	logger    *zerolog.Logger
+	cache     Provider
+	// mu is held for reading while submitting and for writing while closing the queue,
+	// so a task is either queued before Drain closes it or rejected with ErrStopped
+	mu     sync.RWMutex
+	closed bool
+	// abort stops the worker between tasks when Drain runs out of time
+	abort     chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+	abortOnce sync.Once
+	// writing is held by the worker while it processes a task, and shared by warm-up writes,
+	// so a warm-up write can't land between a task being queued and processed
+	writing sync.RWMutex
+	// While tracking is on, touched holds the sequence number of the last task submitted for each path
+	trackMu  sync.Mutex
+	tracking int
+	seq      uint64
+	touched  map[string]uint64
+}
+
+// NewAsyncQueue creates a new task queue with the specified queue size
+func NewAsyncQueue(queueSize int, logger *zerolog.Logger, cacheprov Provider) *AsyncHandler {
+	ch := make(chan *Task, queueSize)
+	return &AsyncHandler{
+		taskQueue: ch,
+		logger:    logger,
+		cache:     cacheprov,
+		abort:     make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// SubmitTask puts a new cache operation request into the queue
+func (th *AsyncHandler) SubmitTask(ct *Task) error {
+	for retryCount := 0; retryCount < maxRetryCount; retryCount++ {
+		queued, err := th.enqueue(ct)
+		if queued || err != nil {
+			return err
+		}
+		// Queue is full!
+		// Retry after a second
+		time.Sleep(time.Second)
+	}
+	return ErrQueueFull
+}
+
+// TrySubmitTask puts a new cache operation request into the queue without waiting
+// Returns false if the queue is full
+func (th *AsyncHandler) TrySubmitTask(ct *Task) bool {
+	queued, _ := th.enqueue(ct)
+	return queued
+}
+
+// enqueue queues the task if there is room, without waiting
+// Returns ErrStopped once the handler has been drained
+func (th *AsyncHandler) enqueue(ct *Task) (bool, error) {
+	th.mu.RLock()
+	defer th.mu.RUnlock()
+	if th.closed {
+		return false, ErrStopped
+	}
+	// Recorded before queueing so the task can't be processed first, a task that then doesn't fit only causes a needless skip
+	th.touch(ct.Linkpath)
+	select {
+	case th.taskQueue <- ct:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// RunWorker runs the async worker thread until Drain is called and the queue is empty
+func (th *AsyncHandler) RunWorker() {
+	defer close(th.done)
+	for t := range th.taskQueue {
+		th.writing.Lock()
+		th.process(t)
+		th.writing.Unlock()
+		select {
+		case <-th.abort:
+			return
+		default:
+		}
+	}
+}
+
+// Drain stops the handler accepting new tasks and waits for the worker to finish the queued ones
+// If the context expires first the worker is stopped after its current task, and a DrainTimeout error is returned,
+// so the cache provider is no longer in use once Drain returns
+func (th *AsyncHandler) Drain(ctx context.Context) error {
+	th.stopOnce.Do(func() {
+		th.mu.Lock()
+		th.closed = true
+		close(th.taskQueue)
+		th.mu.Unlock()
+	})
+	select {
+	case <-th.done:
+		return nil
+	case <-ctx.Done():
+		th.abortOnce.Do(func() {
+			close(th.abort)
+		})
+		<-th.done
+		return fmt.Errorf("%w: %d tasks were not processed", ErrDrainTimeout, len(th.taskQueue))
+	}
+}
+
+// Track starts recording the paths tasks are submitted for, so WarmLink can tell which entries it read are stale
+// Returns the sequence to pass to WarmLink, and must be paired with a call to Untrack
+func (th *AsyncHandler) Track() uint64 {
+	th.trackMu.Lock()
+	defer th.trackMu.Unlock()
+	if th.tracking == 0 {
+		th.touched = map[string]uint64{}
+	}
+	th.tracking++
+	th.seq++
+	return th.seq
+}
+
+// Untrack stops recording submitted paths once every Track call has been paired
+func (th *AsyncHandler) Untrack() {
+	th.trackMu.Lock()
+	defer th.trackMu.Unlock()
+	th.tracking--
+	if th.tracking == 0 {
+		th.touched = nil
+	}
+}
+
+// touch records a task being submitted for linkpath while tracking is on
+func (th *AsyncHandler) touch(linkpath string) {
+	th.trackMu.Lock()
+	defer th.trackMu.Unlock()
+	if th.tracking > 0 {
+		th.seq++
+		th.touched[linkpath] = th.seq
+	}
+}
+
+// WarmLink writes an entry read from the database straight to the cache, skipping the queue
+// The write is skipped, returning false, if a task for linkpath was submitted since Track returned since,
+// as that task carries a newer state of the link, or its removal
+// Tiered providers don't publish invalidations for warm writes, as the entry is already current everywhere
+func (th *AsyncHandler) WarmLink(since uint64, linkpath string, entry *Entry, ttl time.Duration) (bool, error) {
+	th.writing.RLock()
+	defer th.writing.RUnlock()
+	th.trackMu.Lock()
+	stale := th.touched[linkpath] > since
+	th.trackMu.Unlock()
+	if stale {
+		return false, nil
+	}
+	if t, ok := th.cache.(*TieredProvider); ok {
+		return true, t.warmLink(linkpath, entry, ttl)
+	}
+	return true, th.cache.UpsertLink(linkpath, entry, ttl)
+}
+
+func (th *AsyncHandler) process(t *Task) {
+	switch t.Operation {
+	case SetLink:
+		// TODO: query the link to check if it exists before setting?
+		if err := th.cache.UpsertLink(t.Linkpath, t.Entry, t.TTL); err != nil {
+			th.logError("Couldn't set link in cache: " + err.Error())
+		}
+	case RemoveLink:
+		// remove the link from cache
+		if err := th.cache.DeleteLink(t.Linkpath); err != nil {
+			th.logError("Couldn't delete link in cache: " + err.Error())
+		}
+	default:
+		// unknown op
+		th.logError("Unknown operation: " + strconv.Itoa(int(t.Operation)))
+	}
+}
+
+func (th *AsyncHandler) logError(errmsg string) {
+	th.logger.Error().Str("Segment", "TaskWorker").Msg(errmsg)
+}
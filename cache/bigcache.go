@@ -1,55 +1,127 @@
-package cache
-
-import (
-	"errors"
-	"time"
-
-	"github.com/allegro/bigcache"
-)
-
-// LocalProvider contains context for a local in-memory cache
-type LocalProvider struct {
-	cache *bigcache.BigCache
-}
-
-// NewLocalProvider creates a new local in-memory cache
-func NewLocalProvider(expiryTimeSec int64) (*LocalProvider, error) {
-	cache, err := bigcache.NewBigCache(bigcache.DefaultConfig(time.Duration(expiryTimeSec) * time.Second))
-
-	lp := &LocalProvider{
-		cache: cache,
-	}
-
-	return lp, err
-}
-
-// FetchLink attempts to grab a link key from the cache
-// Returns a NotFound error if the key is empty or does not exist
-func (lp *LocalProvider) FetchLink(linkpath string) (string, error) {
-
-	entry, err := lp.cache.Get(linkpath)
-	if err != nil {
-		if err == bigcache.ErrEntryNotFound {
-			return "", errors.New("NotFound")
-		}
-		return "", err
-	}
-	// Additional check that key is not empty
-	dest := string(entry)
-	if len(dest) < 1 {
-		return "", errors.New("NotFound")
-	}
-	return dest, err
-}
-
-// DeleteLink will remove the linkpath key from the cache
-// Returns an error only on operational errors
-func (lp *LocalProvider) DeleteLink(linkpath string) error {
-	return lp.cache.Delete(linkpath)
-}
-
-// UpsertLink will insert a linkpath:dest mapping into the cache
-// Returns an error only on operational errors
-func (lp *LocalProvider) UpsertLink(linkpath string, dest string) error {
-	return lp.cache.Set(linkpath, []byte(dest))
-}
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/allegro/bigcache"
+	"github.com/regalias/atlas-api/util"
+)
+
+// deadlineSize is the length of the expiry time stored in front of each value, in unix nanoseconds or 0 for none
+const deadlineSize = 8
+
+// LocalProvider contains context for a local in-memory cache
+// bigcache only has a single lifetime for every entry, so per entry TTLs are stored in front of each value
+// and checked on read, with the shared lifetime as an upper bound
+type LocalProvider struct {
+	cache *bigcache.BigCache
+	// keys tracks the linkpaths written for ScanLinks, as keys read back through the bigcache iterator are unreliable
+	// Keys evicted by bigcache stay here until the next scan notices they are gone
+	keys sync.Map
+}
+
+// NewLocalProvider creates a new local in-memory cache
+func NewLocalProvider(expiryTimeSec int64) (*LocalProvider, error) {
+	cache, err := bigcache.NewBigCache(bigcache.DefaultConfig(time.Duration(expiryTimeSec) * time.Second))
+
+	lp := &LocalProvider{
+		cache: cache,
+	}
+
+	return lp, err
+}
+
+// FetchLink attempts to grab a link key from the cache
+// Returns a NotFound error if the key is empty, does not exist or has passed its TTL
+func (lp *LocalProvider) FetchLink(linkpath string) (*Entry, error) {
+	val, err := lp.get(linkpath)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntry(val)
+}
+
+// get reads the stored value of a linkpath, removing it if its TTL has passed
+func (lp *LocalProvider) get(linkpath string) ([]byte, error) {
+	val, err := lp.cache.Get(linkpath)
+	if err != nil {
+		if err == bigcache.ErrEntryNotFound {
+			return nil, util.WrapError(ErrNotFound, err)
+		}
+		return nil, err
+	}
+	// Additional check that key is not empty
+	if len(val) <= deadlineSize {
+		return nil, ErrNotFound
+	}
+	deadline := int64(binary.BigEndian.Uint64(val))
+	if deadline != 0 && time.Now().UnixNano() >= deadline {
+		lp.DeleteLink(linkpath)
+		return nil, ErrNotFound
+	}
+	return val[deadlineSize:], nil
+}
+
+// DeleteLink will remove the linkpath key from the cache
+// Returns an error only on operational errors
+func (lp *LocalProvider) DeleteLink(linkpath string) error {
+	lp.keys.Delete(linkpath)
+	err := lp.cache.Delete(linkpath)
+	if err == bigcache.ErrEntryNotFound {
+		// Nothing to delete
+		return nil
+	}
+	return err
+}
+
+// UpsertLink will insert a linkpath:entry mapping into the cache
+// The entry is dropped after ttl or the cache's shared lifetime, whichever comes first
+// Returns an error only on operational errors
+func (lp *LocalProvider) UpsertLink(linkpath string, entry *Entry, ttl time.Duration) error {
+	data, err := encodeEntry(entry)
+	if err != nil {
+		return err
+	}
+	val := make([]byte, deadlineSize+len(data))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(val, uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	copy(val[deadlineSize:], data)
+	if err := lp.cache.Set(linkpath, val); err != nil {
+		return err
+	}
+	lp.keys.Store(linkpath, struct{}{})
+	return nil
+}
+
+// ScanLinks walks every linkpath in the local cache, forgetting the ones bigcache has evicted or that have expired
+func (lp *LocalProvider) ScanLinks(fn func(linkpath string) error) error {
+	var err error
+	lp.keys.Range(func(k, _ interface{}) bool {
+		linkpath := k.(string)
+		if _, gerr := lp.get(linkpath); errors.Is(gerr, ErrNotFound) {
+			lp.keys.Delete(linkpath)
+			return true
+		}
+		err = fn(linkpath)
+		return err == nil
+	})
+	return err
+}
+
+// Reset empties the local cache
+func (lp *LocalProvider) Reset() error {
+	lp.keys.Range(func(k, _ interface{}) bool {
+		lp.keys.Delete(k)
+		return true
+	})
+	return lp.cache.Reset()
+}
+
+// Close releases the in-memory cache
+func (lp *LocalProvider) Close() error {
+	return lp.cache.Close()
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/regalias/atlas-api/util"
+)
+
+// entryFormat names the way entries are encoded, and is part of every redis key
+// Change it along with the encoding, so nodes running different versions use different keys during a rolling deploy
+const entryFormat = "v3:"
+
+// Entry is the cached state of a link path, enough to answer a redirect without the database
+type Entry struct {
+	// Target is where the path redirects to, empty when it should not redirect
+	Target string `json:"Target,omitempty"`
+	// Enabled is false when the link exists but should not redirect right now, e.g. it is disabled or expired
+	Enabled bool `json:"Enabled"`
+	// Code is the status code the redirect is sent with
+	Code int `json:"Code,omitempty"`
+	// ExpireAt is the unix time the link's schedule changes what the path resolves to, 0 if it never does
+	// The entry must not be served from then on, even if the provider still holds it
+	ExpireAt int64 `json:"ExpireAt,omitempty"`
+	// Version is the version of the link the entry was built from
+	Version int64 `json:"Version"`
+}
+
+// Expired checks if the entry is out of date at the given unix time
+func (e *Entry) Expired(now int64) bool {
+	return e.ExpireAt != 0 && now >= e.ExpireAt
+}
+
+// remaining returns how long the entry stays valid, or 0 if it does not expire
+func (e *Entry) remaining() time.Duration {
+	if e.ExpireAt == 0 {
+		return 0
+	}
+	d := time.Until(time.Unix(e.ExpireAt, 0))
+	if d <= 0 {
+		// Already expired, keep it for as little as the providers allow
+		return time.Millisecond
+	}
+	return d
+}
+
+// encodeEntry serializes an entry for storage
+func encodeEntry(entry *Entry) ([]byte, error) {
+	return json.Marshal(entry)
+}
+
+// decodeEntry parses a stored entry
+// Values that can't be parsed are reported as ErrNotFound, so that callers fall back to the database and overwrite them
+func decodeEntry(data []byte) (*Entry, error) {
+	entry := &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, util.WrapError(ErrNotFound, err)
+	}
+	return entry, nil
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEntryEncoding(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry Entry
+	}{
+		{name: "redirect", entry: Entry{Target: "https://example.com", Enabled: true, Version: 3}},
+		{name: "redirect code", entry: Entry{Target: "https://example.com", Enabled: true, Code: 301, Version: 3}},
+		{name: "disabled", entry: Entry{Version: 1}},
+		{name: "scheduled", entry: Entry{Target: "https://example.com", Enabled: true, ExpireAt: 2000, Version: 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := encodeEntry(&tt.entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decodeEntry(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.entry) {
+				t.Fatalf("got %+v, want %+v", *got, tt.entry)
+			}
+		})
+	}
+}
+
+func TestDecodeEntryRejectsUnknownValues(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "empty", data: ""},
+		// Values written by versions that stored the bare target URL
+		{name: "bare URL", data: "https://example.com"},
+		{name: "truncated", data: `{"Target":"https://exa`},
+		{name: "wrong type", data: `{"Version":"3"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeEntry([]byte(tt.data)); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("got error %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestEntryExpiry(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name     string
+		expireAt int64
+		expired  bool
+		// remaining is checked to be within a second of the expected duration
+		remaining time.Duration
+	}{
+		{name: "never expires"},
+		{name: "expires later", expireAt: now + 60, remaining: time.Minute},
+		{name: "expires now", expireAt: now, expired: true, remaining: time.Millisecond},
+		{name: "expired", expireAt: now - 60, expired: true, remaining: time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Entry{ExpireAt: tt.expireAt}
+			if got := e.Expired(now); got != tt.expired {
+				t.Errorf("Expired = %v, want %v", got, tt.expired)
+			}
+			if got := e.remaining(); got > tt.remaining || got < tt.remaining-time.Second {
+				t.Errorf("remaining = %v, want about %v", got, tt.remaining)
+			}
+		})
+	}
+}
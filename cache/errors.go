@@ -0,0 +1,22 @@
+package cache
+
+import (
+	"errors"
+
+	"github.com/regalias/atlas-api/util"
+)
+
+var (
+	// ErrNotFound is returned when the link is not in the cache
+	ErrNotFound = errors.New("NotFound")
+	// ErrQueueFull is returned when a task could not be queued within the retry limit
+	ErrQueueFull = errors.New("TaskSubmitFailed")
+	// ErrStopped is returned when submitting a task after the handler has been drained
+	ErrStopped = errors.New("HandlerStopped")
+	// ErrDrainTimeout is returned when the queue could not be drained before the deadline
+	ErrDrainTimeout = errors.New("DrainTimeout")
+)
+
+// Error pairs one of the sentinel errors with the underlying Redis or bigcache error that caused it
+// Use errors.Is to check the sentinel and errors.As to inspect the cause
+type Error = util.Error
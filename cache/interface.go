@@ -1,17 +1,28 @@
-package cache
-
-// Provider is the generic interface for interacting with an underlying cache provider
-type Provider interface {
-
-	// FetchLink attempts to grab a link key from the cache
-	// Returns a NotFound error if the key is empty or does not exist
-	FetchLink(linkpath string) (string, error)
-
-	// DeleteLink will remove the linkpath key from the cache
-	// Returns an error only on operational errors
-	DeleteLink(linkpath string) error
-
-	// UpsertLink will insert a linkpath:dest mapping into the cache
-	// Returns an error only on operational errors
-	UpsertLink(linkpath string, dest string) error
-}
+package cache
+
+import "time"
+
+// Provider is the generic interface for interacting with an underlying cache provider
+type Provider interface {
+
+	// FetchLink attempts to grab a link entry from the cache
+	// Returns ErrNotFound if the key is empty, does not exist or has passed its TTL
+	// An entry past its own ExpireAt can still be returned, see Entry.Expired
+	FetchLink(linkpath string) (*Entry, error)
+
+	// DeleteLink will remove the linkpath key from the cache
+	// Returns an error only on operational errors
+	DeleteLink(linkpath string) error
+
+	// UpsertLink will insert a linkpath:entry mapping into the cache, which is dropped after ttl
+	// A ttl of 0 keeps the entry until it is deleted or evicted
+	// Returns an error only on operational errors
+	UpsertLink(linkpath string, entry *Entry, ttl time.Duration) error
+
+	// ScanLinks calls fn with the linkpath of every key in the cache, in no particular order
+	// Stops at and returns the first error returned by fn
+	ScanLinks(fn func(linkpath string) error) error
+
+	// Close releases any connections or memory held by the provider
+	Close() error
+}
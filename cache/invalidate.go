@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// redisInvalidator publishes invalidations on a redis pub/sub channel
+// Messages are the publishing node's ID and the linkpath separated by a space, so a node can skip its own
+type redisInvalidator struct {
+	client  redis.UniversalClient
+	pubsub  *redis.PubSub
+	channel string
+	node    string
+	// closed is closed by Close, so Listen can tell shutting down from a lost connection
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// listenIdleTimeout is how long Listen waits for a message before pinging to check the connection
+const listenIdleTimeout = 30 * time.Second
+
+// Invalidator creates an invalidator on the given pub/sub channel, sharing the provider's connection options
+func (r *RedisProvider) Invalidator(channel string) (Invalidator, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	ri := &redisInvalidator{
+		client:  r.client,
+		pubsub:  r.client.Subscribe(channel),
+		channel: channel,
+		node:    hex.EncodeToString(id),
+		closed:  make(chan struct{}),
+	}
+	// Wait for the subscription so invalidations published right after startup are not missed
+	if _, err := ri.pubsub.Receive(); err != nil {
+		ri.pubsub.Close()
+		return nil, err
+	}
+	return ri, nil
+}
+
+func (ri *redisInvalidator) Publish(linkpath string) error {
+	return ri.client.Publish(ri.channel, ri.node+" "+linkpath).Err()
+}
+
+// Listen receives messages one at a time rather than through the go-redis channel, which drops messages when it is full
+// Messages wait on the connection while the node is busy, and if redis drops a node that falls too far behind,
+// the connection error resets L1 like any other
+func (ri *redisInvalidator) Listen(invalidate func(linkpath string), reset func()) {
+	for {
+		msg, err := ri.pubsub.ReceiveTimeout(listenIdleTimeout)
+		if err != nil {
+			select {
+			case <-ri.closed:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				// Idle, a failed ping means the connection is gone
+				if err = ri.pubsub.Ping(); err == nil {
+					continue
+				}
+			}
+			reset()
+			time.Sleep(time.Second)
+			continue
+		}
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			// Only sent again when the connection was re-established, anything published meanwhile is lost
+			if m.Kind == "subscribe" {
+				reset()
+			}
+		case *redis.Message:
+			parts := strings.SplitN(m.Payload, " ", 2)
+			if len(parts) == 2 && parts[0] != ri.node {
+				invalidate(parts[1])
+			}
+		}
+	}
+}
+
+func (ri *redisInvalidator) Close() error {
+	ri.closeOnce.Do(func() {
+		close(ri.closed)
+	})
+	return ri.pubsub.Close()
+}
+
+// MemoryBus passes invalidations between tiered providers in the same process
+// It stands in for redis pub/sub when running several nodes in one process, as the tiered provider tests do
+// Publish waits for room rather than dropping invalidations
+type MemoryBus struct {
+	mu    sync.Mutex
+	nodes map[*memoryInvalidator]bool
+}
+
+// NewMemoryBus creates an empty in-process bus
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{nodes: map[*memoryInvalidator]bool{}}
+}
+
+// Node adds a node to the bus, returning the invalidator it should be given
+func (b *MemoryBus) Node() Invalidator {
+	mi := &memoryInvalidator{
+		bus:      b,
+		messages: make(chan string, 100),
+	}
+	b.mu.Lock()
+	b.nodes[mi] = true
+	b.mu.Unlock()
+	return mi
+}
+
+// memoryInvalidator is a single node's connection to a MemoryBus
+type memoryInvalidator struct {
+	bus      *MemoryBus
+	messages chan string
+}
+
+func (mi *memoryInvalidator) Publish(linkpath string) error {
+	mi.bus.mu.Lock()
+	defer mi.bus.mu.Unlock()
+	for node := range mi.bus.nodes {
+		if node != mi {
+			node.messages <- linkpath
+		}
+	}
+	return nil
+}
+
+func (mi *memoryInvalidator) Listen(invalidate func(linkpath string), reset func()) {
+	for linkpath := range mi.messages {
+		invalidate(linkpath)
+	}
+}
+
+func (mi *memoryInvalidator) Close() error {
+	mi.bus.mu.Lock()
+	defer mi.bus.mu.Unlock()
+	if mi.bus.nodes[mi] {
+		delete(mi.bus.nodes, mi)
+		close(mi.messages)
+	}
+	return nil
+}
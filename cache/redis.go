@@ -1,56 +1,200 @@
-package cache
-
-import (
-	"errors"
-	"strconv"
-
-	"github.com/go-redis/redis/v7"
-)
-
-// RedisProvider contains the context for the cache
-type RedisProvider struct {
-	client *redis.Client
-}
-
-// NewRedisProvider creates a new redis cache provider
-func NewRedisProvider(host string, port uint16, opts interface{}) (*RedisProvider, error) {
-
-	// TODO: bring in opts
-	r := &RedisProvider{
-		client: redis.NewClient(&redis.Options{
-			Addr:     host + ":" + strconv.Itoa(int(port)),
-			Password: "", // no password set
-			DB:       0,  // use default DB
-		}),
-	}
-	_, err := r.client.Ping().Result()
-	// fmt.Printf("%s\n", res)
-	return r, err
-}
-
-// TODO: handle retry logic?
-
-// FetchLink fetches a linkpath from redis
-func (r *RedisProvider) FetchLink(linkpath string) (string, error) {
-	val, err := r.client.Get(linkpath).Result()
-	if err == redis.Nil {
-		// Key does not exist yet
-		return "", errors.New("NotFound")
-	}
-	return val, err
-}
-
-// DeleteLink deletes the linkpath key from redis
-func (r *RedisProvider) DeleteLink(linkpath string) error {
-	_, err := r.client.Del(linkpath).Result()
-	// if err == nil && val < 1 {
-	// 	return err
-	// }
-	return err
-}
-
-// UpsertLink creates or updates the linkpath key in redis
-func (r *RedisProvider) UpsertLink(linkpath string, dest string) error {
-	err := r.client.Set(linkpath, dest, 0).Err()
-	return err
-}
+package cache
+
+import (
+	"crypto/tls"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/regalias/atlas-api/util"
+)
+
+// Redis deployment modes
+const (
+	RedisSingle   = "single"
+	RedisSentinel = "sentinel"
+	RedisCluster  = "cluster"
+)
+
+// RedisOptions contains the connection options for the redis provider
+// Zero values leave the go-redis defaults in place
+type RedisOptions struct {
+	// Mode is RedisSingle, RedisSentinel or RedisCluster
+	Mode string
+	// Addrs holds the server address in single mode, or the seed addresses of the sentinels or cluster nodes
+	Addrs []string
+	// MasterName is the name of the master set monitored by the sentinels
+	MasterName string
+
+	Username string
+	Password string
+	// SentinelUsername and SentinelPassword authenticate with the sentinels themselves
+	SentinelUsername string
+	SentinelPassword string
+	// DB is the database index, cluster mode only supports 0
+	DB int
+	// KeyPrefix is prepended to every link key, so only keys with it are scanned on a shared database
+	// The entry format version follows it, e.g. atlas:v3:docs
+	KeyPrefix string
+	// TLS enables TLS when not nil
+	TLS *tls.Config
+
+	PoolSize     int
+	MinIdleConns int
+	MaxRetries   int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolTimeout  time.Duration
+}
+
+// RedisProvider contains the context for the cache
+type RedisProvider struct {
+	client redis.UniversalClient
+	// keyPrefix holds the configured prefix and the entry format
+	keyPrefix string
+}
+
+// NewRedisProvider creates a new redis cache provider and checks the connection
+func NewRedisProvider(opts *RedisOptions) (*RedisProvider, error) {
+	var client redis.UniversalClient
+	switch opts.Mode {
+	case RedisSentinel:
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       opts.MasterName,
+			SentinelAddrs:    opts.Addrs,
+			SentinelUsername: opts.SentinelUsername,
+			SentinelPassword: opts.SentinelPassword,
+			Username:         opts.Username,
+			Password:         opts.Password,
+			DB:               opts.DB,
+			TLSConfig:        opts.TLS,
+			PoolSize:         opts.PoolSize,
+			MinIdleConns:     opts.MinIdleConns,
+			MaxRetries:       opts.MaxRetries,
+			DialTimeout:      opts.DialTimeout,
+			ReadTimeout:      opts.ReadTimeout,
+			WriteTimeout:     opts.WriteTimeout,
+			PoolTimeout:      opts.PoolTimeout,
+		})
+	case RedisCluster:
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        opts.Addrs,
+			Username:     opts.Username,
+			Password:     opts.Password,
+			TLSConfig:    opts.TLS,
+			PoolSize:     opts.PoolSize,
+			MinIdleConns: opts.MinIdleConns,
+			MaxRetries:   opts.MaxRetries,
+			DialTimeout:  opts.DialTimeout,
+			ReadTimeout:  opts.ReadTimeout,
+			WriteTimeout: opts.WriteTimeout,
+			PoolTimeout:  opts.PoolTimeout,
+		})
+	default:
+		var addr string
+		if len(opts.Addrs) > 0 {
+			addr = opts.Addrs[0]
+		}
+		client = redis.NewClient(&redis.Options{
+			Addr:         addr,
+			Username:     opts.Username,
+			Password:     opts.Password,
+			DB:           opts.DB,
+			TLSConfig:    opts.TLS,
+			PoolSize:     opts.PoolSize,
+			MinIdleConns: opts.MinIdleConns,
+			MaxRetries:   opts.MaxRetries,
+			DialTimeout:  opts.DialTimeout,
+			ReadTimeout:  opts.ReadTimeout,
+			WriteTimeout: opts.WriteTimeout,
+			PoolTimeout:  opts.PoolTimeout,
+		})
+	}
+
+	r := &RedisProvider{client: client, keyPrefix: opts.KeyPrefix + entryFormat}
+	if err := r.client.Ping().Err(); err != nil {
+		r.client.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// TODO: handle retry logic?
+
+// FetchLink fetches a linkpath from redis
+func (r *RedisProvider) FetchLink(linkpath string) (*Entry, error) {
+	val, err := r.client.Get(r.key(linkpath)).Bytes()
+	if err == redis.Nil {
+		// Key does not exist yet
+		return nil, util.WrapError(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntry(val)
+}
+
+// DeleteLink deletes the linkpath key from redis
+func (r *RedisProvider) DeleteLink(linkpath string) error {
+	_, err := r.client.Del(r.key(linkpath)).Result()
+	// if err == nil && val < 1 {
+	// 	return err
+	// }
+	return err
+}
+
+// UpsertLink creates or updates the linkpath key in redis, letting redis expire it after ttl
+func (r *RedisProvider) UpsertLink(linkpath string, entry *Entry, ttl time.Duration) error {
+	val, err := encodeEntry(entry)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(r.key(linkpath), val, ttl).Err()
+}
+
+// key returns the redis key holding linkpath
+func (r *RedisProvider) key(linkpath string) string {
+	return r.keyPrefix + linkpath
+}
+
+// ScanLinks walks the keys starting with the key prefix with SCAN, so keys written during the scan may be missed
+// In cluster mode every master is scanned, with calls to fn serialised
+func (r *RedisProvider) ScanLinks(fn func(linkpath string) error) error {
+	match := globEscaper.Replace(r.keyPrefix) + "*"
+	strip := func(key string) error {
+		return fn(strings.TrimPrefix(key, r.keyPrefix))
+	}
+	cc, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return scanKeys(r.client, match, strip)
+	}
+	var mu sync.Mutex
+	return cc.ForEachMaster(func(master *redis.Client) error {
+		return scanKeys(master, match, func(key string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return strip(key)
+		})
+	})
+}
+
+// globEscaper escapes the characters SCAN MATCH patterns treat specially
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// scanKeys walks the keys of a single redis server matching the pattern
+func scanKeys(client redis.Cmdable, match string, fn func(key string) error) error {
+	iter := client.Scan(0, match, 500).Iterator()
+	for iter.Next() {
+		if err := fn(iter.Val()); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// Close closes the redis client and its connection pool
+func (r *RedisProvider) Close() error {
+	return r.client.Close()
+}
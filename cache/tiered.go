@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Invalidator passes L1 invalidations between the nodes sharing an L2 cache
+type Invalidator interface {
+	// Publish tells every other node to drop linkpath from its L1 cache
+	Publish(linkpath string) error
+
+	// Listen calls invalidate for every linkpath published by another node until Close is called
+	// reset is called whenever invalidations may have been missed, e.g. after reconnecting
+	Listen(invalidate func(linkpath string), reset func())
+
+	// Close stops listening and releases the connection
+	Close() error
+}
+
+// generationStripes is how many generation counters the linkpaths are spread over
+const generationStripes = 256
+
+// TieredProvider reads through an in-process L1 cache in front of a shared L2 cache
+// Writes go to both tiers and are published so other nodes drop their L1 copy
+// An L1 entry can only outlive a missed invalidation until it expires
+type TieredProvider struct {
+	l1  *LocalProvider
+	l2  Provider
+	bus Invalidator
+
+	// mu guards every L1 change along with the generations, which count the L1 changes of the linkpaths in each stripe
+	// A read only keeps what it found in L2 if the generation is unchanged, so it can't undo an invalidation that arrived meanwhile
+	mu          sync.Mutex
+	generations [generationStripes]uint64
+}
+
+// NewTieredProvider creates a two tier cache and starts listening for invalidations from other nodes
+func NewTieredProvider(l1 *LocalProvider, l2 Provider, bus Invalidator) *TieredProvider {
+	t := &TieredProvider{
+		l1:  l1,
+		l2:  l2,
+		bus: bus,
+	}
+	go bus.Listen(func(linkpath string) {
+		t.changeL1(linkpath, func() error {
+			return l1.DeleteLink(linkpath)
+		})
+	}, t.resetL1)
+	return t
+}
+
+// FetchLink reads from L1, falling through to L2 and keeping the result in L1 on a hit
+// The L1 copy lasts until the entry's ExpireAt, as the TTL left in L2 is not known
+func (t *TieredProvider) FetchLink(linkpath string) (*Entry, error) {
+	if entry, err := t.l1.FetchLink(linkpath); err == nil {
+		return entry, nil
+	}
+	stripe := generationStripe(linkpath)
+	t.mu.Lock()
+	gen := t.generations[stripe]
+	t.mu.Unlock()
+
+	entry, err := t.l2.FetchLink(linkpath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Best effort, the next read falls through again
+	t.mu.Lock()
+	if t.generations[stripe] == gen {
+		t.l1.UpsertLink(linkpath, entry, entry.remaining())
+	}
+	t.mu.Unlock()
+	return entry, nil
+}
+
+// DeleteLink removes the linkpath from both tiers and invalidates it on every other node
+func (t *TieredProvider) DeleteLink(linkpath string) error {
+	if err := t.l2.DeleteLink(linkpath); err != nil {
+		return err
+	}
+	if err := t.changeL1(linkpath, func() error {
+		return t.l1.DeleteLink(linkpath)
+	}); err != nil {
+		return err
+	}
+	return t.bus.Publish(linkpath)
+}
+
+// UpsertLink writes the linkpath to both tiers and invalidates it on every other node
+func (t *TieredProvider) UpsertLink(linkpath string, entry *Entry, ttl time.Duration) error {
+	if err := t.warmLink(linkpath, entry, ttl); err != nil {
+		return err
+	}
+	return t.bus.Publish(linkpath)
+}
+
+// warmLink writes the linkpath to both tiers without invalidating it on other nodes
+// Used while warming up, when the entry matches the database and so can't be newer than another node's copy
+func (t *TieredProvider) warmLink(linkpath string, entry *Entry, ttl time.Duration) error {
+	if err := t.l2.UpsertLink(linkpath, entry, ttl); err != nil {
+		return err
+	}
+	return t.changeL1(linkpath, func() error {
+		return t.l1.UpsertLink(linkpath, entry, ttl)
+	})
+}
+
+// changeL1 applies a change to the L1 copy of linkpath, moving its generation on so reads already in flight don't keep theirs
+func (t *TieredProvider) changeL1(linkpath string, change func() error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.generations[generationStripe(linkpath)]++
+	return change()
+}
+
+// resetL1 empties L1 when invalidations may have been missed, moving every generation on
+func (t *TieredProvider) resetL1() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.generations {
+		t.generations[i]++
+	}
+	t.l1.Reset()
+}
+
+// generationStripe picks the generation counter of a linkpath
+func generationStripe(linkpath string) int {
+	h := fnv.New32a()
+	h.Write([]byte(linkpath))
+	return int(h.Sum32() % generationStripes)
+}
+
+// ScanLinks walks the keys of the shared L2 cache
+func (t *TieredProvider) ScanLinks(fn func(linkpath string) error) error {
+	return t.l2.ScanLinks(fn)
+}
+
+// Close stops listening for invalidations and closes both tiers
+func (t *TieredProvider) Close() error {
+	var firstErr error
+	for _, closer := range []func() error{t.bus.Close, t.l1.Close, t.l2.Close} {
+		if err := closer(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
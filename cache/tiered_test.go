@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestNodes builds n tiered providers sharing one L2 cache over a MemoryBus
+func newTestNodes(t *testing.T, l2 Provider, n int) []*TieredProvider {
+	t.Helper()
+	bus := NewMemoryBus()
+	nodes := make([]*TieredProvider, n)
+	for i := range nodes {
+		l1, err := NewLocalProvider(60)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = NewTieredProvider(l1, l2, bus.Node())
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.bus.Close()
+			node.l1.Close()
+		}
+	})
+	return nodes
+}
+
+// waitForL1 polls the node's L1 cache until check passes or a second has gone by
+func waitForL1(t *testing.T, node *TieredProvider, linkpath string, check func(*Entry, error) bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !check(node.l1.FetchLink(linkpath)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("L1 copy of %s did not change", linkpath)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTieredInvalidatesOtherNodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(node *TieredProvider) error
+	}{
+		{
+			name: "upsert",
+			write: func(node *TieredProvider) error {
+				return node.UpsertLink("docs", &Entry{Target: "https://new.example.com", Enabled: true, Version: 2}, 0)
+			},
+		},
+		{
+			name:  "delete",
+			write: func(node *TieredProvider) error { return node.DeleteLink("docs") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l2, err := NewLocalProvider(60)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l2.Close()
+			nodes := newTestNodes(t, l2, 2)
+
+			// Seeded straight into L2, as an invalidation from the seeding write could race with the read below
+			if err := l2.UpsertLink("docs", &Entry{Target: "https://old.example.com", Enabled: true, Version: 1}, 0); err != nil {
+				t.Fatal(err)
+			}
+			// The second node keeps its read in L1
+			if _, err := nodes[1].FetchLink("docs"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := nodes[1].l1.FetchLink("docs"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.write(nodes[0]); err != nil {
+				t.Fatal(err)
+			}
+			waitForL1(t, nodes[1], "docs", func(e *Entry, err error) bool { return errors.Is(err, ErrNotFound) })
+		})
+	}
+}
+
+func TestTieredWarmLinkIsNotPublished(t *testing.T) {
+	l2, err := NewLocalProvider(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	nodes := newTestNodes(t, l2, 2)
+
+	if err := l2.UpsertLink("docs", &Entry{Target: "https://example.com", Enabled: true, Version: 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nodes[1].FetchLink("docs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[0].warmLink("docs", &Entry{Target: "https://example.com", Enabled: true, Version: 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	// A MemoryBus queues invalidations before Publish returns, so one would have been handled by now
+	time.Sleep(50 * time.Millisecond)
+	if _, err := nodes[1].l1.FetchLink("docs"); err != nil {
+		t.Fatalf("warm write invalidated another node: %v", err)
+	}
+}
+
+// blockingL2 wraps a provider, calling during while FetchLink is between reading and returning
+type blockingL2 struct {
+	Provider
+	during func()
+}
+
+func (b *blockingL2) FetchLink(linkpath string) (*Entry, error) {
+	entry, err := b.Provider.FetchLink(linkpath)
+	b.during()
+	return entry, err
+}
+
+func TestTieredFetchDoesNotUndoInvalidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		invalidate func(node *TieredProvider)
+	}{
+		{
+			name: "invalidation of the same path",
+			invalidate: func(node *TieredProvider) {
+				node.changeL1("docs", func() error { return node.l1.DeleteLink("docs") })
+			},
+		},
+		{
+			name:       "reset after a lost connection",
+			invalidate: func(node *TieredProvider) { node.resetL1() },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shared, err := NewLocalProvider(60)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer shared.Close()
+			if err := shared.UpsertLink("docs", &Entry{Target: "https://old.example.com", Enabled: true, Version: 1}, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			l2 := &blockingL2{Provider: shared}
+			node := newTestNodes(t, l2, 1)[0]
+			// The invalidation lands after L2 was read but before the result is kept in L1
+			l2.during = func() { tt.invalidate(node) }
+
+			entry, err := node.FetchLink("docs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Version != 1 {
+				t.Fatalf("got version %d, want 1", entry.Version)
+			}
+			if _, err := node.l1.FetchLink("docs"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("stale read was kept in L1, err = %v", err)
+			}
+
+			// Without an invalidation the next read is kept as usual
+			l2.during = func() {}
+			if _, err := node.FetchLink("docs"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := node.l1.FetchLink("docs"); err != nil {
+				t.Fatalf("read was not kept in L1: %v", err)
+			}
+		})
+	}
+}
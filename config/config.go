@@ -0,0 +1,406 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is prepended to every flag name to form its environment variable
+const envPrefix = "ATLAS_"
+
+// Config contains the runtime configuration of the API server
+// Values are resolved in order of precedence: flags, environment variables, config file, defaults
+type Config struct {
+	LogLevel     string `json:"logLevel" yaml:"logLevel" toml:"logLevel"`
+	LogConsole   bool   `json:"logConsole" yaml:"logConsole" toml:"logConsole"`
+	ListenAddr   string `json:"listenAddr" yaml:"listenAddr" toml:"listenAddr"`
+	RedirectCode int    `json:"redirectCode" yaml:"redirectCode" toml:"redirectCode"`
+	// ShutdownTimeoutSec bounds how long in-flight requests and queued cache tasks are given on shutdown
+	ShutdownTimeoutSec int `json:"shutdownTimeoutSec" yaml:"shutdownTimeoutSec" toml:"shutdownTimeoutSec"`
+	// RequireIfMatch rejects updates and deletes that do not carry an If-Match header
+	RequireIfMatch bool `json:"requireIfMatch" yaml:"requireIfMatch" toml:"requireIfMatch"`
+	// ScheduleIntervalSec is the longest the scheduler waits between checks for link activations and expiries
+	ScheduleIntervalSec int `json:"scheduleIntervalSec" yaml:"scheduleIntervalSec" toml:"scheduleIntervalSec"`
+
+	Database  DatabaseConfig  `json:"database" yaml:"database" toml:"database"`
+	Cache     CacheConfig     `json:"cache" yaml:"cache" toml:"cache"`
+	Auth      AuthConfig      `json:"auth" yaml:"auth" toml:"auth"`
+	Analytics AnalyticsConfig `json:"analytics" yaml:"analytics" toml:"analytics"`
+	Trash     TrashConfig     `json:"trash" yaml:"trash" toml:"trash"`
+	// ShortCodes configures the paths generated for links created without a LinkPath
+	ShortCodes ShortCodeConfig `json:"shortCodes" yaml:"shortCodes" toml:"shortCodes"`
+}
+
+// DatabaseConfig contains the persistent storage options
+type DatabaseConfig struct {
+	Provider  string `json:"provider" yaml:"provider" toml:"provider"`
+	TableName string `json:"tableName" yaml:"tableName" toml:"tableName"`
+	// DSN is the data source name for the postgres and sqlite providers
+	DSN string `json:"dsn" yaml:"dsn" toml:"dsn"`
+}
+
+// CacheConfig contains the link cache options
+type CacheConfig struct {
+	Provider       string `json:"provider" yaml:"provider" toml:"provider"`
+	RedisHost      string `json:"redisHost" yaml:"redisHost" toml:"redisHost"`
+	RedisPort      int    `json:"redisPort" yaml:"redisPort" toml:"redisPort"`
+	LocalExpirySec int64  `json:"localExpirySec" yaml:"localExpirySec" toml:"localExpirySec"`
+	QueueSize      int    `json:"queueSize" yaml:"queueSize" toml:"queueSize"`
+	// EntryTTLSec caps how long a link stays cached, 0 keeps links until they change or their schedule moves on
+	EntryTTLSec int64 `json:"entryTTLSec" yaml:"entryTTLSec" toml:"entryTTLSec"`
+	// InvalidationChannel is the redis pub/sub channel the tiered provider's nodes share
+	InvalidationChannel string `json:"invalidationChannel" yaml:"invalidationChannel" toml:"invalidationChannel"`
+	// Warmup fills the cache from the database on startup
+	Warmup CacheWarmupConfig `json:"warmup" yaml:"warmup" toml:"warmup"`
+	// Reconcile repairs drift between the cache and the database
+	Reconcile CacheReconcileConfig `json:"reconcile" yaml:"reconcile" toml:"reconcile"`
+	// Redis holds the connection options of the redis and tiered providers besides the host and port
+	Redis RedisConfig `json:"redis" yaml:"redis" toml:"redis"`
+}
+
+// RedisConfig contains the redis connection options
+// Zero values for the pool, retry and timeout options keep the client library defaults
+type RedisConfig struct {
+	// Mode is single, sentinel or cluster
+	Mode string `json:"mode" yaml:"mode" toml:"mode"`
+	// Addrs lists host:port seed addresses of the sentinels or cluster nodes, single mode uses redisHost and redisPort
+	Addrs      []string `json:"addrs" yaml:"addrs" toml:"addrs"`
+	MasterName string   `json:"masterName" yaml:"masterName" toml:"masterName"`
+	// Username selects a redis 6 ACL user, leave empty for password only auth
+	Username         string `json:"username" yaml:"username" toml:"username"`
+	Password         string `json:"password" yaml:"password" toml:"password"`
+	SentinelUsername string `json:"sentinelUsername" yaml:"sentinelUsername" toml:"sentinelUsername"`
+	SentinelPassword string `json:"sentinelPassword" yaml:"sentinelPassword" toml:"sentinelPassword"`
+	DB               int    `json:"db" yaml:"db" toml:"db"`
+	// KeyPrefix is prepended to every cached link key, keep it distinct from other users of the database
+	KeyPrefix    string         `json:"keyPrefix" yaml:"keyPrefix" toml:"keyPrefix"`
+	TLS          RedisTLSConfig `json:"tls" yaml:"tls" toml:"tls"`
+	PoolSize     int            `json:"poolSize" yaml:"poolSize" toml:"poolSize"`
+	MinIdleConns int            `json:"minIdleConns" yaml:"minIdleConns" toml:"minIdleConns"`
+	// MaxRetries of -1 disables retries
+	MaxRetries     int `json:"maxRetries" yaml:"maxRetries" toml:"maxRetries"`
+	DialTimeoutMs  int `json:"dialTimeoutMs" yaml:"dialTimeoutMs" toml:"dialTimeoutMs"`
+	ReadTimeoutMs  int `json:"readTimeoutMs" yaml:"readTimeoutMs" toml:"readTimeoutMs"`
+	WriteTimeoutMs int `json:"writeTimeoutMs" yaml:"writeTimeoutMs" toml:"writeTimeoutMs"`
+	PoolTimeoutMs  int `json:"poolTimeoutMs" yaml:"poolTimeoutMs" toml:"poolTimeoutMs"`
+}
+
+// RedisTLSConfig contains the redis TLS options
+// The system roots verify the server unless CAFile is set, and CertFile and KeyFile enable client certificates
+type RedisTLSConfig struct {
+	Enabled    bool   `json:"enabled" yaml:"enabled" toml:"enabled"`
+	CAFile     string `json:"caFile" yaml:"caFile" toml:"caFile"`
+	CertFile   string `json:"certFile" yaml:"certFile" toml:"certFile"`
+	KeyFile    string `json:"keyFile" yaml:"keyFile" toml:"keyFile"`
+	ServerName string `json:"serverName" yaml:"serverName" toml:"serverName"`
+	// InsecureSkipVerify disables server certificate verification, only for testing
+	InsecureSkipVerify bool `json:"insecureSkipVerify" yaml:"insecureSkipVerify" toml:"insecureSkipVerify"`
+}
+
+// CacheWarmupConfig contains the startup cache warm-up options
+type CacheWarmupConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Concurrency is the number of links written to the cache at once
+	Concurrency int `json:"concurrency" yaml:"concurrency" toml:"concurrency"`
+	// PageSize is the number of links read from the database at once
+	PageSize int `json:"pageSize" yaml:"pageSize" toml:"pageSize"`
+	// BlockReadiness keeps the readiness probe failing until the warm-up is done
+	BlockReadiness bool `json:"blockReadiness" yaml:"blockReadiness" toml:"blockReadiness"`
+}
+
+// AuthConfig contains the authentication and authorization options for the API
+// API keys and role bindings can only be supplied through the config file
+type AuthConfig struct {
+	Enabled bool           `json:"enabled" yaml:"enabled" toml:"enabled"`
+	APIKeys []APIKeyConfig `json:"apiKeys" yaml:"apiKeys" toml:"apiKeys"`
+	JWT     JWTConfig      `json:"jwt" yaml:"jwt" toml:"jwt"`
+	// RoleBindings maps a principal subject to a role: viewer, editor or admin
+	RoleBindings map[string]string `json:"roleBindings" yaml:"roleBindings" toml:"roleBindings"`
+	// DefaultRole is granted to authenticated principals without a higher role
+	DefaultRole string `json:"defaultRole" yaml:"defaultRole" toml:"defaultRole"`
+}
+
+// APIKeyConfig maps a static API key to the subject and role it authenticates as
+type APIKeyConfig struct {
+	Key     string `json:"key" yaml:"key" toml:"key"`
+	Subject string `json:"subject" yaml:"subject" toml:"subject"`
+	Role    string `json:"role" yaml:"role" toml:"role"`
+}
+
+// JWTConfig contains the bearer token verification options
+// JWT authentication is disabled when JWKSFile is empty
+type JWTConfig struct {
+	JWKSFile     string `json:"jwksFile" yaml:"jwksFile" toml:"jwksFile"`
+	Issuer       string `json:"issuer" yaml:"issuer" toml:"issuer"`
+	Audience     string `json:"audience" yaml:"audience" toml:"audience"`
+	SubjectClaim string `json:"subjectClaim" yaml:"subjectClaim" toml:"subjectClaim"`
+	// RolesClaim names a string or string array claim holding role names, ignored when empty
+	RolesClaim string `json:"rolesClaim" yaml:"rolesClaim" toml:"rolesClaim"`
+	// ClockSkewSec is the allowance for clock differences when checking token expiry, at most 300
+	ClockSkewSec int `json:"clockSkewSec" yaml:"clockSkewSec" toml:"clockSkewSec"`
+}
+
+// AnalyticsConfig contains the click analytics options
+type AnalyticsConfig struct {
+	Enabled  bool   `json:"enabled" yaml:"enabled" toml:"enabled"`
+	Provider string `json:"provider" yaml:"provider" toml:"provider"`
+	// DSN is the data source name for the postgres and sqlite providers
+	DSN              string `json:"dsn" yaml:"dsn" toml:"dsn"`
+	QueueSize        int    `json:"queueSize" yaml:"queueSize" toml:"queueSize"`
+	BatchSize        int    `json:"batchSize" yaml:"batchSize" toml:"batchSize"`
+	FlushIntervalSec int    `json:"flushIntervalSec" yaml:"flushIntervalSec" toml:"flushIntervalSec"`
+	// RetentionDays is how long raw click events are kept, 0 keeps them forever
+	// The hourly and daily counters are kept regardless
+	RetentionDays int `json:"retentionDays" yaml:"retentionDays" toml:"retentionDays"`
+}
+
+// TrashConfig contains the soft delete options
+// When disabled, deleting a link removes it permanently
+type TrashConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// RetentionHours is how long deleted links can be restored before the reaper purges them
+	RetentionHours  int `json:"retentionHours" yaml:"retentionHours" toml:"retentionHours"`
+	ReapIntervalSec int `json:"reapIntervalSec" yaml:"reapIntervalSec" toml:"reapIntervalSec"`
+	// ReusePaths lets new links take the path of a link in the trash, purging it
+	ReusePaths bool `json:"reusePaths" yaml:"reusePaths" toml:"reusePaths"`
+}
+
+// ShortCodeConfig contains the options for generating link paths
+// The reserved word list can only be supplied through the config file
+type ShortCodeConfig struct {
+	// Alphabet holds the letters and digits codes are drawn from
+	Alphabet string `json:"alphabet" yaml:"alphabet" toml:"alphabet"`
+	Length   int    `json:"length" yaml:"length" toml:"length"`
+	// Attempts is how many codes are drawn before giving up when they are taken or blocked
+	Attempts int `json:"attempts" yaml:"attempts" toml:"attempts"`
+	// Reserved lists words that generated codes may not contain, and chosen paths may not be, ignoring case
+	Reserved []string `json:"reserved" yaml:"reserved" toml:"reserved"`
+}
+
+// CacheReconcileConfig contains the cache reconciliation options
+// Reconciliation can always be triggered through the API, even when it does not run periodically
+type CacheReconcileConfig struct {
+	// IntervalSec is the time between reconciliations, 0 disables periodic runs
+	IntervalSec int `json:"intervalSec" yaml:"intervalSec" toml:"intervalSec"`
+	// RemoveOrphans deletes cached keys under the key prefix without a link or alias
+	RemoveOrphans bool `json:"removeOrphans" yaml:"removeOrphans" toml:"removeOrphans"`
+}
+
+// Default returns the configuration used when nothing else is specified
+func Default() *Config {
+	return &Config{
+		LogLevel:     "info",
+		LogConsole:   false,
+		ListenAddr:   ":8081",
+		RedirectCode: 302,
+
+		ShutdownTimeoutSec:  30,
+		ScheduleIntervalSec: 60,
+		Database: DatabaseConfig{
+			Provider:  "dynamodb",
+			TableName: "atlas-table-main",
+		},
+		Cache: CacheConfig{
+			Provider:       "redis",
+			RedisHost:      "127.0.0.1",
+			RedisPort:      6379,
+			LocalExpirySec: 600,
+			QueueSize:      100,
+			// Shared by every node of a deployment, change it to run several deployments on one redis
+			InvalidationChannel: "atlas-invalidate",
+			Warmup: CacheWarmupConfig{
+				Enabled:     false,
+				Concurrency: 8,
+				PageSize:    500,
+			},
+			Reconcile: CacheReconcileConfig{
+				IntervalSec:   0,
+				RemoveOrphans: false,
+			},
+			Redis: RedisConfig{
+				Mode:      "single",
+				KeyPrefix: "atlas:",
+			},
+		},
+		Auth: AuthConfig{
+			Enabled:     false,
+			DefaultRole: "viewer",
+			JWT: JWTConfig{
+				SubjectClaim: "sub",
+				ClockSkewSec: 60,
+			},
+		},
+		Analytics: AnalyticsConfig{
+			Enabled:          true,
+			Provider:         "memory",
+			QueueSize:        10000,
+			BatchSize:        500,
+			FlushIntervalSec: 5,
+			RetentionDays:    90,
+		},
+		Trash: TrashConfig{
+			Enabled:         false,
+			RetentionHours:  720,
+			ReapIntervalSec: 3600,
+			ReusePaths:      false,
+		},
+		ShortCodes: ShortCodeConfig{
+			Alphabet: "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			Length:   7,
+			Attempts: 5,
+		},
+	}
+}
+
+// bindFlags registers a flag for every scalar option, bound to the supplied config
+// The flag name is also used to derive the environment variable name
+func bindFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level: debug, info, warn or error")
+	fs.BoolVar(&c.LogConsole, "log-console", c.LogConsole, "write human readable logs instead of JSON")
+	fs.StringVar(&c.ListenAddr, "listen-addr", c.ListenAddr, "address for the HTTP server to listen on")
+	fs.IntVar(&c.RedirectCode, "redirect-code", c.RedirectCode, "status code used for link redirects: 301, 302, 307 or 308")
+	fs.BoolVar(&c.RequireIfMatch, "require-if-match", c.RequireIfMatch, "reject link updates and deletes without an If-Match header")
+	fs.IntVar(&c.ScheduleIntervalSec, "schedule-interval", c.ScheduleIntervalSec, "maximum seconds between checks for scheduled link activations and expiries")
+	fs.IntVar(&c.ShutdownTimeoutSec, "shutdown-timeout", c.ShutdownTimeoutSec, "seconds to wait for requests and cache tasks to finish on shutdown")
+
+	fs.StringVar(&c.Database.Provider, "db-provider", c.Database.Provider, "database provider: dynamodb, postgres, sqlite or memory")
+	fs.StringVar(&c.Database.TableName, "db-table", c.Database.TableName, "DynamoDB table name")
+	fs.StringVar(&c.Database.DSN, "db-dsn", c.Database.DSN, "data source name for the postgres and sqlite providers")
+
+	fs.StringVar(&c.Cache.Provider, "cache-provider", c.Cache.Provider, "cache provider: redis, local or tiered")
+	fs.StringVar(&c.Cache.RedisHost, "redis-host", c.Cache.RedisHost, "redis server host")
+	fs.IntVar(&c.Cache.RedisPort, "redis-port", c.Cache.RedisPort, "redis server port")
+	fs.StringVar(&c.Cache.Redis.Mode, "redis-mode", c.Cache.Redis.Mode, "redis deployment: single, sentinel or cluster")
+	fs.Var((*stringList)(&c.Cache.Redis.Addrs), "redis-addrs", "comma separated host:port addresses of the redis sentinels or cluster nodes")
+	fs.StringVar(&c.Cache.Redis.MasterName, "redis-master-name", c.Cache.Redis.MasterName, "redis sentinel master set name")
+	fs.StringVar(&c.Cache.Redis.Username, "redis-username", c.Cache.Redis.Username, "redis ACL username")
+	fs.StringVar(&c.Cache.Redis.Password, "redis-password", c.Cache.Redis.Password, "redis password")
+	fs.StringVar(&c.Cache.Redis.SentinelUsername, "redis-sentinel-username", c.Cache.Redis.SentinelUsername, "redis sentinel ACL username")
+	fs.StringVar(&c.Cache.Redis.SentinelPassword, "redis-sentinel-password", c.Cache.Redis.SentinelPassword, "redis sentinel password")
+	fs.IntVar(&c.Cache.Redis.DB, "redis-db", c.Cache.Redis.DB, "redis database index")
+	fs.StringVar(&c.Cache.Redis.KeyPrefix, "redis-key-prefix", c.Cache.Redis.KeyPrefix, "prefix of every cached link key in redis")
+	fs.BoolVar(&c.Cache.Redis.TLS.Enabled, "redis-tls", c.Cache.Redis.TLS.Enabled, "connect to redis over TLS")
+	fs.StringVar(&c.Cache.Redis.TLS.CAFile, "redis-tls-ca-file", c.Cache.Redis.TLS.CAFile, "PEM file with the CA certificates trusted for redis")
+	fs.StringVar(&c.Cache.Redis.TLS.CertFile, "redis-tls-cert-file", c.Cache.Redis.TLS.CertFile, "PEM client certificate for redis")
+	fs.StringVar(&c.Cache.Redis.TLS.KeyFile, "redis-tls-key-file", c.Cache.Redis.TLS.KeyFile, "PEM client key for redis")
+	fs.StringVar(&c.Cache.Redis.TLS.ServerName, "redis-tls-server-name", c.Cache.Redis.TLS.ServerName, "server name expected in the redis certificate")
+	fs.BoolVar(&c.Cache.Redis.TLS.InsecureSkipVerify, "redis-tls-insecure-skip-verify", c.Cache.Redis.TLS.InsecureSkipVerify, "skip verification of the redis certificate, for testing only")
+	fs.IntVar(&c.Cache.Redis.PoolSize, "redis-pool-size", c.Cache.Redis.PoolSize, "maximum redis connections per node, 0 for the library default")
+	fs.IntVar(&c.Cache.Redis.MinIdleConns, "redis-min-idle-conns", c.Cache.Redis.MinIdleConns, "redis connections kept open while idle")
+	fs.IntVar(&c.Cache.Redis.MaxRetries, "redis-max-retries", c.Cache.Redis.MaxRetries, "retries of failed redis commands, -1 to disable")
+	fs.IntVar(&c.Cache.Redis.DialTimeoutMs, "redis-dial-timeout-ms", c.Cache.Redis.DialTimeoutMs, "redis connect timeout in milliseconds")
+	fs.IntVar(&c.Cache.Redis.ReadTimeoutMs, "redis-read-timeout-ms", c.Cache.Redis.ReadTimeoutMs, "redis read timeout in milliseconds")
+	fs.IntVar(&c.Cache.Redis.WriteTimeoutMs, "redis-write-timeout-ms", c.Cache.Redis.WriteTimeoutMs, "redis write timeout in milliseconds")
+	fs.IntVar(&c.Cache.Redis.PoolTimeoutMs, "redis-pool-timeout-ms", c.Cache.Redis.PoolTimeoutMs, "time to wait for a free redis connection in milliseconds")
+	fs.StringVar(&c.Cache.InvalidationChannel, "cache-invalidation-channel", c.Cache.InvalidationChannel, "redis pub/sub channel used to invalidate the local tier of the tiered cache")
+	fs.Int64Var(&c.Cache.LocalExpirySec, "cache-local-expiry", c.Cache.LocalExpirySec, "local cache entry lifetime in seconds")
+	fs.IntVar(&c.Cache.QueueSize, "cache-queue-size", c.Cache.QueueSize, "size of the async cache task queue")
+	fs.Int64Var(&c.Cache.EntryTTLSec, "cache-entry-ttl", c.Cache.EntryTTLSec, "maximum lifetime of a cached link in seconds, 0 for no limit")
+	fs.BoolVar(&c.Cache.Warmup.Enabled, "cache-warmup", c.Cache.Warmup.Enabled, "fill the cache with every enabled link on startup")
+	fs.IntVar(&c.Cache.Warmup.Concurrency, "cache-warmup-concurrency", c.Cache.Warmup.Concurrency, "number of links written to the cache at once during warm-up")
+	fs.IntVar(&c.Cache.Warmup.PageSize, "cache-warmup-page-size", c.Cache.Warmup.PageSize, "number of links read from the database at once during warm-up")
+	fs.IntVar(&c.Cache.Reconcile.IntervalSec, "cache-reconcile-interval", c.Cache.Reconcile.IntervalSec, "seconds between cache reconciliations with the database, 0 to only run on demand")
+	fs.BoolVar(&c.Cache.Reconcile.RemoveOrphans, "cache-reconcile-remove-orphans", c.Cache.Reconcile.RemoveOrphans, "delete cached keys without a link or alias when reconciling")
+	fs.BoolVar(&c.Cache.Warmup.BlockReadiness, "cache-warmup-block-ready", c.Cache.Warmup.BlockReadiness, "report not ready until the cache warm-up is done")
+
+	fs.BoolVar(&c.Auth.Enabled, "auth-enabled", c.Auth.Enabled, "require authentication and enforce roles on API endpoints")
+	fs.StringVar(&c.Auth.DefaultRole, "auth-default-role", c.Auth.DefaultRole, "role granted to authenticated users without a binding: none, viewer, editor or admin")
+	fs.StringVar(&c.Auth.JWT.JWKSFile, "auth-jwks-file", c.Auth.JWT.JWKSFile, "local JWKS file with HS256/RS256 keys for bearer tokens")
+	fs.StringVar(&c.Auth.JWT.Issuer, "auth-jwt-issuer", c.Auth.JWT.Issuer, "required bearer token issuer")
+	fs.StringVar(&c.Auth.JWT.Audience, "auth-jwt-audience", c.Auth.JWT.Audience, "required bearer token audience")
+	fs.StringVar(&c.Auth.JWT.SubjectClaim, "auth-jwt-subject-claim", c.Auth.JWT.SubjectClaim, "bearer token claim used as the user identity")
+	fs.StringVar(&c.Auth.JWT.RolesClaim, "auth-jwt-roles-claim", c.Auth.JWT.RolesClaim, "bearer token claim holding role names")
+	fs.IntVar(&c.Auth.JWT.ClockSkewSec, "auth-jwt-clock-skew", c.Auth.JWT.ClockSkewSec, "seconds of clock difference allowed when checking bearer token times, at most 300")
+
+	fs.BoolVar(&c.Analytics.Enabled, "analytics-enabled", c.Analytics.Enabled, "record click events for redirects")
+	fs.StringVar(&c.Analytics.Provider, "analytics-provider", c.Analytics.Provider, "click analytics store: memory, postgres or sqlite")
+	fs.StringVar(&c.Analytics.DSN, "analytics-dsn", c.Analytics.DSN, "data source name for the postgres and sqlite analytics stores")
+	fs.IntVar(&c.Analytics.QueueSize, "analytics-queue-size", c.Analytics.QueueSize, "size of the click event queue")
+	fs.IntVar(&c.Analytics.BatchSize, "analytics-batch-size", c.Analytics.BatchSize, "number of click events written per batch")
+	fs.IntVar(&c.Analytics.FlushIntervalSec, "analytics-flush-interval", c.Analytics.FlushIntervalSec, "seconds between flushes of partial click event batches")
+	fs.IntVar(&c.Analytics.RetentionDays, "analytics-retention-days", c.Analytics.RetentionDays, "days raw click events are kept, 0 to keep them forever")
+	fs.BoolVar(&c.Trash.Enabled, "trash-enabled", c.Trash.Enabled, "move deleted links to the trash instead of removing them")
+	fs.IntVar(&c.Trash.RetentionHours, "trash-retention-hours", c.Trash.RetentionHours, "hours deleted links can be restored for before they are purged")
+	fs.IntVar(&c.Trash.ReapIntervalSec, "trash-reap-interval", c.Trash.ReapIntervalSec, "seconds between purges of expired links from the trash")
+	fs.BoolVar(&c.Trash.ReusePaths, "trash-reuse-paths", c.Trash.ReusePaths, "allow new links to take the path of a link in the trash")
+
+	fs.StringVar(&c.ShortCodes.Alphabet, "shortcode-alphabet", c.ShortCodes.Alphabet, "letters and digits generated link paths are drawn from")
+	fs.IntVar(&c.ShortCodes.Length, "shortcode-length", c.ShortCodes.Length, "length of generated link paths")
+	fs.IntVar(&c.ShortCodes.Attempts, "shortcode-attempts", c.ShortCodes.Attempts, "generated link paths tried before giving up when they are taken")
+}
+
+// stringList is a flag holding a comma separated list
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set replaces the whole list, so a flag or environment variable overrides the config file rather than adding to it
+func (l *stringList) Set(v string) error {
+	*l = nil
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// envName converts a flag name into its environment variable name, e.g. log-level -> ATLAS_LOG_LEVEL
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// Load resolves the configuration from the command line args, environment and optional config file
+// Returns flag.ErrHelp if usage was requested
+func Load(args []string) (*Config, error) {
+
+	// Parse the command line first to find the config file and which flags were explicitly set
+	fs := flag.NewFlagSet("atlas-api", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "path to a YAML, JSON or TOML config file (env "+envPrefix+"CONFIG)")
+	bindFlags(fs, Default())
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, errors.New("unexpected arguments: " + strings.Join(fs.Args(), " "))
+	}
+
+	cfg := Default()
+	if *path != "" {
+		if err := loadFile(*path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Overlay environment variables, then explicitly set flags, through the same flag definitions
+	overlay := flag.NewFlagSet("atlas-api", flag.ContinueOnError)
+	bindFlags(overlay, cfg)
+
+	var err error
+	overlay.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok && err == nil {
+			if serr := overlay.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", v, envName(f.Name), serr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			// Already validated by the first parse
+			overlay.Set(f.Name, f.Value.String())
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
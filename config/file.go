@@ -0,0 +1,52 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// loadFile reads a config file over the supplied config, using the file extension to pick the format
+// Unknown keys are rejected so that typos do not silently fall back to defaults
+func loadFile(path string, cfg *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, cfg)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(cfg)
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), cfg)
+		if err == nil && len(md.Undecoded()) > 0 {
+			err = errors.New("unknown keys: " + keyList(md.Undecoded()))
+		}
+	default:
+		return errors.New("config file " + path + " must have a .yaml, .yml, .json or .toml extension")
+	}
+
+	if err != nil {
+		return errors.New("could not parse config file " + path + ": " + err.Error())
+	}
+	return nil
+}
+
+func keyList(keys []toml.Key) string {
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = k.String()
+	}
+	return strings.Join(s, ", ")
+}
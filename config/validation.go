@@ -0,0 +1,229 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/regalias/atlas-api/auth"
+)
+
+// Validate checks the resolved configuration for invalid or inconsistent options
+// Returns an error describing every problem found
+func (c *Config) Validate() error {
+	var problems []string
+
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, "logLevel must be one of debug, info, warn or error")
+	}
+	if c.ListenAddr == "" {
+		problems = append(problems, "listenAddr is required")
+	}
+	switch c.RedirectCode {
+	case 301, 302, 307, 308:
+	default:
+		problems = append(problems, "redirectCode must be one of 301, 302, 307 or 308, got "+strconv.Itoa(c.RedirectCode))
+	}
+	if c.ShutdownTimeoutSec < 1 {
+		problems = append(problems, "shutdownTimeoutSec must be positive")
+	}
+	if c.ScheduleIntervalSec < 1 {
+		problems = append(problems, "scheduleIntervalSec must be positive")
+	}
+
+	switch c.Database.Provider {
+	case "dynamodb":
+		if c.Database.TableName == "" {
+			problems = append(problems, "database.tableName is required for the dynamodb provider")
+		}
+	case "postgres", "sqlite":
+		if c.Database.DSN == "" {
+			problems = append(problems, "database.dsn is required for the "+c.Database.Provider+" provider")
+		}
+	case "memory":
+	default:
+		problems = append(problems, "database.provider must be dynamodb, postgres, sqlite or memory")
+	}
+
+	switch c.Cache.Provider {
+	case "redis", "tiered", "local":
+	default:
+		problems = append(problems, "cache.provider must be redis, local or tiered")
+	}
+	if c.Cache.Provider == "redis" || c.Cache.Provider == "tiered" {
+		problems = append(problems, c.Cache.validateRedis()...)
+	}
+	if c.Cache.Provider == "local" || c.Cache.Provider == "tiered" {
+		if c.Cache.LocalExpirySec < 1 {
+			problems = append(problems, "cache.localExpirySec must be positive")
+		}
+	}
+	if c.Cache.Provider == "tiered" && c.Cache.InvalidationChannel == "" {
+		problems = append(problems, "cache.invalidationChannel is required for the tiered provider")
+	}
+	if c.Cache.QueueSize < 1 {
+		problems = append(problems, "cache.queueSize must be positive")
+	}
+	if c.Cache.EntryTTLSec < 0 {
+		problems = append(problems, "cache.entryTTLSec must not be negative")
+	}
+	if c.Cache.Reconcile.RemoveOrphans && c.Cache.Provider != "local" && c.Cache.Redis.KeyPrefix == "" {
+		problems = append(problems, "cache.redis.keyPrefix is required to remove orphans, so other keys in the database are left alone")
+	}
+	if c.Cache.Reconcile.IntervalSec < 0 {
+		problems = append(problems, "cache.reconcile.intervalSec must not be negative")
+	}
+	if c.Cache.Warmup.Enabled {
+		if c.Cache.Warmup.Concurrency < 1 {
+			problems = append(problems, "cache.warmup.concurrency must be positive")
+		}
+		if c.Cache.Warmup.PageSize < 1 || c.Cache.Warmup.PageSize > 500 {
+			problems = append(problems, "cache.warmup.pageSize must be between 1 and 500")
+		}
+	}
+
+	if c.Analytics.Enabled {
+		switch c.Analytics.Provider {
+		case "postgres", "sqlite":
+			if c.Analytics.DSN == "" {
+				problems = append(problems, "analytics.dsn is required for the "+c.Analytics.Provider+" provider")
+			}
+		case "memory":
+		default:
+			problems = append(problems, "analytics.provider must be memory, postgres or sqlite")
+		}
+		if c.Analytics.QueueSize < 1 || c.Analytics.BatchSize < 1 || c.Analytics.FlushIntervalSec < 1 {
+			problems = append(problems, "analytics.queueSize, analytics.batchSize and analytics.flushIntervalSec must be positive")
+		}
+		if c.Analytics.RetentionDays < 0 {
+			problems = append(problems, "analytics.retentionDays must not be negative")
+		}
+	}
+
+	if c.Trash.Enabled && (c.Trash.RetentionHours < 1 || c.Trash.ReapIntervalSec < 1) {
+		problems = append(problems, "trash.retentionHours and trash.reapIntervalSec must be positive")
+	}
+
+	if len(c.ShortCodes.Alphabet) < 2 || strings.Trim(c.ShortCodes.Alphabet, alphanumerics) != "" {
+		problems = append(problems, "shortCodes.alphabet must only hold letters and digits, at least 2 of them")
+	} else if hasRepeats(c.ShortCodes.Alphabet) {
+		problems = append(problems, "shortCodes.alphabet must not repeat characters")
+	}
+	if c.ShortCodes.Length < 3 || c.ShortCodes.Length > 50 {
+		problems = append(problems, "shortCodes.length must be between 3 and 50")
+	}
+	if c.ShortCodes.Attempts < 1 {
+		problems = append(problems, "shortCodes.attempts must be positive")
+	}
+	for i, word := range c.ShortCodes.Reserved {
+		if word == "" {
+			problems = append(problems, "shortCodes.reserved["+strconv.Itoa(i)+"] must not be empty")
+		}
+	}
+
+	if c.Auth.Enabled {
+		if len(c.Auth.APIKeys) == 0 && c.Auth.JWT.JWKSFile == "" {
+			problems = append(problems, "auth requires at least one of auth.apiKeys or auth.jwt.jwksFile when enabled")
+		}
+		seen := map[string]bool{}
+		for i, k := range c.Auth.APIKeys {
+			if len(k.Key) < 16 || k.Subject == "" {
+				problems = append(problems, "auth.apiKeys["+strconv.Itoa(i)+"] must have a key of at least 16 characters and a subject")
+			}
+			if k.Role != "" && !isRole(k.Role) {
+				problems = append(problems, "auth.apiKeys["+strconv.Itoa(i)+"].role must be one of "+roleList)
+			}
+			if seen[k.Key] {
+				problems = append(problems, "auth.apiKeys["+strconv.Itoa(i)+"] duplicates an earlier key")
+			}
+			seen[k.Key] = true
+		}
+		if c.Auth.JWT.JWKSFile != "" && c.Auth.JWT.SubjectClaim == "" {
+			problems = append(problems, "auth.jwt.subjectClaim is required when auth.jwt.jwksFile is set")
+		}
+		if c.Auth.JWT.ClockSkewSec < 0 || c.Auth.JWT.ClockSkewSec > 300 {
+			problems = append(problems, "auth.jwt.clockSkewSec must be between 0 and 300")
+		}
+		for sub, role := range c.Auth.RoleBindings {
+			if !isRole(role) {
+				problems = append(problems, "auth.roleBindings["+sub+"] must be one of "+roleList)
+			}
+		}
+		if !isRole(c.Auth.DefaultRole) {
+			problems = append(problems, "auth.defaultRole must be one of "+roleList)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+const roleList = "none, viewer, editor or admin"
+
+func isRole(name string) bool {
+	_, err := auth.ParseRole(name)
+	return err == nil
+}
+
+// alphanumerics holds every character a short code alphabet may use
+const alphanumerics = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// hasRepeats reports whether any character appears in s more than once
+func hasRepeats(s string) bool {
+	seen := map[rune]bool{}
+	for _, r := range s {
+		if seen[r] {
+			return true
+		}
+		seen[r] = true
+	}
+	return false
+}
+
+// validateRedis checks the redis connection options
+func (cc *CacheConfig) validateRedis() []string {
+	var problems []string
+	rc := &cc.Redis
+	switch rc.Mode {
+	case "single":
+		if cc.RedisHost == "" {
+			problems = append(problems, "cache.redisHost is required for the "+cc.Provider+" provider")
+		}
+		if cc.RedisPort < 1 || cc.RedisPort > 65535 {
+			problems = append(problems, "cache.redisPort must be between 1 and 65535")
+		}
+	case "sentinel":
+		if len(rc.Addrs) == 0 || rc.MasterName == "" {
+			problems = append(problems, "cache.redis.addrs and cache.redis.masterName are required in sentinel mode")
+		}
+	case "cluster":
+		if len(rc.Addrs) == 0 {
+			problems = append(problems, "cache.redis.addrs is required in cluster mode")
+		}
+		if rc.DB != 0 {
+			problems = append(problems, "cache.redis.db must be 0 in cluster mode")
+		}
+	default:
+		problems = append(problems, "cache.redis.mode must be single, sentinel or cluster")
+	}
+
+	if rc.DB < 0 || rc.PoolSize < 0 || rc.MinIdleConns < 0 || rc.MaxRetries < -1 {
+		problems = append(problems, "cache.redis.db, poolSize and minIdleConns must not be negative, and maxRetries must be -1 or more")
+	}
+	if rc.DialTimeoutMs < 0 || rc.ReadTimeoutMs < 0 || rc.WriteTimeoutMs < 0 || rc.PoolTimeoutMs < 0 {
+		problems = append(problems, "cache.redis timeouts must not be negative")
+	}
+
+	tc := &rc.TLS
+	if !tc.Enabled && (tc.CAFile != "" || tc.CertFile != "" || tc.KeyFile != "" || tc.ServerName != "" || tc.InsecureSkipVerify) {
+		problems = append(problems, "cache.redis.tls.enabled must be set to use the other TLS options")
+	}
+	if (tc.CertFile == "") != (tc.KeyFile == "") {
+		problems = append(problems, "cache.redis.tls.certFile and cache.redis.tls.keyFile must be set together")
+	}
+	return problems
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"sort"
+	"time"
+
+	"github.com/regalias/atlas-api/models"
+)
+
+// MaxAliases is the most aliases a link may have, counting the alias a rename may leave at the old path
+// Renames move every alias in the same transaction, which keeps DynamoDB under its 25 item limit
+const MaxAliases = 20
+
+// aliasRow converts an alias into the row stored alongside links
+func aliasRow(alias *models.Alias) *models.LinkModel {
+	return &models.LinkModel{
+		LinkPath:       alias.AliasPath,
+		AliasOf:        alias.LinkPath,
+		CreatedTime:    alias.CreatedTime,
+		LastModified:   alias.CreatedTime,
+		LastModifiedBy: alias.CreatedBy,
+	}
+}
+
+// aliasFromRow converts a stored alias row back into an alias
+func aliasFromRow(lm *models.LinkModel) *models.Alias {
+	return &models.Alias{
+		AliasPath:   lm.LinkPath,
+		LinkPath:    lm.AliasOf,
+		CreatedTime: lm.CreatedTime,
+		CreatedBy:   lm.LastModifiedBy,
+	}
+}
+
+// keptAlias builds the alias left at the old path by a rename that keeps it
+func keptAlias(oldpath string, newpath string, actor string) *models.Alias {
+	return &models.Alias{
+		AliasPath:   oldpath,
+		LinkPath:    newpath,
+		CreatedTime: time.Now().Unix(),
+		CreatedBy:   actor,
+	}
+}
+
+// sortAliases orders aliases by path
+func sortAliases(aliases []*models.Alias) {
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].AliasPath < aliases[j].AliasPath })
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"strconv"
+
+	"github.com/regalias/atlas-api/models"
+)
+
+// BatchPut is a single link written by BatchPutLinks
+// Previous is the stored link being replaced as returned by BatchGetLinks, or nil when creating
+// The put is only written if the stored link is still at Previous's version, or still missing
+type BatchPut struct {
+	Link     *models.LinkModel
+	Previous *models.LinkModel
+}
+
+// BatchWriteError reports the links a batch write could not store
+// They were either changed since they were read, or could not be written at all
+// Every other link in the batch was written along with its revision
+type BatchWriteError struct {
+	Failed []string
+	Err    error
+}
+
+func (e *BatchWriteError) Error() string {
+	return strconv.Itoa(len(e.Failed)) + " links could not be written: " + e.Err.Error()
+}
+
+// Unwrap returns the last underlying error
+func (e *BatchWriteError) Unwrap() error {
+	return e.Err
+}
+
+// batchUnchanged checks that the stored link is still the one the put expects to replace
+func batchUnchanged(put *BatchPut, stored *models.LinkModel) bool {
+	if put.Previous == nil || stored == nil {
+		return put.Previous == nil && stored == nil
+	}
+	return stored.AliasOf == "" && stored.Version == put.Previous.Version
+}
+
+// batchVersion is the version a put writes, and the number of its revision
+// It follows both the stored version and the path's history, as links written before revisions have no history
+func batchVersion(stored *models.LinkModel, lastRevision int64) int64 {
+	if stored != nil && stored.Version > lastRevision {
+		return stored.Version + 1
+	}
+	return lastRevision + 1
+}
+
+// batchRevision builds the revision recording a batch put
+// Replacing a link in the trash counts as creating a new one
+func batchRevision(put *BatchPut, revision int64) *models.Revision {
+	if put.Previous == nil || put.Previous.DeletedAt != 0 {
+		return newRevision(models.RevisionCreate, nil, put.Link, revision, put.Link.LastModifiedBy)
+	}
+	return newRevision(models.RevisionUpdate, put.Previous, put.Link, revision, put.Link.LastModifiedBy)
+}
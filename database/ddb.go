@@ -1,252 +1,470 @@
-package database
-
-import (
-	"errors"
-	"strconv"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
-	"github.com/regalias/atlas-api/models"
-	"github.com/rs/zerolog"
-)
-
-// DDBProvider contains methods to interact with the dynamodb database used for persistent storage
-// Implements the database.Provider interface
-type DDBProvider struct {
-	sess      *session.Session
-	ddb       *dynamodb.DynamoDB
-	logger    *zerolog.Logger
-	tableName string
-}
-
-// NewDDB creates and configures a new DynamoDB provider
-func NewDDB(logger *zerolog.Logger, tableName string) (*DDBProvider, error) {
-	sess := newAWSSession()
-	ddb := &DDBProvider{
-		sess:      sess,
-		ddb:       dynamodb.New(sess),
-		logger:    logger,
-		tableName: tableName,
-	}
-	return ddb, nil
-}
-
-// InitDatabase attempts to ensure the database exists
-func (ddb *DDBProvider) InitDatabase() error {
-	return ddb.ensureTable()
-}
-
-// GetLinkDetails fetches the link details based on a link path
-func (ddb *DDBProvider) GetLinkDetails(linkpath string) (*models.LinkModel, error) {
-	resp, err := ddb.ddb.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String(ddb.tableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"LinkPath": {
-				S: aws.String(linkpath),
-			},
-		},
-	})
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeProvisionedThroughputExceededException:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeProvisionedThroughputExceededException + ":" + aerr.Error())
-			case dynamodb.ErrCodeResourceNotFoundException:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeResourceNotFoundException + ":" + aerr.Error())
-			case dynamodb.ErrCodeRequestLimitExceeded:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeRequestLimitExceeded + ":" + aerr.Error())
-			case dynamodb.ErrCodeInternalServerError:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeInternalServerError + ":" + aerr.Error())
-			default:
-				ddb.logger.Error().Msg(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			ddb.logger.Error().Msg(err.Error())
-		}
-		return nil, err
-	}
-
-	lm := &models.LinkModel{}
-	err = dynamodbattribute.UnmarshalMap(resp.Item, &lm)
-	if err != nil {
-		ddb.logger.Error().Msg("Failed to unmarshal Record: " + err.Error())
-		return nil, err
-	}
-
-	if lm.LinkPath == "" {
-		return nil, errors.New("NotFound")
-	}
-
-	return lm, nil
-}
-
-// CreateLink creates a new link from the supplied model
-func (ddb *DDBProvider) CreateLink(linkmodel *models.LinkModel) error {
-	link, err := dynamodbattribute.MarshalMap(*linkmodel)
-
-	if err != nil {
-		ddb.logger.Error().Msg("DDB Marshal Failed: " + err.Error())
-		return err
-	}
-
-	_, err = ddb.ddb.PutItem(&dynamodb.PutItemInput{
-		Item:                link,
-		TableName:           aws.String(ddb.tableName),
-		ConditionExpression: aws.String("attribute_not_exists(LinkPath)"), // must be unique
-	})
-
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeConditionalCheckFailedException:
-				ddb.logger.Debug().Msg(dynamodb.ErrCodeConditionalCheckFailedException + ":" + aerr.Error())
-				// Not unique
-				return errors.New("AlreadyExists")
-			case dynamodb.ErrCodeProvisionedThroughputExceededException:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeProvisionedThroughputExceededException + ":" + aerr.Error())
-			case dynamodb.ErrCodeResourceNotFoundException:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeResourceNotFoundException + ":" + aerr.Error())
-			case dynamodb.ErrCodeItemCollectionSizeLimitExceededException:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeItemCollectionSizeLimitExceededException + ":" + aerr.Error())
-			case dynamodb.ErrCodeTransactionConflictException:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeTransactionConflictException + ":" + aerr.Error())
-			case dynamodb.ErrCodeRequestLimitExceeded:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeRequestLimitExceeded + ":" + aerr.Error())
-			case dynamodb.ErrCodeInternalServerError:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeInternalServerError + ":" + aerr.Error())
-			default:
-				ddb.logger.Error().Msg("DDB PutItem Failed: " + aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			ddb.logger.Error().Msg("DDB PutItem Failed: " + err.Error())
-		}
-	}
-	return err
-}
-
-// DeleteLink deletes the link matching the link path in the supplied model
-func (ddb *DDBProvider) DeleteLink(linkpath string) error {
-	// DeleteItem is idempotent - need to specify a condition that it must exist to be successful
-	_, err := ddb.ddb.DeleteItem(&dynamodb.DeleteItemInput{
-		TableName: aws.String(ddb.tableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"LinkPath": {
-				S: aws.String(linkpath),
-			},
-		},
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":lp": {
-				S: aws.String(linkpath),
-			},
-		},
-		ConditionExpression: aws.String("LinkPath = :lp"),
-	})
-
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeConditionalCheckFailedException:
-				return errors.New("NotFound")
-			default:
-				ddb.logger.Error().Msg("DDB DeleteItem Failed: " + aerr.Error())
-				return err
-			}
-		} else {
-			ddb.logger.Error().Msg("DDB DeleteItem Failed: " + err.Error())
-		}
-	}
-	return err
-}
-
-// UpdateLink updates the existing link matching the link path in the supplied model
-func (ddb *DDBProvider) UpdateLink(linkmodel *models.LinkModel) error {
-
-	// Query the existing link to check for existance and differences
-	res, err := ddb.GetLinkDetails(linkmodel.LinkPath)
-	if err != nil {
-		return err // pass back upstream error
-	}
-
-	if models.CheckLinkModelsAreEqual(linkmodel, res) {
-		// Models are same, no changes required!
-		return errors.New("NoChange")
-	}
-
-	link := map[string]*dynamodb.AttributeValue{
-		"LinkPath": {S: aws.String(linkmodel.LinkPath)},
-	}
-
-	input := &dynamodb.UpdateItemInput{
-		ExpressionAttributeNames: map[string]*string{
-			"#CN":  aws.String("CanonicalName"),
-			"#TU":  aws.String("TargetURL"),
-			"#EN":  aws.String("Enabled"),
-			"#LM":  aws.String("LastModified"),
-			"#LMB": aws.String("LastModifiedBy"),
-		},
-		TableName:        aws.String(ddb.tableName),
-		ReturnValues:     aws.String("NONE"),
-		UpdateExpression: aws.String("set #CN = :cn, #TU = :tu, #EN = :en, #LM = :lm, #LMB = :lmb"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":lp": {
-				S: aws.String(linkmodel.LinkPath),
-			},
-			":tu": {
-				S: aws.String(linkmodel.TargetURL),
-			},
-			":cn": {
-				S: aws.String(linkmodel.CanonicalName),
-			},
-			":lm": {
-				N: aws.String(strconv.FormatInt(linkmodel.LastModified, 10)),
-			},
-			":lmb": {
-				S: aws.String(linkmodel.LastModifiedBy),
-			},
-			":en": {
-				BOOL: aws.Bool(linkmodel.Enabled),
-			},
-		},
-		ConditionExpression: aws.String("LinkPath = :lp"), // TODO: this does not quite work
-		Key:                 link,
-	}
-
-	_, err = ddb.ddb.UpdateItem(input)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeConditionalCheckFailedException:
-				ddb.logger.Debug().Msg(dynamodb.ErrCodeConditionalCheckFailedException + ":" + aerr.Error())
-				// Item does not exist - we shouldn't get here as we already checked this before
-				return errors.New("NotFound")
-			case dynamodb.ErrCodeProvisionedThroughputExceededException:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeProvisionedThroughputExceededException + ":" + aerr.Error())
-			case dynamodb.ErrCodeResourceNotFoundException:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeResourceNotFoundException + ":" + aerr.Error())
-			case dynamodb.ErrCodeItemCollectionSizeLimitExceededException:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeItemCollectionSizeLimitExceededException + ":" + aerr.Error())
-			case dynamodb.ErrCodeTransactionConflictException:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeTransactionConflictException + ":" + aerr.Error())
-			case dynamodb.ErrCodeRequestLimitExceeded:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeRequestLimitExceeded + ":" + aerr.Error())
-			case dynamodb.ErrCodeInternalServerError:
-				ddb.logger.Error().Msg(dynamodb.ErrCodeInternalServerError + ":" + aerr.Error())
-			default:
-				ddb.logger.Error().Msg(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			ddb.logger.Error().Msg(err.Error())
-		}
-		return err
-	}
-	return err
-}
+package database
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+	"github.com/rs/zerolog"
+)
+
+// DDBProvider contains methods to interact with the dynamodb database used for persistent storage
+// Implements the database.Provider interface
+type DDBProvider struct {
+	sess           *session.Session
+	ddb            *dynamodb.DynamoDB
+	logger         *zerolog.Logger
+	tableName      string
+	revisionsTable string
+}
+
+// NewDDB creates and configures a new DynamoDB provider
+// Revisions are kept in a second table named after the link table with a -revisions suffix
+func NewDDB(logger *zerolog.Logger, tableName string) (*DDBProvider, error) {
+	sess := newAWSSession()
+	ddb := &DDBProvider{
+		sess:           sess,
+		ddb:            dynamodb.New(sess),
+		logger:         logger,
+		tableName:      tableName,
+		revisionsTable: tableName + "-revisions",
+	}
+	return ddb, nil
+}
+
+// InitDatabase attempts to ensure the database exists
+func (ddb *DDBProvider) InitDatabase() error {
+	return ddb.ensureTable()
+}
+
+// GetLinkDetails fetches the link details based on a link path
+// Links in the trash and aliases are reported as not found
+func (ddb *DDBProvider) GetLinkDetails(linkpath string) (*models.LinkModel, error) {
+	lm, err := ddb.getItem(linkpath)
+	if err != nil {
+		return nil, err
+	}
+	if lm.DeletedAt != 0 || lm.AliasOf != "" {
+		return nil, ErrNotFound
+	}
+	return lm, nil
+}
+
+// getItem fetches the stored row whether it is a live link, a link in the trash or an alias
+func (ddb *DDBProvider) getItem(linkpath string) (*models.LinkModel, error) {
+	resp, err := ddb.ddb.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(ddb.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"LinkPath": {
+				S: aws.String(linkpath),
+			},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case dynamodb.ErrCodeProvisionedThroughputExceededException:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeProvisionedThroughputExceededException + ":" + aerr.Error())
+			case dynamodb.ErrCodeResourceNotFoundException:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeResourceNotFoundException + ":" + aerr.Error())
+			case dynamodb.ErrCodeRequestLimitExceeded:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeRequestLimitExceeded + ":" + aerr.Error())
+			case dynamodb.ErrCodeInternalServerError:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeInternalServerError + ":" + aerr.Error())
+			default:
+				ddb.logger.Error().Msg(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			ddb.logger.Error().Msg(err.Error())
+		}
+		return nil, err
+	}
+
+	lm := &models.LinkModel{}
+	err = dynamodbattribute.UnmarshalMap(resp.Item, &lm)
+	if err != nil {
+		ddb.logger.Error().Msg("Failed to unmarshal Record: " + err.Error())
+		return nil, err
+	}
+
+	if lm.LinkPath == "" {
+		return nil, ErrNotFound
+	}
+
+	return lm, nil
+}
+
+// CreateLink creates a new link from the supplied model
+// The link and its first revision are written in one transaction
+func (ddb *DDBProvider) CreateLink(linkmodel *models.LinkModel) error {
+	items, version, err := ddb.createItems(linkmodel, false)
+	if err != nil {
+		return err
+	}
+	_, err = ddb.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+
+	if err != nil {
+		if transactionConditionFailed(err) {
+			ddb.logger.Debug().Msg(dynamodb.ErrCodeTransactionCanceledException + ":" + err.Error())
+			// Not unique, or someone else re-created the path after we read its history
+			return util.WrapError(ErrAlreadyExists, err)
+		}
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case dynamodb.ErrCodeProvisionedThroughputExceededException:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeProvisionedThroughputExceededException + ":" + aerr.Error())
+			case dynamodb.ErrCodeResourceNotFoundException:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeResourceNotFoundException + ":" + aerr.Error())
+			case dynamodb.ErrCodeItemCollectionSizeLimitExceededException:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeItemCollectionSizeLimitExceededException + ":" + aerr.Error())
+			case dynamodb.ErrCodeTransactionConflictException:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeTransactionConflictException + ":" + aerr.Error())
+			case dynamodb.ErrCodeRequestLimitExceeded:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeRequestLimitExceeded + ":" + aerr.Error())
+			case dynamodb.ErrCodeInternalServerError:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeInternalServerError + ":" + aerr.Error())
+			default:
+				ddb.logger.Error().Msg("DDB TransactWriteItems Failed: " + aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			ddb.logger.Error().Msg("DDB TransactWriteItems Failed: " + err.Error())
+		}
+		return err
+	}
+	linkmodel.Version = version
+	return nil
+}
+
+// DeleteLink deletes the link matching the link path
+// Unless version is AnyVersion the stored link must still be at that version
+// The deleted state is read first so it can be recorded in the revision written with the delete
+func (ddb *DDBProvider) DeleteLink(linkpath string, version int64, actor string) error {
+	items, err := ddb.deleteItems(linkpath, version, actor)
+	if err != nil {
+		return err
+	}
+	_, err = ddb.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		if transactionConditionFailed(err) {
+			// Either the link is gone or it has moved on, look it up to tell which
+			if _, gerr := ddb.GetLinkDetails(linkpath); gerr != nil {
+				return gerr
+			}
+			return util.WrapError(ErrVersionMismatch, err)
+		}
+		ddb.logTransactionError(err)
+		return err
+	}
+	return nil
+}
+
+// UpdateLink updates the existing link matching the link path in the supplied model
+// The write is conditional on the version that was read, so concurrent updates can't overwrite each other
+// Unless the model carries AnyVersion its version must match the stored version
+// On success the model's Version is set to the new version
+func (ddb *DDBProvider) UpdateLink(linkmodel *models.LinkModel) error {
+	items, newVersion, err := ddb.updateItems(linkmodel)
+	if err != nil {
+		return err
+	}
+	_, err = ddb.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		if transactionConditionFailed(err) {
+			ddb.logger.Debug().Msg(dynamodb.ErrCodeTransactionCanceledException + ":" + err.Error())
+			// Item was changed or deleted after we read it
+			return util.WrapError(ErrVersionMismatch, err)
+		}
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case dynamodb.ErrCodeProvisionedThroughputExceededException:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeProvisionedThroughputExceededException + ":" + aerr.Error())
+			case dynamodb.ErrCodeResourceNotFoundException:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeResourceNotFoundException + ":" + aerr.Error())
+			case dynamodb.ErrCodeItemCollectionSizeLimitExceededException:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeItemCollectionSizeLimitExceededException + ":" + aerr.Error())
+			case dynamodb.ErrCodeTransactionConflictException:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeTransactionConflictException + ":" + aerr.Error())
+			case dynamodb.ErrCodeRequestLimitExceeded:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeRequestLimitExceeded + ":" + aerr.Error())
+			case dynamodb.ErrCodeInternalServerError:
+				ddb.logger.Error().Msg(dynamodb.ErrCodeInternalServerError + ":" + aerr.Error())
+			default:
+				ddb.logger.Error().Msg(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			ddb.logger.Error().Msg(err.Error())
+		}
+		return err
+	}
+	linkmodel.Version = newVersion
+	return err
+}
+
+// versionCondition builds the condition expression checking the Version attribute against :v
+// Links written before versioning have no Version attribute, which counts as version 0
+func versionCondition(expected int64) string {
+	if expected == 0 {
+		return "(attribute_not_exists(#V) OR #V = :v)"
+	}
+	return "#V = :v"
+}
+
+// linkItem marshals a link for a put, adding the Scheduled attribute that puts scheduled links in the schedule index
+func linkItem(lm *models.LinkModel) (map[string]*dynamodb.AttributeValue, error) {
+	item, err := dynamodbattribute.MarshalMap(lm)
+	if err != nil {
+		return nil, err
+	}
+	if lm.ActivateAt != 0 || lm.ExpireAt != 0 {
+		item["Scheduled"] = &dynamodb.AttributeValue{S: aws.String(scheduledKey)}
+	}
+	return item, nil
+}
+
+// backfillMarker is the key of the revisions table item recording that backfillScheduled has finished
+// Link paths can't start with an underscore, so it never collides with the history of a link
+var backfillMarker = map[string]*dynamodb.AttributeValue{
+	"LinkPath": {S: aws.String("_scheduled-backfill")},
+	"Revision": {N: aws.String("0")},
+}
+
+// backfillScheduled adds the links scheduled before the schedule index existed to it
+// It scans the whole table on every startup until one scan has finished and left the backfill marker
+// Links that already carry the Scheduled attribute are skipped, so an interrupted backfill is safe to run again
+func (ddb *DDBProvider) backfillScheduled() error {
+	marker, err := ddb.ddb.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(ddb.revisionsTable),
+		Key:            backfillMarker,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err == nil && len(marker.Item) > 0 {
+		return nil
+	}
+	// A failed lookup only costs a scan that finds nothing to add
+
+	names := map[string]*string{
+		"#AA": aws.String("ActivateAt"),
+		"#EA": aws.String("ExpireAt"),
+		"#SC": aws.String("Scheduled"),
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":zero": {N: aws.String("0")},
+	}
+	scheduled := "(#AA > :zero OR #EA > :zero)"
+
+	var failed error
+	count := 0
+	err = ddb.ddb.ScanPages(&dynamodb.ScanInput{
+		TableName:                 aws.String(ddb.tableName),
+		FilterExpression:          aws.String(scheduled + " AND attribute_not_exists(#SC)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ProjectionExpression:      aws.String("LinkPath"),
+	}, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			_, err := ddb.ddb.UpdateItem(&dynamodb.UpdateItemInput{
+				TableName:                aws.String(ddb.tableName),
+				Key:                      map[string]*dynamodb.AttributeValue{"LinkPath": item["LinkPath"]},
+				UpdateExpression:         aws.String("set #SC = :sc"),
+				ConditionExpression:      aws.String("attribute_exists(LinkPath) AND " + scheduled),
+				ExpressionAttributeNames: names,
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":zero": values[":zero"],
+					":sc":   {S: aws.String(scheduledKey)},
+				},
+			})
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+				// Deleted or unscheduled since the scan
+				continue
+			}
+			if err != nil {
+				failed = err
+				return false
+			}
+			count++
+		}
+		return true
+	})
+	if err == nil {
+		err = failed
+	}
+	if err != nil {
+		ddb.logger.Error().Msg("Could not add scheduled links to " + scheduleIndex + ": " + err.Error())
+		return err
+	}
+	ddb.logger.Info().Int("Links", count).Msg("Added scheduled links to " + scheduleIndex)
+
+	// Without the marker the next startup scans again, which finds nothing left to add
+	if _, err := ddb.ddb.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(ddb.revisionsTable),
+		Item:      backfillMarker,
+	}); err != nil {
+		ddb.logger.Warn().Msg("Could not record that " + scheduleIndex + " was backfilled: " + err.Error())
+	}
+	return nil
+}
+
+// aliasCountCondition builds the condition expression checking the AliasCount attribute against :ac
+// Links without aliases may have no AliasCount attribute
+func aliasCountCondition(expected int64) string {
+	if expected == 0 {
+		return "(attribute_not_exists(#AC) OR #AC = :ac)"
+	}
+	return "#AC = :ac"
+}
+
+// adoptedAliases counts the aliases left behind by links previously deleted at a path
+// Only paths with history can have any, and no alias can be added to a path without a live link
+func (ddb *DDBProvider) adoptedAliases(linkpath string, lastRevision int64) (int64, error) {
+	if lastRevision == 0 {
+		return 0, nil
+	}
+	aliases, err := ddb.verifiedAliases(linkpath)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(aliases)), nil
+}
+
+// ListLinks scans the table for links matching the filter, one page at a time
+// Scheduled links are queried from the sparse schedule index instead of scanning the table
+// The continuation token wraps the DynamoDB LastEvaluatedKey
+// At most MaxScanPerPage items are read per call, so sparse filters return short pages rather than scanning the whole table
+func (ddb *DDBProvider) ListLinks(filter *ListFilter) (*ListPage, error) {
+
+	startPath, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the filter expression from whichever filters were supplied
+	// Live links have no DeletedAt attribute, and only alias rows have AliasOf
+	conditions := []string{"attribute_not_exists(#DA)", "attribute_not_exists(#AO)"}
+	if filter.Trashed {
+		conditions[0] = "attribute_exists(#DA)"
+	}
+	names := map[string]*string{"#DA": aws.String("DeletedAt"), "#AO": aws.String("AliasOf")}
+	values := map[string]*dynamodb.AttributeValue{}
+	if filter.Enabled != nil {
+		conditions = append(conditions, "#EN = :en")
+		names["#EN"] = aws.String("Enabled")
+		values[":en"] = &dynamodb.AttributeValue{BOOL: aws.Bool(*filter.Enabled)}
+	}
+	if filter.NamePrefix != "" {
+		conditions = append(conditions, "begins_with(#CN, :cnp)")
+		names["#CN"] = aws.String("CanonicalName")
+		values[":cnp"] = &dynamodb.AttributeValue{S: aws.String(filter.NamePrefix)}
+	}
+	if filter.LastModifiedBy != "" {
+		conditions = append(conditions, "#LMB = :lmb")
+		names["#LMB"] = aws.String("LastModifiedBy")
+		values[":lmb"] = &dynamodb.AttributeValue{S: aws.String(filter.LastModifiedBy)}
+	}
+
+	// Scans and queries share the filter, the queried index also holds the LinkPath in its key
+	var fetch func(start map[string]*dynamodb.AttributeValue, limit int64) ([]map[string]*dynamodb.AttributeValue, map[string]*dynamodb.AttributeValue, int64, error)
+	var startKey map[string]*dynamodb.AttributeValue
+	if filter.Scheduled {
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(ddb.tableName),
+			IndexName:              aws.String(scheduleIndex),
+			KeyConditionExpression: aws.String("#SC = :sc"),
+			FilterExpression:       aws.String(strings.Join(conditions, " AND ")),
+		}
+		names["#SC"] = aws.String("Scheduled")
+		values[":sc"] = &dynamodb.AttributeValue{S: aws.String(scheduledKey)}
+		input.ExpressionAttributeNames = names
+		input.ExpressionAttributeValues = values
+		if startPath != "" {
+			startKey = map[string]*dynamodb.AttributeValue{
+				"LinkPath":  {S: aws.String(startPath)},
+				"Scheduled": {S: aws.String(scheduledKey)},
+			}
+		}
+		fetch = func(start map[string]*dynamodb.AttributeValue, limit int64) ([]map[string]*dynamodb.AttributeValue, map[string]*dynamodb.AttributeValue, int64, error) {
+			input.ExclusiveStartKey = start
+			input.Limit = aws.Int64(limit)
+			resp, err := ddb.ddb.Query(input)
+			if err != nil {
+				ddb.logQueryError(err)
+				return nil, nil, 0, err
+			}
+			return resp.Items, resp.LastEvaluatedKey, aws.Int64Value(resp.ScannedCount), nil
+		}
+	} else {
+		input := &dynamodb.ScanInput{
+			TableName:                aws.String(ddb.tableName),
+			FilterExpression:         aws.String(strings.Join(conditions, " AND ")),
+			ExpressionAttributeNames: names,
+		}
+		if len(values) > 0 {
+			input.ExpressionAttributeValues = values
+		}
+		if startPath != "" {
+			startKey = map[string]*dynamodb.AttributeValue{
+				"LinkPath": {S: aws.String(startPath)},
+			}
+		}
+		fetch = func(start map[string]*dynamodb.AttributeValue, limit int64) ([]map[string]*dynamodb.AttributeValue, map[string]*dynamodb.AttributeValue, int64, error) {
+			input.ExclusiveStartKey = start
+			input.Limit = aws.Int64(limit)
+			resp, err := ddb.ddb.Scan(input)
+			if err != nil {
+				if aerr, ok := err.(awserr.Error); ok {
+					ddb.logger.Error().Msg("DDB Scan Failed: " + aerr.Code() + ":" + aerr.Error())
+				} else {
+					ddb.logger.Error().Msg("DDB Scan Failed: " + err.Error())
+				}
+				return nil, nil, 0, err
+			}
+			return resp.Items, resp.LastEvaluatedKey, aws.Int64Value(resp.ScannedCount), nil
+		}
+	}
+
+	// Limit is applied before the filter expression, so keep scanning until the page is full or the scan budget is spent
+	// Each scan only evaluates as many items as are still missing, keeping LastEvaluatedKey aligned with the page
+	limit := filter.pageSize()
+	page := &ListPage{Links: make([]*models.LinkModel, 0, limit)}
+	var scanned int64
+	for {
+		want := limit - int64(len(page.Links))
+		if budget := MaxScanPerPage - scanned; want > budget {
+			want = budget
+		}
+
+		items, lastKey, count, err := fetch(startKey, want)
+		if err != nil {
+			return nil, err
+		}
+
+		var links []*models.LinkModel
+		if err := dynamodbattribute.UnmarshalListOfMaps(items, &links); err != nil {
+			ddb.logger.Error().Msg("Failed to unmarshal Records: " + err.Error())
+			return nil, err
+		}
+		page.Links = append(page.Links, links...)
+		scanned += count
+
+		if len(lastKey) == 0 {
+			// Reached the end of the table
+			page.NextCursor = ""
+			return page, nil
+		}
+		page.NextCursor = encodeCursor(aws.StringValue(lastKey["LinkPath"].S))
+		if int64(len(page.Links)) >= limit || scanned >= MaxScanPerPage {
+			return page, nil
+		}
+		startKey = lastKey
+	}
+}
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+)
+
+// CreateAlias adds an alias row for a live link
+// The link's AliasCount is incremented under the MaxAliases limit in the same transaction as the put of the alias row
+func (ddb *DDBProvider) CreateAlias(alias *models.Alias, purgeTrashed bool) error {
+	item, err := dynamodbattribute.MarshalMap(aliasRow(alias))
+	if err != nil {
+		ddb.logger.Error().Msg("DDB Marshal Failed: " + err.Error())
+		return err
+	}
+
+	_, err = ddb.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(ddb.tableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						"LinkPath": {S: aws.String(alias.LinkPath)},
+					},
+					UpdateExpression: aws.String("add #AC :one"),
+					// Must be a live link, not an alias or a link in the trash, with room for another alias
+					ConditionExpression: aws.String("attribute_exists(LinkPath) AND attribute_not_exists(#DA) AND attribute_not_exists(#AO) AND (attribute_not_exists(#AC) OR #AC < :max)"),
+					ExpressionAttributeNames: map[string]*string{
+						"#DA": aws.String("DeletedAt"),
+						"#AO": aws.String("AliasOf"),
+						"#AC": aws.String("AliasCount"),
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":one": {N: aws.String("1")},
+						":max": {N: aws.String(strconv.Itoa(MaxAliases))},
+					},
+				},
+			},
+			{Put: claimPut(&dynamodb.Put{Item: item, TableName: aws.String(ddb.tableName)}, purgeTrashed)},
+		},
+	})
+	if err != nil {
+		if item, ok := cancelledItem(err); ok {
+			ddb.logger.Debug().Msg(dynamodb.ErrCodeTransactionCanceledException + ":" + err.Error())
+			if item == 1 {
+				return util.WrapError(ErrAlreadyExists, err)
+			}
+			// Either the link is gone or it is full
+			if _, gerr := ddb.GetLinkDetails(alias.LinkPath); gerr != nil {
+				return gerr
+			}
+			return util.WrapError(ErrTooManyAliases, err)
+		}
+		ddb.logTransactionError(err)
+		return err
+	}
+	return nil
+}
+
+// GetAlias fetches an alias by its path
+func (ddb *DDBProvider) GetAlias(aliaspath string) (*models.Alias, error) {
+	lm, err := ddb.getItem(aliaspath)
+	if err != nil {
+		return nil, err
+	}
+	if lm.AliasOf == "" {
+		return nil, ErrNotFound
+	}
+	return aliasFromRow(lm), nil
+}
+
+// ListAliases queries the alias index for every alias of a link
+// Index queries are eventually consistent, so an alias created a moment ago may be missing
+func (ddb *DDBProvider) ListAliases(linkpath string) ([]*models.Alias, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(ddb.tableName),
+		IndexName:              aws.String(aliasIndex),
+		KeyConditionExpression: aws.String("#AO = :lp"),
+		ExpressionAttributeNames: map[string]*string{
+			"#AO": aws.String("AliasOf"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":lp": {S: aws.String(linkpath)},
+		},
+	}
+
+	aliases := make([]*models.Alias, 0)
+	err := ddb.ddb.QueryPages(input, func(page *dynamodb.QueryOutput, last bool) bool {
+		var rows []*models.LinkModel
+		if err := dynamodbattribute.UnmarshalListOfMaps(page.Items, &rows); err != nil {
+			ddb.logger.Error().Msg("Failed to unmarshal Records: " + err.Error())
+			return false
+		}
+		for _, lm := range rows {
+			aliases = append(aliases, aliasFromRow(lm))
+		}
+		return true
+	})
+	if err != nil {
+		ddb.logQueryError(err)
+		return nil, err
+	}
+	sortAliases(aliases)
+	return aliases, nil
+}
+
+// ListAllAliases scans the alias index, which only holds alias rows, for the aliases of every link
+// Like ListAliases it is eventually consistent
+func (ddb *DDBProvider) ListAllAliases() ([]*models.Alias, error) {
+	aliases := make([]*models.Alias, 0)
+	var failed error
+	err := ddb.ddb.ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(ddb.tableName),
+		IndexName: aws.String(aliasIndex),
+	}, func(page *dynamodb.ScanOutput, last bool) bool {
+		var rows []*models.LinkModel
+		if failed = dynamodbattribute.UnmarshalListOfMaps(page.Items, &rows); failed != nil {
+			ddb.logger.Error().Msg("Failed to unmarshal Records: " + failed.Error())
+			return false
+		}
+		for _, lm := range rows {
+			aliases = append(aliases, aliasFromRow(lm))
+		}
+		return true
+	})
+	if failed != nil {
+		return nil, failed
+	}
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			ddb.logger.Error().Msg("DDB Scan Failed: " + aerr.Code() + ":" + aerr.Error())
+		} else {
+			ddb.logger.Error().Msg("DDB Scan Failed: " + err.Error())
+		}
+		return nil, err
+	}
+	sortAliases(aliases)
+	return aliases, nil
+}
+
+// DeleteAlias removes an alias row, refusing to touch links
+// The AliasCount of the link it points at is decremented in the same transaction
+// Aliases of a link that has since been deleted are removed on their own
+func (ddb *DDBProvider) DeleteAlias(aliaspath string) error {
+	var err error
+	// A rename can move the alias to another link between the read and the write, so try again with its new link
+	for attempt := 0; attempt < 3; attempt++ {
+		var row *models.LinkModel
+		row, err = ddb.getItem(aliaspath)
+		if err != nil {
+			return err
+		}
+		if row.AliasOf == "" {
+			return ErrNotFound
+		}
+
+		owned := ddb.aliasDelete(row)
+		_, err = ddb.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				{Delete: owned},
+				{
+					Update: &dynamodb.Update{
+						TableName: aws.String(ddb.tableName),
+						Key: map[string]*dynamodb.AttributeValue{
+							"LinkPath": {S: aws.String(row.AliasOf)},
+						},
+						UpdateExpression:    aws.String("add #AC :minus"),
+						ConditionExpression: aws.String("attribute_exists(LinkPath) AND #AC > :zero"),
+						ExpressionAttributeNames: map[string]*string{
+							"#AC": aws.String("AliasCount"),
+						},
+						ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+							":minus": {N: aws.String("-1")},
+							":zero":  {N: aws.String("0")},
+						},
+					},
+				},
+			},
+		})
+		item, cancelled := cancelledItem(err)
+		switch {
+		case err == nil:
+			return nil
+		case cancelled && item == 0:
+			// Moved or removed since it was read
+			continue
+		case cancelled:
+			// The link is gone, there is no count to keep
+			_, err = ddb.ddb.DeleteItem(&dynamodb.DeleteItemInput{
+				TableName:                 owned.TableName,
+				Key:                       owned.Key,
+				ConditionExpression:       owned.ConditionExpression,
+				ExpressionAttributeNames:  owned.ExpressionAttributeNames,
+				ExpressionAttributeValues: owned.ExpressionAttributeValues,
+			})
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+				continue
+			}
+			if err != nil {
+				ddb.logger.Error().Msg("DDB DeleteItem Failed: " + err.Error())
+			}
+			return err
+		default:
+			ddb.logTransactionError(err)
+			return err
+		}
+	}
+	return util.WrapError(ErrNotFound, err)
+}
+
+// aliasDelete builds the delete of an alias row, conditional on it still pointing at the same link
+func (ddb *DDBProvider) aliasDelete(row *models.LinkModel) *dynamodb.Delete {
+	return &dynamodb.Delete{
+		TableName: aws.String(ddb.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"LinkPath": {S: aws.String(row.LinkPath)},
+		},
+		ConditionExpression: aws.String("#AO = :lp"),
+		ExpressionAttributeNames: map[string]*string{
+			"#AO": aws.String("AliasOf"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":lp": {S: aws.String(row.AliasOf)},
+		},
+	}
+}
+
+// verifiedAliases lists the aliases of a link, dropping any the index still holds after they were deleted or moved
+// The rows are re-read with strongly consistent reads, but an alias created a moment ago may still be missing
+func (ddb *DDBProvider) verifiedAliases(linkpath string) ([]*models.Alias, error) {
+	aliases, err := ddb.ListAliases(linkpath)
+	if err != nil || len(aliases) == 0 {
+		return aliases, err
+	}
+
+	keys := make([]map[string]*dynamodb.AttributeValue, len(aliases))
+	for i, alias := range aliases {
+		keys[i] = map[string]*dynamodb.AttributeValue{"LinkPath": {S: aws.String(alias.AliasPath)}}
+	}
+	items, err := ddb.batchGet(ddb.tableName, keys, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	var rows []*models.LinkModel
+	if err := dynamodbattribute.UnmarshalListOfMaps(items, &rows); err != nil {
+		ddb.logger.Error().Msg("Failed to unmarshal Records: " + err.Error())
+		return nil, err
+	}
+	current := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		if row.AliasOf == linkpath {
+			current[row.LinkPath] = true
+		}
+	}
+
+	verified := aliases[:0]
+	for _, alias := range aliases {
+		if current[alias.AliasPath] {
+			verified = append(verified, alias)
+		}
+	}
+	return verified, nil
+}
+
+// aliasUpdate builds the transaction item moving an alias to a new link
+func (ddb *DDBProvider) aliasUpdate(alias *models.Alias, newpath string) *dynamodb.TransactWriteItem {
+	return &dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			TableName: aws.String(ddb.tableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"LinkPath": {S: aws.String(alias.AliasPath)},
+			},
+			UpdateExpression:    aws.String("set #AO = :new"),
+			ConditionExpression: aws.String("#AO = :old"),
+			ExpressionAttributeNames: map[string]*string{
+				"#AO": aws.String("AliasOf"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":old": {S: aws.String(alias.LinkPath)},
+				":new": {S: aws.String(newpath)},
+			},
+		},
+	}
+}
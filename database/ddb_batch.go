@@ -0,0 +1,277 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+)
+
+const (
+	// ddbBatchGetSize is the most keys BatchGetItem accepts per request
+	ddbBatchGetSize = 100
+	// ddbBatchPutSize is the number of links per TransactWriteItems request, each link also writes a revision
+	ddbBatchPutSize = 12
+	// ddbBatchRetries bounds the attempts at reading unprocessed keys or retrying a cancelled transaction
+	ddbBatchRetries = 8
+)
+
+// errUnprocessed is reported for keys or links DynamoDB still had not processed after every retry
+var errUnprocessed = errors.New("unprocessed items remained after retries")
+
+// BatchGetLinks fetches the links stored under the given paths, including links in the trash
+func (ddb *DDBProvider) BatchGetLinks(linkpaths []string) (map[string]*models.LinkModel, error) {
+	found := make(map[string]*models.LinkModel, len(linkpaths))
+	for start := 0; start < len(linkpaths); start += ddbBatchGetSize {
+		end := start + ddbBatchGetSize
+		if end > len(linkpaths) {
+			end = len(linkpaths)
+		}
+		keys := make([]map[string]*dynamodb.AttributeValue, 0, end-start)
+		for _, p := range linkpaths[start:end] {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{"LinkPath": {S: aws.String(p)}})
+		}
+
+		items, err := ddb.batchGet(ddb.tableName, keys, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		var links []*models.LinkModel
+		if err := dynamodbattribute.UnmarshalListOfMaps(items, &links); err != nil {
+			ddb.logger.Error().Msg("Failed to unmarshal Records: " + err.Error())
+			return nil, err
+		}
+		for _, lm := range links {
+			found[lm.LinkPath] = lm
+		}
+	}
+	return found, nil
+}
+
+// BatchPutLinks creates or replaces links, writing each link and its revision in the same transaction
+// Up to ddbBatchPutSize links share a transaction, and each link is conditional on its Previous
+// Links whose condition fails are left out and the rest of their group retried, conflicts are retried with backoff
+// BatchWriteItem would be cheaper, but it takes no conditions and can't keep a link and its revision together
+func (ddb *DDBProvider) BatchPutLinks(puts []*BatchPut) error {
+	versions, adopted, err := ddb.nextRevisions(puts)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	var lastErr error
+	for start := 0; start < len(puts); start += ddbBatchPutSize {
+		end := start + ddbBatchPutSize
+		if end > len(puts) {
+			end = len(puts)
+		}
+
+		notWritten, err := ddb.putGroup(puts[start:end], versions, adopted)
+		if err != nil {
+			lastErr = err
+		}
+		skip := map[*BatchPut]bool{}
+		for _, put := range notWritten {
+			skip[put] = true
+			failed = append(failed, put.Link.LinkPath)
+		}
+		for _, put := range puts[start:end] {
+			if !skip[put] {
+				put.Link.Version = versions[put.Link.LinkPath]
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return &BatchWriteError{Failed: failed, Err: lastErr}
+	}
+	return nil
+}
+
+// putGroup writes a group of links and their revisions in a single transaction
+// Returns the puts that were not written, along with the last error
+func (ddb *DDBProvider) putGroup(group []*BatchPut, versions map[string]int64, adopted map[string]int64) ([]*BatchPut, error) {
+	var notWritten []*BatchPut
+	var lastErr error
+	pending := group
+	for attempt := 0; attempt < ddbBatchRetries && len(pending) > 0; attempt++ {
+		items := make([]*dynamodb.TransactWriteItem, 0, 2*len(pending))
+		for _, put := range pending {
+			putItems, err := ddb.batchPutItems(put, versions[put.Link.LinkPath], adopted[put.Link.LinkPath])
+			if err != nil {
+				return group, err
+			}
+			items = append(items, putItems...)
+		}
+
+		_, err := ddb.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+		if err == nil {
+			return notWritten, lastErr
+		}
+		tce, ok := err.(*dynamodb.TransactionCanceledException)
+		if !ok {
+			ddb.logTransactionError(err)
+			return append(notWritten, pending...), err
+		}
+
+		// Each put has two items, drop the puts whose link or revision no longer matches and retry the others
+		var retry []*BatchPut
+		for i, put := range pending {
+			if itemConditionFailed(tce, 2*i) || itemConditionFailed(tce, 2*i+1) {
+				notWritten = append(notWritten, put)
+				lastErr = util.WrapError(ErrVersionMismatch, err)
+				continue
+			}
+			retry = append(retry, put)
+		}
+		if len(retry) == len(pending) {
+			// Cancelled by a conflicting transaction or throttling, back off before trying again
+			lastErr = err
+			time.Sleep(batchBackoff(attempt + 1))
+		}
+		pending = retry
+	}
+	if len(pending) > 0 {
+		ddb.logger.Error().Msg("DDB TransactWriteItems Failed: " + errUnprocessed.Error())
+		return append(notWritten, pending...), errUnprocessed
+	}
+	return notWritten, lastErr
+}
+
+// batchPutItems builds the transaction items writing a put and its revision
+// The link is only replaced if it is still at the version of Previous, or only created if the path is still free
+// A replaced link keeps its alias count, which must not have changed either, and a new one adopts any aliases left at the path
+func (ddb *DDBProvider) batchPutItems(put *BatchPut, version int64, adopted int64) ([]*dynamodb.TransactWriteItem, error) {
+	lm := *put.Link
+	lm.Version = version
+	lm.AliasCount = adopted
+	if put.Previous != nil {
+		lm.AliasCount = put.Previous.AliasCount
+	}
+	link, err := linkItem(&lm)
+	if err != nil {
+		ddb.logger.Error().Msg("DDB Marshal Failed: " + err.Error())
+		return nil, err
+	}
+	revision, err := ddb.revisionPut(batchRevision(put, version))
+	if err != nil {
+		return nil, err
+	}
+
+	linkPut := &dynamodb.Put{
+		Item:                link,
+		TableName:           aws.String(ddb.tableName),
+		ConditionExpression: aws.String("attribute_not_exists(LinkPath)"),
+	}
+	if put.Previous != nil {
+		linkPut.ConditionExpression = aws.String("attribute_exists(LinkPath) AND attribute_not_exists(#AO) AND " +
+			versionCondition(put.Previous.Version) + " AND " + aliasCountCondition(put.Previous.AliasCount))
+		linkPut.ExpressionAttributeNames = map[string]*string{
+			"#AO": aws.String("AliasOf"),
+			"#V":  aws.String("Version"),
+			"#AC": aws.String("AliasCount"),
+		}
+		linkPut.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":v":  {N: aws.String(strconv.FormatInt(put.Previous.Version, 10))},
+			":ac": {N: aws.String(strconv.FormatInt(put.Previous.AliasCount, 10))},
+		}
+	}
+	return []*dynamodb.TransactWriteItem{{Put: linkPut}, revision}, nil
+}
+
+// itemConditionFailed checks if the condition expression of a transaction item failed
+func itemConditionFailed(tce *dynamodb.TransactionCanceledException, i int) bool {
+	return i < len(tce.CancellationReasons) && aws.StringValue(tce.CancellationReasons[i].Code) == "ConditionalCheckFailed"
+}
+
+// nextRevisions works out the revision each put records, as batchVersion does for the other providers
+// Replaced links usually continue from their stored version and new links start at 1, unless the path has
+// history beyond that, which a batched lookup of that revision confirms without a query per path
+// Also returns the aliases adopted by new links at paths with history, see adoptedAliases
+func (ddb *DDBProvider) nextRevisions(puts []*BatchPut) (map[string]int64, map[string]int64, error) {
+	versions := make(map[string]int64, len(puts))
+	adopted := map[string]int64{}
+	previous := make(map[string]*models.LinkModel, len(puts))
+	var keys []map[string]*dynamodb.AttributeValue
+	for _, put := range puts {
+		next := batchVersion(put.Previous, 0)
+		versions[put.Link.LinkPath] = next
+		previous[put.Link.LinkPath] = put.Previous
+		keys = append(keys, map[string]*dynamodb.AttributeValue{
+			"LinkPath": {S: aws.String(put.Link.LinkPath)},
+			"Revision": {N: aws.String(strconv.FormatInt(next, 10))},
+		})
+	}
+
+	for start := 0; start < len(keys); start += ddbBatchGetSize {
+		end := start + ddbBatchGetSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		items, err := ddb.batchGet(ddb.revisionsTable, keys[start:end], aws.String("LinkPath"), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, item := range items {
+			linkpath := aws.StringValue(item["LinkPath"].S)
+			last, err := ddb.lastRevision(linkpath)
+			if err != nil {
+				return nil, nil, err
+			}
+			versions[linkpath] = batchVersion(previous[linkpath], last)
+			if previous[linkpath] == nil {
+				if adopted[linkpath], err = ddb.adoptedAliases(linkpath, last); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+	return versions, adopted, nil
+}
+
+// batchGet reads up to ddbBatchGetSize keys from a table, retrying unprocessed keys with backoff
+func (ddb *DDBProvider) batchGet(table string, keys []map[string]*dynamodb.AttributeValue, projection *string, consistent bool) ([]map[string]*dynamodb.AttributeValue, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	request := map[string]*dynamodb.KeysAndAttributes{
+		table: {Keys: keys, ProjectionExpression: projection, ConsistentRead: aws.Bool(consistent)},
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	for attempt := 0; attempt < ddbBatchRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(batchBackoff(attempt))
+		}
+		resp, err := ddb.ddb.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: request})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				ddb.logger.Error().Msg("DDB BatchGetItem Failed: " + aerr.Code() + ":" + aerr.Error())
+			} else {
+				ddb.logger.Error().Msg("DDB BatchGetItem Failed: " + err.Error())
+			}
+			return nil, err
+		}
+		items = append(items, resp.Responses[table]...)
+		if len(resp.UnprocessedKeys) == 0 {
+			return items, nil
+		}
+		request = resp.UnprocessedKeys
+	}
+	ddb.logger.Error().Msg("DDB BatchGetItem Failed: " + errUnprocessed.Error())
+	return nil, errUnprocessed
+}
+
+// batchBackoff is the exponential delay before a retry, capped at 5 seconds
+func batchBackoff(attempt int) time.Duration {
+	d := 50 * time.Millisecond << uint(attempt)
+	if d > 5*time.Second {
+		return 5 * time.Second
+	}
+	return d
+}
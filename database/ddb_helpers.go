@@ -1,88 +1,293 @@
-package database
-
-import (
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-)
-
-func newAWSSession() *session.Session {
-	return session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-}
-
-// ensureTable attempts to describe the requested table, and creates one if it doesn't exist
-func (dp *DDBProvider) ensureTable() error {
-	_, err := dp.ddb.DescribeTable(&dynamodb.DescribeTableInput{
-		TableName: aws.String(dp.tableName),
-	})
-
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeResourceNotFoundException:
-				dp.logger.Debug().Msg(dynamodb.ErrCodeResourceNotFoundException + ":" + aerr.Error())
-				// Table doesn't exist, lets create it
-				dp.logger.Info().Msg("Table " + dp.tableName + " not found, creating it now...")
-				return dp.createTable(dp.tableName)
-			case dynamodb.ErrCodeInternalServerError:
-				dp.logger.Error().Msg(dynamodb.ErrCodeInternalServerError + ":" + aerr.Error())
-			default:
-				dp.logger.Error().Msg(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			dp.logger.Error().Msg(err.Error())
-		}
-	}
-	return err
-}
-
-// createTable creates the target DDB table with the required schema
-func (dp *DDBProvider) createTable(tableName string) error {
-	// TODO: configure created table options from config?
-	input := &dynamodb.CreateTableInput{
-		AttributeDefinitions: []*dynamodb.AttributeDefinition{
-			{
-				AttributeName: aws.String("LinkPath"),
-				AttributeType: aws.String("S"),
-			},
-		},
-		KeySchema: []*dynamodb.KeySchemaElement{
-			{
-				AttributeName: aws.String("LinkPath"),
-				KeyType:       aws.String("HASH"),
-			},
-		},
-		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
-		// ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
-		// 	ReadCapacityUnits:  aws.Int64(5),
-		// 	WriteCapacityUnits: aws.Int64(5),
-		// },
-		TableName: aws.String(tableName),
-	}
-
-	_, err := dp.ddb.CreateTable(input)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeResourceInUseException:
-				dp.logger.Error().Msg(dynamodb.ErrCodeResourceInUseException + ":" + aerr.Error())
-			case dynamodb.ErrCodeLimitExceededException:
-				dp.logger.Error().Msg(dynamodb.ErrCodeLimitExceededException + ":" + aerr.Error())
-			case dynamodb.ErrCodeInternalServerError:
-				dp.logger.Error().Msg(dynamodb.ErrCodeInternalServerError + ":" + aerr.Error())
-			default:
-				dp.logger.Error().Msg(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			dp.logger.Error().Msg(err.Error())
-		}
-	}
-	return err
-}
+package database
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func newAWSSession() *session.Session {
+	return session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+}
+
+// tableSchema is the key schema of one of the provider's tables
+type tableSchema struct {
+	attributes []*dynamodb.AttributeDefinition
+	keys       []*dynamodb.KeySchemaElement
+	indexes    []*dynamodb.GlobalSecondaryIndex
+}
+
+// aliasIndex finds the aliases of a link, it is sparse as only alias rows have an AliasOf attribute
+const aliasIndex = "AliasOf-index"
+
+// scheduleIndex lists the links with an activation or expiry time without scanning the table
+// It is sparse as only those links have a Scheduled attribute, which always holds scheduledKey
+const scheduleIndex = "Scheduled-index"
+
+// scheduledKey is the value of the Scheduled attribute, the index is sorted by LinkPath within it
+const scheduledKey = "1"
+
+// linkTableSchema is keyed on LinkPath alone, with indexes on AliasOf and Scheduled
+var linkTableSchema = tableSchema{
+	attributes: []*dynamodb.AttributeDefinition{
+		{
+			AttributeName: aws.String("LinkPath"),
+			AttributeType: aws.String("S"),
+		},
+		{
+			AttributeName: aws.String("AliasOf"),
+			AttributeType: aws.String("S"),
+		},
+		{
+			AttributeName: aws.String("Scheduled"),
+			AttributeType: aws.String("S"),
+		},
+	},
+	keys: []*dynamodb.KeySchemaElement{
+		{
+			AttributeName: aws.String("LinkPath"),
+			KeyType:       aws.String("HASH"),
+		},
+	},
+	indexes: []*dynamodb.GlobalSecondaryIndex{
+		{
+			IndexName: aws.String(aliasIndex),
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{
+					AttributeName: aws.String("AliasOf"),
+					KeyType:       aws.String("HASH"),
+				},
+			},
+			Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+		},
+		{
+			IndexName: aws.String(scheduleIndex),
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{
+					AttributeName: aws.String("Scheduled"),
+					KeyType:       aws.String("HASH"),
+				},
+				{
+					AttributeName: aws.String("LinkPath"),
+					KeyType:       aws.String("RANGE"),
+				},
+			},
+			Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+		},
+	},
+}
+
+// revisionTableSchema keeps each link's revisions together, sorted by revision number
+var revisionTableSchema = tableSchema{
+	attributes: []*dynamodb.AttributeDefinition{
+		{
+			AttributeName: aws.String("LinkPath"),
+			AttributeType: aws.String("S"),
+		},
+		{
+			AttributeName: aws.String("Revision"),
+			AttributeType: aws.String("N"),
+		},
+	},
+	keys: []*dynamodb.KeySchemaElement{
+		{
+			AttributeName: aws.String("LinkPath"),
+			KeyType:       aws.String("HASH"),
+		},
+		{
+			AttributeName: aws.String("Revision"),
+			KeyType:       aws.String("RANGE"),
+		},
+	},
+}
+
+// ensureTable makes sure both the link and revision tables exist
+// Links scheduled before the schedule index existed are added to it on every startup until that has finished once
+func (dp *DDBProvider) ensureTable() error {
+	indexes, err := dp.ensure(dp.tableName, linkTableSchema)
+	if err != nil {
+		return err
+	}
+	if _, err := dp.ensure(dp.revisionsTable, revisionTableSchema); err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index == scheduleIndex {
+			return dp.backfillScheduled()
+		}
+	}
+	return nil
+}
+
+// ensure attempts to describe the requested table, and creates one if it doesn't exist
+// Returns the names of the indexes an existing table has or is building, none for a table it created
+func (dp *DDBProvider) ensure(tableName string, schema tableSchema) ([]string, error) {
+	desc, err := dp.ddb.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err == nil {
+		return dp.ensureIndexes(tableName, schema, desc.Table)
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case dynamodb.ErrCodeResourceNotFoundException:
+			dp.logger.Debug().Msg(dynamodb.ErrCodeResourceNotFoundException + ":" + aerr.Error())
+			// Table doesn't exist, lets create it
+			dp.logger.Info().Msg("Table " + tableName + " not found, creating it now...")
+			return nil, dp.createTable(tableName, schema)
+		case dynamodb.ErrCodeInternalServerError:
+			dp.logger.Error().Msg(dynamodb.ErrCodeInternalServerError + ":" + aerr.Error())
+		default:
+			dp.logger.Error().Msg(aerr.Error())
+		}
+	} else {
+		// Print the error, cast err to awserr.Error to get the Code and
+		// Message from an error.
+		dp.logger.Error().Msg(err.Error())
+	}
+	return nil, err
+}
+
+// createTable creates the target DDB table with the required schema
+func (dp *DDBProvider) createTable(tableName string, schema tableSchema) error {
+	// TODO: configure created table options from config?
+	input := &dynamodb.CreateTableInput{
+		AttributeDefinitions: schema.attributes,
+		KeySchema:            schema.keys,
+		BillingMode:          aws.String(dynamodb.BillingModePayPerRequest),
+		// ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+		// 	ReadCapacityUnits:  aws.Int64(5),
+		// 	WriteCapacityUnits: aws.Int64(5),
+		// },
+		TableName: aws.String(tableName),
+	}
+	if len(schema.indexes) > 0 {
+		input.GlobalSecondaryIndexes = schema.indexes
+	}
+
+	_, err := dp.ddb.CreateTable(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case dynamodb.ErrCodeResourceInUseException:
+				dp.logger.Error().Msg(dynamodb.ErrCodeResourceInUseException + ":" + aerr.Error())
+			case dynamodb.ErrCodeLimitExceededException:
+				dp.logger.Error().Msg(dynamodb.ErrCodeLimitExceededException + ":" + aerr.Error())
+			case dynamodb.ErrCodeInternalServerError:
+				dp.logger.Error().Msg(dynamodb.ErrCodeInternalServerError + ":" + aerr.Error())
+			default:
+				dp.logger.Error().Msg(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			dp.logger.Error().Msg(err.Error())
+		}
+	}
+	return err
+}
+
+// ensureIndexes adds one of the schema's indexes that a table created by an older version is missing
+// DynamoDB builds new indexes in the background and rejects further changes to the table until it is done,
+// so any other missing index is added on a later startup. Queries against an index fail until it is active
+// Returns the names of the indexes the table has or is building
+func (dp *DDBProvider) ensureIndexes(tableName string, schema tableSchema, table *dynamodb.TableDescription) ([]string, error) {
+	var indexes []string
+	existing := map[string]bool{}
+	busy := aws.StringValue(table.TableStatus) != dynamodb.TableStatusActive
+	for _, gsi := range table.GlobalSecondaryIndexes {
+		indexes = append(indexes, aws.StringValue(gsi.IndexName))
+		existing[aws.StringValue(gsi.IndexName)] = true
+		if aws.StringValue(gsi.IndexStatus) != dynamodb.IndexStatusActive {
+			busy = true
+		}
+	}
+
+	for _, index := range schema.indexes {
+		name := aws.StringValue(index.IndexName)
+		if existing[name] {
+			continue
+		}
+		if busy {
+			dp.logger.Warn().Msg("Index " + name + " not found on " + tableName + ", it will be created on a later startup once the table is active")
+			continue
+		}
+		dp.logger.Info().Msg("Index " + name + " not found on " + tableName + ", creating it now...")
+		_, err := dp.ddb.UpdateTable(&dynamodb.UpdateTableInput{
+			TableName:            aws.String(tableName),
+			AttributeDefinitions: schema.attributes,
+			GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{
+				{
+					Create: &dynamodb.CreateGlobalSecondaryIndexAction{
+						IndexName:  index.IndexName,
+						KeySchema:  index.KeySchema,
+						Projection: index.Projection,
+					},
+				},
+			},
+		})
+		if retryLater(err) {
+			// Another node or an operator is changing the table, or the account is at its limit
+			dp.logger.Warn().Msg("Could not create index " + name + " on " + tableName + ", it will be retried on a later startup: " + err.Error())
+			busy = true
+			continue
+		}
+		if err != nil {
+			dp.logger.Error().Msg("DDB UpdateTable Failed: " + err.Error())
+			return indexes, err
+		}
+		indexes = append(indexes, name)
+		busy = true
+	}
+	return indexes, nil
+}
+
+// retryLater checks if a table change was refused only until the table or account is less busy
+func retryLater(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	return aerr.Code() == dynamodb.ErrCodeResourceInUseException || aerr.Code() == dynamodb.ErrCodeLimitExceededException
+}
+
+// transactionConditionFailed checks if a transaction was cancelled because one of its condition expressions failed
+func transactionConditionFailed(err error) bool {
+	_, ok := cancelledItem(err)
+	return ok
+}
+
+// cancelledItem returns the index of the first transaction item whose condition expression failed
+func cancelledItem(err error) (int, bool) {
+	tce, ok := err.(*dynamodb.TransactionCanceledException)
+	if !ok {
+		return 0, false
+	}
+	for i, reason := range tce.CancellationReasons {
+		if aws.StringValue(reason.Code) == "ConditionalCheckFailed" {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// logTransactionError logs a failed TransactWriteItems call
+func (dp *DDBProvider) logTransactionError(err error) {
+	if aerr, ok := err.(awserr.Error); ok {
+		dp.logger.Error().Msg("DDB TransactWriteItems Failed: " + aerr.Code() + ":" + aerr.Error())
+	} else {
+		dp.logger.Error().Msg("DDB TransactWriteItems Failed: " + err.Error())
+	}
+}
+
+// nullableString converts a string to an attribute value, storing empty strings as NULL like MarshalMap
+func nullableString(v string) *dynamodb.AttributeValue {
+	if v == "" {
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+	}
+	return &dynamodb.AttributeValue{S: aws.String(v)}
+}
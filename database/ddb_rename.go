@@ -0,0 +1,134 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+)
+
+// ddbIndexRetries bounds the re-reads of the alias index while it catches up with a link's AliasCount
+const ddbIndexRetries = 3
+
+// errAliasIndexBehind is reported when the alias index never showed as many aliases as the link counts
+var errAliasIndexBehind = errors.New("alias index does not match the link's alias count")
+
+// RenameLink moves a live link and its aliases to a new path
+// The new link, the old link's removal, both revisions and the alias updates are written in one transaction
+// The removal is conditional on the old link's AliasCount, so an alias created meanwhile fails the rename
+// rather than being left behind, and every alias the count includes must be visible in the index first
+func (ddb *DDBProvider) RenameLink(linkpath string, newpath string, version int64, keepOld bool, purgeTrashed bool, actor string) (*models.LinkModel, error) {
+	var existing *models.LinkModel
+	var aliases []*models.Alias
+	for attempt := 0; ; attempt++ {
+		var err error
+		if existing, err = ddb.GetLinkDetails(linkpath); err != nil {
+			return nil, err
+		}
+		if version != AnyVersion && version != existing.Version {
+			return nil, ErrVersionMismatch
+		}
+		if aliases, err = ddb.verifiedAliases(linkpath); err != nil {
+			return nil, err
+		}
+		if int64(len(aliases)) == existing.AliasCount {
+			break
+		}
+		if attempt == ddbIndexRetries {
+			// The aliases are still changing, or the index is far behind
+			return nil, util.WrapError(ErrVersionMismatch, errAliasIndexBehind)
+		}
+		time.Sleep(batchBackoff(attempt + 1))
+	}
+	last, err := ddb.lastRevision(newpath)
+	if err != nil {
+		return nil, err
+	}
+
+	count := existing.AliasCount
+	adopted, err := ddb.adoptedAliases(newpath, last)
+	if err != nil {
+		return nil, err
+	}
+	count += adopted
+	if keepOld {
+		count++
+	}
+	if count > MaxAliases {
+		return nil, ErrTooManyAliases
+	}
+
+	renamed := renamedLink(existing, newpath, last+1, actor)
+	renamed.AliasCount = count
+	to, from := renameRevisions(existing, renamed, actor)
+	link, err := linkItem(renamed)
+	if err != nil {
+		ddb.logger.Error().Msg("DDB Marshal Failed: " + err.Error())
+		return nil, err
+	}
+	toPut, err := ddb.revisionPut(to)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []*dynamodb.TransactWriteItem{
+		{Put: claimPut(&dynamodb.Put{Item: link, TableName: aws.String(ddb.tableName)}, purgeTrashed)},
+		toPut,
+	}
+	fromPut, err := ddb.revisionPut(from)
+	if err != nil {
+		return nil, err
+	}
+	oldItem := ddb.linkDelete(linkpath, existing.Version)
+	oldItem.Delete.ConditionExpression = aws.String(aws.StringValue(oldItem.Delete.ConditionExpression) + " AND " + aliasCountCondition(existing.AliasCount))
+	oldItem.Delete.ExpressionAttributeNames["#AC"] = aws.String("AliasCount")
+	oldItem.Delete.ExpressionAttributeValues[":ac"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(existing.AliasCount, 10))}
+	if keepOld {
+		// Replace the old link with an alias, under the same condition as the delete
+		kept, err := dynamodbattribute.MarshalMap(aliasRow(keptAlias(linkpath, newpath, actor)))
+		if err != nil {
+			ddb.logger.Error().Msg("DDB Marshal Failed: " + err.Error())
+			return nil, err
+		}
+		del := oldItem.Delete
+		oldItem = &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				Item:                      kept,
+				TableName:                 del.TableName,
+				ConditionExpression:       del.ConditionExpression,
+				ExpressionAttributeNames:  del.ExpressionAttributeNames,
+				ExpressionAttributeValues: del.ExpressionAttributeValues,
+			},
+		}
+	}
+	items = append(items, oldItem, fromPut)
+
+	// Move the aliases along with the link
+	for _, alias := range aliases {
+		items = append(items, ddb.aliasUpdate(alias, newpath))
+	}
+
+	_, err = ddb.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		if item, ok := cancelledItem(err); ok {
+			ddb.logger.Debug().Msg(dynamodb.ErrCodeTransactionCanceledException + ":" + err.Error())
+			if item < 2 {
+				// The new path is taken, or someone else re-created it after we read its history
+				return nil, util.WrapError(ErrAlreadyExists, err)
+			}
+			// Either the old link or one of its aliases changed, look the link up to tell which
+			if _, gerr := ddb.GetLinkDetails(linkpath); gerr != nil {
+				return nil, gerr
+			}
+			return nil, util.WrapError(ErrVersionMismatch, err)
+		}
+		ddb.logTransactionError(err)
+		return nil, err
+	}
+	return renamed, nil
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/regalias/atlas-api/models"
+)
+
+// ListRevisions queries the revision table for a link's revisions, newest first
+func (ddb *DDBProvider) ListRevisions(linkpath string, before int64, limit int64) ([]*models.Revision, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(ddb.revisionsTable),
+		KeyConditionExpression: aws.String("#LP = :lp"),
+		ExpressionAttributeNames: map[string]*string{
+			"#LP": aws.String("LinkPath"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":lp": {S: aws.String(linkpath)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(revisionPageSize(limit)),
+	}
+	if before != 0 {
+		input.KeyConditionExpression = aws.String("#LP = :lp AND #R < :before")
+		input.ExpressionAttributeNames["#R"] = aws.String("Revision")
+		input.ExpressionAttributeValues[":before"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(before, 10))}
+	}
+
+	resp, err := ddb.ddb.Query(input)
+	if err != nil {
+		ddb.logQueryError(err)
+		return nil, err
+	}
+
+	revs := make([]*models.Revision, 0, len(resp.Items))
+	if err := dynamodbattribute.UnmarshalListOfMaps(resp.Items, &revs); err != nil {
+		ddb.logger.Error().Msg("Failed to unmarshal Records: " + err.Error())
+		return nil, err
+	}
+	return revs, nil
+}
+
+// GetRevision fetches a single revision of a link
+func (ddb *DDBProvider) GetRevision(linkpath string, revision int64) (*models.Revision, error) {
+	resp, err := ddb.ddb.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(ddb.revisionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"LinkPath": {S: aws.String(linkpath)},
+			"Revision": {N: aws.String(strconv.FormatInt(revision, 10))},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			ddb.logger.Error().Msg("DDB GetItem Failed: " + aerr.Code() + ":" + aerr.Error())
+		} else {
+			ddb.logger.Error().Msg("DDB GetItem Failed: " + err.Error())
+		}
+		return nil, err
+	}
+	if len(resp.Item) == 0 {
+		return nil, ErrNotFound
+	}
+
+	rev := &models.Revision{}
+	if err := dynamodbattribute.UnmarshalMap(resp.Item, rev); err != nil {
+		ddb.logger.Error().Msg("Failed to unmarshal Record: " + err.Error())
+		return nil, err
+	}
+	return rev, nil
+}
+
+// lastRevision returns the newest revision number of a link path, or 0 if it has no history
+func (ddb *DDBProvider) lastRevision(linkpath string) (int64, error) {
+	revs, err := ddb.ListRevisions(linkpath, 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(revs) == 0 {
+		return 0, nil
+	}
+	return revs[0].Revision, nil
+}
+
+// revisionPut builds the transaction item recording a revision
+// The condition stops two writers from both claiming the same revision number
+func (ddb *DDBProvider) revisionPut(rev *models.Revision) (*dynamodb.TransactWriteItem, error) {
+	item, err := dynamodbattribute.MarshalMap(rev)
+	if err != nil {
+		ddb.logger.Error().Msg("DDB Marshal Failed: " + err.Error())
+		return nil, err
+	}
+	return &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			Item:                item,
+			TableName:           aws.String(ddb.revisionsTable),
+			ConditionExpression: aws.String("attribute_not_exists(Revision)"),
+		},
+	}, nil
+}
+
+// logQueryError logs a failed Query call
+func (ddb *DDBProvider) logQueryError(err error) {
+	if aerr, ok := err.(awserr.Error); ok {
+		ddb.logger.Error().Msg("DDB Query Failed: " + aerr.Code() + ":" + aerr.Error())
+	} else {
+		ddb.logger.Error().Msg("DDB Query Failed: " + err.Error())
+	}
+}
@@ -0,0 +1,284 @@
+package database
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+)
+
+// TransactLinks applies every operation in a single TransactWriteItems call
+// Each operation contributes its link write and revision, built the same way as the single link methods
+func (ddb *DDBProvider) TransactLinks(ops []*LinkOp) error {
+	if err := checkTransaction(ops); err != nil {
+		return err
+	}
+
+	var items []*dynamodb.TransactWriteItem
+	// owner maps each transaction item back to the operation that added it
+	var owner []int
+	versions := make([]int64, len(ops))
+	for i, op := range ops {
+		var opItems []*dynamodb.TransactWriteItem
+		var err error
+		switch op.Op {
+		case OpCreate:
+			opItems, versions[i], err = ddb.createItems(op.Link, op.PurgeTrashed)
+		case OpUpdate:
+			opItems, versions[i], err = ddb.updateItems(op.Link)
+		case OpDelete:
+			opItems, err = ddb.deleteItems(op.Link.LinkPath, op.Link.Version, op.Link.LastModifiedBy)
+		case OpTrash:
+			opItems, err = ddb.trashItems(op.Link.LinkPath, op.Link.Version, op.Link.LastModifiedBy)
+		}
+		if err != nil {
+			return &TransactError{Index: i, Err: err}
+		}
+		items = append(items, opItems...)
+		for range opItems {
+			owner = append(owner, i)
+		}
+	}
+
+	_, err := ddb.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		if item, ok := cancelledItem(err); ok {
+			ddb.logger.Debug().Msg(dynamodb.ErrCodeTransactionCanceledException + ":" + err.Error())
+			// The link changed after we read it, or someone else claimed the path
+			i := owner[item]
+			if ops[i].Op == OpCreate {
+				return &TransactError{Index: i, Err: util.WrapError(ErrAlreadyExists, err)}
+			}
+			return &TransactError{Index: i, Err: util.WrapError(ErrVersionMismatch, err)}
+		}
+		ddb.logTransactionError(err)
+		return err
+	}
+
+	for i, op := range ops {
+		if op.Op == OpCreate || op.Op == OpUpdate {
+			op.Link.Version = versions[i]
+		}
+	}
+	return nil
+}
+
+// createItems builds the transaction items creating a link and its first revision
+// With purgeTrashed the put may also replace a link in the trash, which purges it in the same write
+// Returns the items along with the version the link will be created at
+func (ddb *DDBProvider) createItems(linkmodel *models.LinkModel, purgeTrashed bool) ([]*dynamodb.TransactWriteItem, int64, error) {
+	last, err := ddb.lastRevision(linkmodel.LinkPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	lm := *linkmodel
+	lm.Version = last + 1
+	if lm.AliasCount, err = ddb.adoptedAliases(lm.LinkPath, last); err != nil {
+		return nil, 0, err
+	}
+
+	link, err := linkItem(&lm)
+	if err != nil {
+		ddb.logger.Error().Msg("DDB Marshal Failed: " + err.Error())
+		return nil, 0, err
+	}
+	revision, err := ddb.revisionPut(newRevision(models.RevisionCreate, nil, &lm, lm.Version, lm.LastModifiedBy))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	put := claimPut(&dynamodb.Put{Item: link, TableName: aws.String(ddb.tableName)}, purgeTrashed)
+	return []*dynamodb.TransactWriteItem{{Put: put}, revision}, lm.Version, nil
+}
+
+// claimPut conditions a put on its path being unused
+// With purgeTrashed the path may also be held by a link in the trash, which the put purges by replacing it
+func claimPut(put *dynamodb.Put, purgeTrashed bool) *dynamodb.Put {
+	put.ConditionExpression = aws.String("attribute_not_exists(LinkPath)")
+	if purgeTrashed {
+		put.ConditionExpression = aws.String("attribute_not_exists(LinkPath) OR attribute_exists(#DA)")
+		put.ExpressionAttributeNames = map[string]*string{"#DA": aws.String("DeletedAt")}
+	}
+	return put
+}
+
+// updateItems builds the transaction items updating a link and recording the revision
+// The existing link is read first to check for existance and differences
+// Returns the items along with the link's new version
+func (ddb *DDBProvider) updateItems(linkmodel *models.LinkModel) ([]*dynamodb.TransactWriteItem, int64, error) {
+	res, err := ddb.GetLinkDetails(linkmodel.LinkPath)
+	if err != nil {
+		return nil, 0, err // pass back upstream error
+	}
+
+	if linkmodel.Version != AnyVersion && linkmodel.Version != res.Version {
+		return nil, 0, ErrVersionMismatch
+	}
+
+	if models.CheckLinkModelsAreEqual(linkmodel, res) {
+		// Models are same, no changes required!
+		return nil, 0, ErrNoChange
+	}
+	newVersion := res.Version + 1
+
+	link := map[string]*dynamodb.AttributeValue{
+		"LinkPath": {S: aws.String(linkmodel.LinkPath)},
+	}
+
+	// Only scheduled links carry the Scheduled attribute, which puts them in the schedule index
+	expression := "set #CN = :cn, #TU = :tu, #EN = :en, #LM = :lm, #LMB = :lmb, #V = :nv, #AA = :aa, #EA = :ea, #EB = :eb, #FU = :fu"
+	if linkmodel.ActivateAt != 0 || linkmodel.ExpireAt != 0 {
+		expression += ", #SC = :sc"
+	} else {
+		expression += " remove #SC"
+	}
+
+	update := &dynamodb.Update{
+		ExpressionAttributeNames: map[string]*string{
+			"#CN":  aws.String("CanonicalName"),
+			"#TU":  aws.String("TargetURL"),
+			"#EN":  aws.String("Enabled"),
+			"#LM":  aws.String("LastModified"),
+			"#LMB": aws.String("LastModifiedBy"),
+			"#V":   aws.String("Version"),
+			"#AA":  aws.String("ActivateAt"),
+			"#EA":  aws.String("ExpireAt"),
+			"#EB":  aws.String("ExpiryBehaviour"),
+			"#FU":  aws.String("FallbackURL"),
+			"#SC":  aws.String("Scheduled"),
+		},
+		TableName:        aws.String(ddb.tableName),
+		UpdateExpression: aws.String(expression),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":tu": {
+				S: aws.String(linkmodel.TargetURL),
+			},
+			":cn": {
+				S: aws.String(linkmodel.CanonicalName),
+			},
+			":lm": {
+				N: aws.String(strconv.FormatInt(linkmodel.LastModified, 10)),
+			},
+			":lmb": {
+				S: aws.String(linkmodel.LastModifiedBy),
+			},
+			":en": {
+				BOOL: aws.Bool(linkmodel.Enabled),
+			},
+			":v": {
+				N: aws.String(strconv.FormatInt(res.Version, 10)),
+			},
+			":nv": {
+				N: aws.String(strconv.FormatInt(newVersion, 10)),
+			},
+			":aa": {
+				N: aws.String(strconv.FormatInt(linkmodel.ActivateAt, 10)),
+			},
+			":ea": {
+				N: aws.String(strconv.FormatInt(linkmodel.ExpireAt, 10)),
+			},
+			// Store empty strings as NULL, the same way MarshalMap does on create
+			":eb": nullableString(linkmodel.ExpiryBehaviour),
+			":fu": nullableString(linkmodel.FallbackURL),
+		},
+		// Fails if the link was deleted or updated by someone else since we read it
+		ConditionExpression: aws.String("attribute_exists(LinkPath) AND " + versionCondition(res.Version)),
+		Key:                 link,
+	}
+	if linkmodel.ActivateAt != 0 || linkmodel.ExpireAt != 0 {
+		update.ExpressionAttributeValues[":sc"] = &dynamodb.AttributeValue{S: aws.String(scheduledKey)}
+	}
+
+	updated := *linkmodel
+	updated.CreatedTime = res.CreatedTime
+	revision, err := ddb.revisionPut(newRevision(models.RevisionUpdate, res, &updated, newVersion, linkmodel.LastModifiedBy))
+	if err != nil {
+		return nil, 0, err
+	}
+	return []*dynamodb.TransactWriteItem{{Update: update}, revision}, newVersion, nil
+}
+
+// deleteItems builds the transaction items deleting a link and recording the revision
+// The deleted state is read first so it can be recorded in the revision written with the delete
+func (ddb *DDBProvider) deleteItems(linkpath string, version int64, actor string) ([]*dynamodb.TransactWriteItem, error) {
+	existing, err := ddb.GetLinkDetails(linkpath)
+	if err != nil {
+		return nil, err
+	}
+	if version != AnyVersion && version != existing.Version {
+		return nil, ErrVersionMismatch
+	}
+
+	revision, err := ddb.revisionPut(newRevision(models.RevisionDelete, existing, nil, existing.Version+1, actor))
+	if err != nil {
+		return nil, err
+	}
+
+	return []*dynamodb.TransactWriteItem{ddb.linkDelete(linkpath, existing.Version), revision}, nil
+}
+
+// linkDelete builds the transaction item deleting a link that is still at the expected version
+func (ddb *DDBProvider) linkDelete(linkpath string, expected int64) *dynamodb.TransactWriteItem {
+	// DeleteItem is idempotent - need to specify a condition that it must exist to be successful
+	// and still be at the version we recorded
+	return &dynamodb.TransactWriteItem{
+		Delete: &dynamodb.Delete{
+			TableName: aws.String(ddb.tableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"LinkPath": {
+					S: aws.String(linkpath),
+				},
+			},
+			ConditionExpression:      aws.String("attribute_exists(LinkPath) AND " + versionCondition(expected)),
+			ExpressionAttributeNames: map[string]*string{"#V": aws.String("Version")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":v": {N: aws.String(strconv.FormatInt(expected, 10))},
+			},
+		},
+	}
+}
+
+// trashItems builds the transaction items moving a link to the trash and recording the revision
+func (ddb *DDBProvider) trashItems(linkpath string, version int64, actor string) ([]*dynamodb.TransactWriteItem, error) {
+	existing, err := ddb.GetLinkDetails(linkpath)
+	if err != nil {
+		return nil, err
+	}
+	if version != AnyVersion && version != existing.Version {
+		return nil, ErrVersionMismatch
+	}
+	newVersion := existing.Version + 1
+
+	revision, err := ddb.revisionPut(newRevision(models.RevisionTrash, existing, nil, newVersion, actor))
+	if err != nil {
+		return nil, err
+	}
+
+	return []*dynamodb.TransactWriteItem{
+		{
+			Update: &dynamodb.Update{
+				TableName: aws.String(ddb.tableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"LinkPath": {S: aws.String(linkpath)},
+				},
+				UpdateExpression: aws.String("set #DA = :da, #DB = :db, #V = :nv"),
+				ExpressionAttributeNames: map[string]*string{
+					"#DA": aws.String("DeletedAt"),
+					"#DB": aws.String("DeletedBy"),
+					"#V":  aws.String("Version"),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":da": {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+					":db": {S: aws.String(actor)},
+					":v":  {N: aws.String(strconv.FormatInt(existing.Version, 10))},
+					":nv": {N: aws.String(strconv.FormatInt(newVersion, 10))},
+				},
+				ConditionExpression: aws.String("attribute_exists(LinkPath) AND attribute_not_exists(#DA) AND " + versionCondition(existing.Version)),
+			},
+		},
+		revision,
+	}, nil
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+)
+
+// TrashLink moves the link to the trash by setting its DeletedAt and DeletedBy attributes
+// The update is conditional on the version that was read, like UpdateLink
+func (ddb *DDBProvider) TrashLink(linkpath string, version int64, actor string) error {
+	items, err := ddb.trashItems(linkpath, version, actor)
+	if err != nil {
+		return err
+	}
+	_, err = ddb.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		if transactionConditionFailed(err) {
+			// Either the link is gone or it has moved on, look it up to tell which
+			if _, gerr := ddb.GetLinkDetails(linkpath); gerr != nil {
+				return gerr
+			}
+			return util.WrapError(ErrVersionMismatch, err)
+		}
+		ddb.logTransactionError(err)
+		return err
+	}
+	return nil
+}
+
+// RestoreLink brings a link that was moved to the trash at or after deletedAfter back
+// by removing its DeletedAt and DeletedBy attributes
+func (ddb *DDBProvider) RestoreLink(linkpath string, deletedAfter int64, actor string) (*models.LinkModel, error) {
+	lm, err := ddb.getItem(linkpath)
+	if err != nil {
+		return nil, err
+	}
+	if lm.DeletedAt == 0 || lm.DeletedAt < deletedAfter {
+		return nil, ErrNotFound
+	}
+
+	previous := lm.Version
+	lm.DeletedAt = 0
+	lm.DeletedBy = ""
+	lm.LastModified = time.Now().Unix()
+	lm.LastModifiedBy = actor
+	lm.Version++
+
+	revision, err := ddb.revisionPut(newRevision(models.RevisionRestore, nil, lm, lm.Version, actor))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ddb.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(ddb.tableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						"LinkPath": {S: aws.String(linkpath)},
+					},
+					UpdateExpression: aws.String("set #LM = :lm, #LMB = :lmb, #V = :nv remove #DA, #DB"),
+					ExpressionAttributeNames: map[string]*string{
+						"#DA":  aws.String("DeletedAt"),
+						"#DB":  aws.String("DeletedBy"),
+						"#LM":  aws.String("LastModified"),
+						"#LMB": aws.String("LastModifiedBy"),
+						"#V":   aws.String("Version"),
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":lm":  {N: aws.String(strconv.FormatInt(lm.LastModified, 10))},
+						":lmb": {S: aws.String(actor)},
+						":v":   {N: aws.String(strconv.FormatInt(previous, 10))},
+						":nv":  {N: aws.String(strconv.FormatInt(lm.Version, 10))},
+					},
+					// Fails if the link was purged or restored by someone else since we read it
+					ConditionExpression: aws.String("attribute_exists(#DA) AND " + versionCondition(previous)),
+				},
+			},
+			revision,
+		},
+	})
+	if err != nil {
+		if transactionConditionFailed(err) {
+			return nil, util.WrapError(ErrNotFound, err)
+		}
+		ddb.logTransactionError(err)
+		return nil, err
+	}
+	return lm, nil
+}
+
+// PurgeLink permanently removes a link that was moved to the trash at or before deletedBefore
+func (ddb *DDBProvider) PurgeLink(linkpath string, deletedBefore int64) error {
+	_, err := ddb.ddb.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(ddb.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"LinkPath": {S: aws.String(linkpath)},
+		},
+		ConditionExpression: aws.String("attribute_exists(#DA) AND #DA <= :before"),
+		ExpressionAttributeNames: map[string]*string{
+			"#DA": aws.String("DeletedAt"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":before": {N: aws.String(strconv.FormatInt(deletedBefore, 10))},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			if aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+				return util.WrapError(ErrNotFound, aerr)
+			}
+			ddb.logger.Error().Msg("DDB DeleteItem Failed: " + aerr.Code() + ":" + aerr.Error())
+		} else {
+			ddb.logger.Error().Msg("DDB DeleteItem Failed: " + err.Error())
+		}
+	}
+	return err
+}
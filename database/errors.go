@@ -0,0 +1,28 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/regalias/atlas-api/util"
+)
+
+var (
+	// ErrNotFound is returned when the requested link does not exist
+	ErrNotFound = errors.New("NotFound")
+	// ErrAlreadyExists is returned when creating a link whose path is already taken
+	ErrAlreadyExists = errors.New("AlreadyExists")
+	// ErrNoChange is returned when an update would not modify the stored link
+	ErrNoChange = errors.New("NoChange")
+	// ErrVersionMismatch is returned when a conditional write finds the link at a different version
+	ErrVersionMismatch = errors.New("VersionMismatch")
+	// ErrInvalidCursor is returned when a list continuation token cannot be decoded
+	ErrInvalidCursor = errors.New("InvalidCursor")
+	// ErrTooManyAliases is returned when a write would give a link more than MaxAliases aliases
+	ErrTooManyAliases = errors.New("TooManyAliases")
+	// ErrInvalidTransaction is returned when a transaction is empty, too large, or names a path twice
+	ErrInvalidTransaction = errors.New("InvalidTransaction")
+)
+
+// Error pairs one of the sentinel errors with the underlying driver or AWS error that caused it
+// Use errors.Is to check the sentinel and errors.As to inspect the cause
+type Error = util.Error
@@ -1,28 +1,115 @@
-package database
-
-import "github.com/regalias/atlas-api/models"
-
-// Provider is the generic interface for interacting with underlying persistent database storage
-type Provider interface {
-
-	// InitDatabase is a helper function to initilize the database and/or schema
-	InitDatabase() error
-
-	// Getter
-	// Returns NotFound error if query return is empty, or operational errors
-	GetLinkDetails(linkpath string) (*models.LinkModel, error)
-
-	// TODO: list operation
-
-	// Standard CRUD operations
-	// CreateLink creates a new link in the underlying database
-	CreateLink(linkmodel *models.LinkModel) error
-
-	// UpdateLink updates the link in the database to match the new model
-	// Must return an error if the link does not exist
-	UpdateLink(linkmodel *models.LinkModel) error
-
-	// DeleteLink deletes the link from the database
-	// Must return an error if the link does not exist
-	DeleteLink(linkpath string) error
-}
+package database
+
+import "github.com/regalias/atlas-api/models"
+
+// AnyVersion is passed as the expected version to skip the version check on a write
+// Versions start at 1, links written before versioning are version 0
+const AnyVersion int64 = -1
+
+// Provider is the generic interface for interacting with underlying persistent database storage
+// Errors callers need to act on are reported with the sentinels in errors.go, checked with errors.Is
+type Provider interface {
+
+	// InitDatabase is a helper function to initilize the database and/or schema
+	InitDatabase() error
+
+	// Getter
+	// Links in the trash are treated as missing by every method except the trash operations and ListLinks
+	// Aliases are stored alongside links but are only visible through the alias methods and BatchGetLinks
+	// Returns ErrNotFound if query return is empty, or operational errors
+	GetLinkDetails(linkpath string) (*models.LinkModel, error)
+
+	// ListLinks returns a single page of links matching the supplied filter
+	// Returns ErrInvalidCursor if the continuation token cannot be decoded
+	ListLinks(filter *ListFilter) (*ListPage, error)
+
+	// Standard CRUD operations
+	// Every successful write also records a models.Revision in the same atomic operation
+	// CreateLink creates a new link in the underlying database
+	// The model's Version is set to the link's first revision, which is 1 unless the path has history
+	// Must return ErrAlreadyExists if the link path is taken
+	CreateLink(linkmodel *models.LinkModel) error
+
+	// UpdateLink updates the link in the database to match the new model
+	// The Version on the model is the version the caller expects to replace, or AnyVersion
+	// The write must be atomic against concurrent updates, and set the model's Version to the new version
+	// Must return ErrNotFound if the link does not exist, ErrNoChange if nothing would be modified,
+	// or ErrVersionMismatch if the stored version differs
+	UpdateLink(linkmodel *models.LinkModel) error
+
+	// DeleteLink deletes the link from the database, recording actor as the user responsible
+	// Unless version is AnyVersion it must match the stored version
+	// Must return ErrNotFound if the link does not exist, or ErrVersionMismatch if the stored version differs
+	DeleteLink(linkpath string, version int64, actor string) error
+
+	// TrashLink soft deletes the link, recording actor and the time of deletion
+	// Unless version is AnyVersion it must match the stored version
+	// Must return ErrNotFound if the link does not exist, or ErrVersionMismatch if the stored version differs
+	TrashLink(linkpath string, version int64, actor string) error
+
+	// RestoreLink brings a link that was moved to the trash at or after deletedAfter back and returns it
+	// Must return ErrNotFound if there is no such link in the trash
+	RestoreLink(linkpath string, deletedAfter int64, actor string) (*models.LinkModel, error)
+
+	// PurgeLink permanently removes a link that was moved to the trash at or before deletedBefore
+	// Must return ErrNotFound if there is no such link in the trash
+	PurgeLink(linkpath string, deletedBefore int64) error
+
+	// RenameLink moves a live link to a new path, keeping its CreatedTime and every other field
+	// Rename revisions are recorded on both paths, and the link's aliases are moved to the new path
+	// keepOld turns the old path into an alias of the new one instead of freeing it
+	// purgeTrashed lets the link take the new path from a link in the trash, which is purged in the same write
+	// Aliases left behind by a link previously deleted at the new path are adopted by the renamed link
+	// Unless version is AnyVersion it must match the stored version of the link being renamed
+	// Returns the link at its new path, or must return ErrNotFound if the link does not exist,
+	// ErrAlreadyExists if the new path is taken, ErrTooManyAliases if the renamed link would have more
+	// than MaxAliases aliases, or ErrVersionMismatch if the stored version differs or aliases changed meanwhile
+	RenameLink(linkpath string, newpath string, version int64, keepOld bool, purgeTrashed bool, actor string) (*models.LinkModel, error)
+
+	// CreateAlias adds an alias for a live link
+	// purgeTrashed lets the alias take the path of a link in the trash, which is purged in the same write
+	// Must return ErrAlreadyExists if the alias path is taken by a link or another alias,
+	// ErrNotFound if the link does not exist, or ErrTooManyAliases if it already has MaxAliases aliases
+	CreateAlias(alias *models.Alias, purgeTrashed bool) error
+
+	// GetAlias fetches an alias by its path
+	// Returns ErrNotFound if there is no alias at the path
+	GetAlias(aliaspath string) (*models.Alias, error)
+
+	// ListAliases returns every alias of a link in AliasPath order
+	ListAliases(linkpath string) ([]*models.Alias, error)
+
+	// ListAllAliases returns every alias of every link in AliasPath order
+	// Passes over the whole database use it rather than listing the aliases of each link in turn
+	ListAllAliases() ([]*models.Alias, error)
+
+	// DeleteAlias removes an alias
+	// Must return ErrNotFound if there is no alias at the path
+	DeleteAlias(aliaspath string) error
+
+	// TransactLinks applies a mix of creates, updates, deletes and trashes all or nothing,
+	// recording a revision for each. Each path may appear once, and versions of created and
+	// updated links are set as in CreateLink and UpdateLink
+	// Returns ErrInvalidTransaction for a malformed transaction, or a *TransactError wrapping
+	// the error of the first operation that could not be applied
+	TransactLinks(ops []*LinkOp) error
+
+	// BatchGetLinks fetches the links stored under the given paths, including links in the trash
+	// Paths that don't exist are left out of the result
+	BatchGetLinks(linkpaths []string) (map[string]*models.LinkModel, error)
+
+	// BatchPutLinks creates or replaces links, recording a revision for each
+	// A link is only written along with its revision if the stored link still matches its Previous
+	// Each link's Version is set to its new revision, which follows the stored version and the path's history
+	// Links are not written atomically as a group, a *BatchWriteError lists the links that were not stored
+	BatchPutLinks(puts []*BatchPut) error
+
+	// ListRevisions returns up to limit revisions of a link, newest first
+	// Only revisions older than before are returned when it is non-zero
+	// History outlives the link, so revisions are returned for deleted paths too
+	ListRevisions(linkpath string, before int64, limit int64) ([]*models.Revision, error)
+
+	// GetRevision fetches a single revision of a link
+	// Returns ErrNotFound if the revision does not exist
+	GetRevision(linkpath string, revision int64) (*models.Revision, error)
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/regalias/atlas-api/models"
+)
+
+const (
+	// DefaultPageSize is the number of links returned by ListLinks when no limit is supplied
+	DefaultPageSize int64 = 50
+	// MaxPageSize is the largest page size ListLinks will honour
+	MaxPageSize int64 = 500
+	// MaxScanPerPage caps how many stored items a provider that filters while scanning reads for a single page
+	MaxScanPerPage int64 = 5 * MaxPageSize
+)
+
+// ListFilter contains the pagination and filter options for listing links
+// Empty filter fields are ignored
+type ListFilter struct {
+	Limit          int64
+	Cursor         string
+	Enabled        *bool
+	NamePrefix     string
+	LastModifiedBy string
+	// Trashed lists links in the trash instead of live links
+	Trashed bool
+	// Scheduled only lists links with an activation or expiry time
+	Scheduled bool
+}
+
+// ListPage contains a single page of links
+// NextCursor is empty when there are no more pages
+// A page can hold fewer links than the limit, or none at all, while NextCursor is set
+// when the provider stopped scanning before the page was full
+type ListPage struct {
+	Links      []*models.LinkModel `json:"Links"`
+	NextCursor string              `json:"NextCursor"`
+}
+
+// cursor is the decoded form of a continuation token
+type cursor struct {
+	LinkPath string `json:"LinkPath"`
+}
+
+// encodeCursor wraps the last evaluated link path into an opaque continuation token
+func encodeCursor(linkpath string) string {
+	if linkpath == "" {
+		return ""
+	}
+	b, _ := json.Marshal(cursor{LinkPath: linkpath})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor unwraps a continuation token into the last evaluated link path
+// Returns an InvalidCursor error if the token is malformed
+func decodeCursor(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.LinkPath == "" {
+		return "", ErrInvalidCursor
+	}
+	return c.LinkPath, nil
+}
+
+// pageSize clamps the requested limit to the supported range
+func (f *ListFilter) pageSize() int64 {
+	if f.Limit <= 0 {
+		return DefaultPageSize
+	}
+	if f.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return f.Limit
+}
+
+// matches checks a link against the filter fields, used by providers that filter in process
+func (f *ListFilter) matches(lm *models.LinkModel) bool {
+	if lm.AliasOf != "" {
+		return false
+	}
+	if (lm.DeletedAt != 0) != f.Trashed {
+		return false
+	}
+	if f.Scheduled && lm.ActivateAt == 0 && lm.ExpireAt == 0 {
+		return false
+	}
+	if f.Enabled != nil && lm.Enabled != *f.Enabled {
+		return false
+	}
+	if f.NamePrefix != "" && !strings.HasPrefix(lm.CanonicalName, f.NamePrefix) {
+		return false
+	}
+	if f.LastModifiedBy != "" && lm.LastModifiedBy != f.LastModifiedBy {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestListFilterPageSize(t *testing.T) {
+	tests := []struct {
+		limit int64
+		want  int64
+	}{
+		{limit: -1, want: DefaultPageSize},
+		{limit: 0, want: DefaultPageSize},
+		{limit: 1, want: 1},
+		{limit: MaxPageSize, want: MaxPageSize},
+		{limit: MaxPageSize + 1, want: MaxPageSize},
+	}
+	for _, tt := range tests {
+		if got := (&ListFilter{Limit: tt.limit}).pageSize(); got != tt.want {
+			t.Errorf("pageSize(%d) = %d, want %d", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  string
+		err   error
+	}{
+		{name: "empty", token: "", want: ""},
+		{name: "round trip", token: encodeCursor("docs"), want: "docs"},
+		{name: "not base64", token: "!!!", err: ErrInvalidCursor},
+		{name: "not json", token: "bm90IGpzb24", err: ErrInvalidCursor},
+		{name: "no path", token: "e30", err: ErrInvalidCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
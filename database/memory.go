@@ -0,0 +1,478 @@
+package database
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/regalias/atlas-api/models"
+	"github.com/rs/zerolog"
+)
+
+// MemoryProvider keeps links in process memory
+// Implements the database.Provider interface with the same error and pagination contract as DDBProvider
+// Data is lost on restart, so it is only meant for tests and local development
+type MemoryProvider struct {
+	mu        sync.RWMutex
+	links     map[string]*models.LinkModel
+	revisions map[string][]*models.Revision
+	logger    *zerolog.Logger
+}
+
+// NewMemory creates a new, empty in-memory provider
+func NewMemory(logger *zerolog.Logger) *MemoryProvider {
+	return &MemoryProvider{
+		links:     make(map[string]*models.LinkModel),
+		revisions: make(map[string][]*models.Revision),
+		logger:    logger,
+	}
+}
+
+// InitDatabase is a no-op, there is nothing to create
+func (mp *MemoryProvider) InitDatabase() error {
+	mp.logger.Warn().Msg("Using the in-memory database provider, links will not be persisted")
+	return nil
+}
+
+// GetLinkDetails fetches the link details based on a link path
+func (mp *MemoryProvider) GetLinkDetails(linkpath string) (*models.LinkModel, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	lm, ok := mp.live(linkpath)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// Hand out copies so callers can't modify stored links without going through the provider
+	c := *lm
+	return &c, nil
+}
+
+// ListLinks returns links matching the filter in LinkPath order, one page at a time
+func (mp *MemoryProvider) ListLinks(filter *ListFilter) (*ListPage, error) {
+	startPath, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	paths := make([]string, 0, len(mp.links))
+	for p := range mp.links {
+		if p > startPath {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	limit := filter.pageSize()
+	page := &ListPage{Links: make([]*models.LinkModel, 0, limit)}
+	for _, p := range paths {
+		lm := mp.links[p]
+		if !filter.matches(lm) {
+			continue
+		}
+		if int64(len(page.Links)) == limit {
+			// There is at least one more match, point the cursor at the last returned link
+			page.NextCursor = encodeCursor(page.Links[len(page.Links)-1].LinkPath)
+			break
+		}
+		c := *lm
+		page.Links = append(page.Links, &c)
+	}
+	return page, nil
+}
+
+// CreateLink creates a new link from the supplied model
+// Returns ErrAlreadyExists if the link path is taken
+func (mp *MemoryProvider) CreateLink(linkmodel *models.LinkModel) error {
+	return mp.write(&LinkOp{Op: OpCreate, Link: linkmodel})
+}
+
+// UpdateLink updates the user controllable and audit fields of an existing link and bumps its version
+// Returns ErrNotFound if the link does not exist, ErrNoChange if nothing would be modified,
+// or ErrVersionMismatch if the model version differs from the stored one
+func (mp *MemoryProvider) UpdateLink(linkmodel *models.LinkModel) error {
+	return mp.write(&LinkOp{Op: OpUpdate, Link: linkmodel})
+}
+
+// DeleteLink deletes the link matching the link path
+// Returns ErrNotFound if the link does not exist, or ErrVersionMismatch if the version differs
+func (mp *MemoryProvider) DeleteLink(linkpath string, version int64, actor string) error {
+	return mp.write(&LinkOp{Op: OpDelete, Link: &models.LinkModel{LinkPath: linkpath, Version: version, LastModifiedBy: actor}})
+}
+
+// TrashLink moves the link to the trash and bumps its version
+// Returns ErrNotFound if the link does not exist, or ErrVersionMismatch if the version differs
+func (mp *MemoryProvider) TrashLink(linkpath string, version int64, actor string) error {
+	return mp.write(&LinkOp{Op: OpTrash, Link: &models.LinkModel{LinkPath: linkpath, Version: version, LastModifiedBy: actor}})
+}
+
+// RenameLink moves a live link and its aliases to a new path, leaving an alias behind if keepOld is set
+func (mp *MemoryProvider) RenameLink(linkpath string, newpath string, version int64, keepOld bool, purgeTrashed bool, actor string) (*models.LinkModel, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	existing, ok := mp.live(linkpath)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if version != AnyVersion && version != existing.Version {
+		return nil, ErrVersionMismatch
+	}
+	if mp.taken(newpath, purgeTrashed) {
+		return nil, ErrAlreadyExists
+	}
+	aliases := mp.aliasCount(linkpath) + mp.aliasCount(newpath)
+	if keepOld {
+		aliases++
+	}
+	if aliases > MaxAliases {
+		return nil, ErrTooManyAliases
+	}
+
+	renamed := renamedLink(existing, newpath, mp.lastRevision(newpath)+1, actor)
+	to, from := renameRevisions(existing, renamed, actor)
+	c := *renamed
+	mp.links[newpath] = &c
+	mp.record(to)
+	delete(mp.links, linkpath)
+	mp.record(from)
+
+	for _, lm := range mp.links {
+		if lm.AliasOf == linkpath {
+			lm.AliasOf = newpath
+		}
+	}
+	if keepOld {
+		mp.links[linkpath] = aliasRow(keptAlias(linkpath, newpath, actor))
+	}
+	return renamed, nil
+}
+
+// CreateAlias adds an alias for a live link
+func (mp *MemoryProvider) CreateAlias(alias *models.Alias, purgeTrashed bool) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if mp.taken(alias.AliasPath, purgeTrashed) {
+		return ErrAlreadyExists
+	}
+	if _, ok := mp.live(alias.LinkPath); !ok {
+		return ErrNotFound
+	}
+	if mp.aliasCount(alias.LinkPath) >= MaxAliases {
+		return ErrTooManyAliases
+	}
+	mp.links[alias.AliasPath] = aliasRow(alias)
+	return nil
+}
+
+// taken reports whether a write can't claim path, which a link in the trash only blocks without purgeTrashed
+// Callers must hold the lock
+func (mp *MemoryProvider) taken(path string, purgeTrashed bool) bool {
+	stored, ok := mp.links[path]
+	return ok && !(purgeTrashed && stored.DeletedAt != 0)
+}
+
+// aliasCount counts the aliases of a link, callers must hold the lock
+func (mp *MemoryProvider) aliasCount(linkpath string) int {
+	n := 0
+	for _, lm := range mp.links {
+		if lm.AliasOf == linkpath {
+			n++
+		}
+	}
+	return n
+}
+
+// GetAlias fetches an alias by its path
+func (mp *MemoryProvider) GetAlias(aliaspath string) (*models.Alias, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	lm, ok := mp.links[aliaspath]
+	if !ok || lm.AliasOf == "" {
+		return nil, ErrNotFound
+	}
+	return aliasFromRow(lm), nil
+}
+
+// ListAliases returns every alias of a link in AliasPath order
+func (mp *MemoryProvider) ListAliases(linkpath string) ([]*models.Alias, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	aliases := make([]*models.Alias, 0)
+	for _, lm := range mp.links {
+		if lm.AliasOf == linkpath {
+			aliases = append(aliases, aliasFromRow(lm))
+		}
+	}
+	sortAliases(aliases)
+	return aliases, nil
+}
+
+// ListAllAliases returns every alias of every link in AliasPath order
+func (mp *MemoryProvider) ListAllAliases() ([]*models.Alias, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	aliases := make([]*models.Alias, 0)
+	for _, lm := range mp.links {
+		if lm.AliasOf != "" {
+			aliases = append(aliases, aliasFromRow(lm))
+		}
+	}
+	sortAliases(aliases)
+	return aliases, nil
+}
+
+// DeleteAlias removes an alias
+func (mp *MemoryProvider) DeleteAlias(aliaspath string) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	lm, ok := mp.links[aliaspath]
+	if !ok || lm.AliasOf == "" {
+		return ErrNotFound
+	}
+	delete(mp.links, aliaspath)
+	return nil
+}
+
+// TransactLinks applies every operation, or none of them if any can't be applied
+func (mp *MemoryProvider) TransactLinks(ops []*LinkOp) error {
+	if err := checkTransaction(ops); err != nil {
+		return err
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	// Paths are distinct, so checking every operation against the current state is enough
+	for i, op := range ops {
+		if err := mp.check(op); err != nil {
+			return &TransactError{Index: i, Err: err}
+		}
+	}
+	for _, op := range ops {
+		mp.apply(op)
+	}
+	return nil
+}
+
+// write applies a single operation
+func (mp *MemoryProvider) write(op *LinkOp) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if err := mp.check(op); err != nil {
+		return err
+	}
+	mp.apply(op)
+	return nil
+}
+
+// check returns the error the operation would fail with, callers must hold the lock
+func (mp *MemoryProvider) check(op *LinkOp) error {
+	if op.Op == OpCreate {
+		if mp.taken(op.Link.LinkPath, op.PurgeTrashed) {
+			return ErrAlreadyExists
+		}
+		return nil
+	}
+
+	existing, ok := mp.live(op.Link.LinkPath)
+	if !ok {
+		return ErrNotFound
+	}
+	if op.Link.Version != AnyVersion && op.Link.Version != existing.Version {
+		return ErrVersionMismatch
+	}
+	if op.Op == OpUpdate && models.CheckLinkModelsAreEqual(op.Link, existing) {
+		return ErrNoChange
+	}
+	return nil
+}
+
+// apply performs an operation that passed check and records its revision, callers must hold the write lock
+func (mp *MemoryProvider) apply(op *LinkOp) {
+	lm := op.Link
+	existing := mp.links[lm.LinkPath]
+
+	switch op.Op {
+	case OpCreate:
+		lm.Version = mp.lastRevision(lm.LinkPath) + 1
+		c := *lm
+		mp.links[lm.LinkPath] = &c
+		mp.record(newRevision(models.RevisionCreate, nil, lm, lm.Version, lm.LastModifiedBy))
+
+	case OpUpdate:
+		old := *existing
+
+		// Only touch the same attributes as the DynamoDB update expression
+		existing.CanonicalName = lm.CanonicalName
+		existing.TargetURL = lm.TargetURL
+		existing.Enabled = lm.Enabled
+		existing.ActivateAt = lm.ActivateAt
+		existing.ExpireAt = lm.ExpireAt
+		existing.ExpiryBehaviour = lm.ExpiryBehaviour
+		existing.FallbackURL = lm.FallbackURL
+		existing.LastModified = lm.LastModified
+		existing.LastModifiedBy = lm.LastModifiedBy
+		existing.Version++
+		lm.Version = existing.Version
+		mp.record(newRevision(models.RevisionUpdate, &old, existing, existing.Version, lm.LastModifiedBy))
+
+	case OpDelete:
+		delete(mp.links, lm.LinkPath)
+		mp.record(newRevision(models.RevisionDelete, existing, nil, existing.Version+1, lm.LastModifiedBy))
+
+	case OpTrash:
+		existing.DeletedAt = time.Now().Unix()
+		existing.DeletedBy = lm.LastModifiedBy
+		existing.Version++
+		mp.record(newRevision(models.RevisionTrash, existing, nil, existing.Version, lm.LastModifiedBy))
+	}
+}
+
+// RestoreLink brings a link that was moved to the trash at or after deletedAfter back
+// Returns ErrNotFound if there is no such link in the trash
+func (mp *MemoryProvider) RestoreLink(linkpath string, deletedAfter int64, actor string) (*models.LinkModel, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	existing, ok := mp.links[linkpath]
+	if !ok || existing.DeletedAt == 0 || existing.DeletedAt < deletedAfter {
+		return nil, ErrNotFound
+	}
+
+	existing.DeletedAt = 0
+	existing.DeletedBy = ""
+	existing.LastModified = time.Now().Unix()
+	existing.LastModifiedBy = actor
+	existing.Version++
+	mp.record(newRevision(models.RevisionRestore, nil, existing, existing.Version, actor))
+	c := *existing
+	return &c, nil
+}
+
+// PurgeLink permanently removes a link that was moved to the trash at or before deletedBefore
+func (mp *MemoryProvider) PurgeLink(linkpath string, deletedBefore int64) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	existing, ok := mp.links[linkpath]
+	if !ok || existing.DeletedAt == 0 || existing.DeletedAt > deletedBefore {
+		return ErrNotFound
+	}
+	delete(mp.links, linkpath)
+	return nil
+}
+
+// BatchGetLinks fetches the links stored under the given paths, including links in the trash
+func (mp *MemoryProvider) BatchGetLinks(linkpaths []string) (map[string]*models.LinkModel, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	found := make(map[string]*models.LinkModel, len(linkpaths))
+	for _, p := range linkpaths {
+		if lm, ok := mp.links[p]; ok {
+			c := *lm
+			found[p] = &c
+		}
+	}
+	return found, nil
+}
+
+// BatchPutLinks creates or replaces links, recording a revision for each
+// Links changed since their Previous was read are left out and reported in a *BatchWriteError
+func (mp *MemoryProvider) BatchPutLinks(puts []*BatchPut) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var failed []string
+	for _, put := range puts {
+		stored := mp.links[put.Link.LinkPath]
+		if !batchUnchanged(put, stored) {
+			failed = append(failed, put.Link.LinkPath)
+			continue
+		}
+		put.Link.Version = batchVersion(stored, mp.lastRevision(put.Link.LinkPath))
+		c := *put.Link
+		mp.links[c.LinkPath] = &c
+		mp.record(batchRevision(put, c.Version))
+	}
+	if len(failed) > 0 {
+		return &BatchWriteError{Failed: failed, Err: ErrVersionMismatch}
+	}
+	return nil
+}
+
+// ListRevisions returns up to limit revisions of a link, newest first
+func (mp *MemoryProvider) ListRevisions(linkpath string, before int64, limit int64) ([]*models.Revision, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	limit = revisionPageSize(limit)
+	revs := mp.revisions[linkpath]
+	out := make([]*models.Revision, 0)
+	for i := len(revs) - 1; i >= 0 && int64(len(out)) < limit; i-- {
+		if before != 0 && revs[i].Revision >= before {
+			continue
+		}
+		out = append(out, copyRevision(revs[i]))
+	}
+	return out, nil
+}
+
+// GetRevision fetches a single revision of a link
+func (mp *MemoryProvider) GetRevision(linkpath string, revision int64) (*models.Revision, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	for _, rev := range mp.revisions[linkpath] {
+		if rev.Revision == revision {
+			return copyRevision(rev), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// live returns the stored link if it exists and is neither in the trash nor an alias, callers must hold the lock
+func (mp *MemoryProvider) live(linkpath string) (*models.LinkModel, bool) {
+	lm, ok := mp.links[linkpath]
+	if !ok || lm.DeletedAt != 0 || lm.AliasOf != "" {
+		return nil, false
+	}
+	return lm, true
+}
+
+// lastRevision returns the newest revision number of a link path, or 0 if it has no history
+// Callers must hold the lock
+func (mp *MemoryProvider) lastRevision(linkpath string) int64 {
+	revs := mp.revisions[linkpath]
+	if len(revs) == 0 {
+		return 0
+	}
+	return revs[len(revs)-1].Revision
+}
+
+// record appends a revision to the link's history, callers must hold the write lock
+func (mp *MemoryProvider) record(rev *models.Revision) {
+	mp.revisions[rev.LinkPath] = append(mp.revisions[rev.LinkPath], rev)
+}
+
+// copyRevision copies a stored revision so callers can't modify the history
+func copyRevision(rev *models.Revision) *models.Revision {
+	c := *rev
+	c.Changes = make([]models.FieldChange, len(rev.Changes))
+	copy(c.Changes, rev.Changes)
+	if rev.Link != nil {
+		l := *rev.Link
+		c.Link = &l
+	}
+	return &c
+}
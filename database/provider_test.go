@@ -0,0 +1,957 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/regalias/atlas-api/models"
+	"github.com/rs/zerolog"
+)
+
+// testProviders builds a fresh, empty instance of every provider that runs without external services
+// The memory provider stands in for DynamoDB in tests, so it is checked against the same contract as SQLite
+func testProviders(t *testing.T) map[string]Provider {
+	t.Helper()
+	logger := zerolog.Nop()
+	sp, err := NewSQL(&logger, DialectSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.InitDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sp.db.Close() })
+	return map[string]Provider{
+		"memory": NewMemory(&logger),
+		"sqlite": sp,
+	}
+}
+
+// forEachProvider runs fn as a subtest against a fresh instance of every provider
+func forEachProvider(t *testing.T, fn func(t *testing.T, p Provider)) {
+	t.Helper()
+	for name, p := range testProviders(t) {
+		p := p
+		t.Run(name, func(t *testing.T) {
+			fn(t, p)
+		})
+	}
+}
+
+// testLink builds a live, enabled link at path
+func testLink(path string) *models.LinkModel {
+	return &models.LinkModel{
+		LinkPath:       path,
+		CanonicalName:  "name" + path,
+		TargetURL:      "https://example.com/" + path,
+		Enabled:        true,
+		CreatedTime:    1,
+		LastModified:   1,
+		LastModifiedBy: "tester",
+		Version:        AnyVersion,
+	}
+}
+
+// testAlias builds an alias of linkpath at aliaspath
+func testAlias(aliaspath string, linkpath string) *models.Alias {
+	return &models.Alias{AliasPath: aliaspath, LinkPath: linkpath, CreatedTime: 1, CreatedBy: "tester"}
+}
+
+// mustCreate creates links at every path, failing the test on error
+func mustCreate(t *testing.T, p Provider, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		if err := p.CreateLink(testLink(path)); err != nil {
+			t.Fatalf("creating %s: %v", path, err)
+		}
+	}
+}
+
+// mustAlias adds aliases of linkpath at every aliaspath, failing the test on error
+func mustAlias(t *testing.T, p Provider, linkpath string, aliaspaths ...string) {
+	t.Helper()
+	for _, aliaspath := range aliaspaths {
+		if err := p.CreateAlias(testAlias(aliaspath, linkpath), false); err != nil {
+			t.Fatalf("aliasing %s: %v", aliaspath, err)
+		}
+	}
+}
+
+// aliasPaths builds n alias paths for linkpath
+func aliasPaths(linkpath string, n int) []string {
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = linkpath + "-alias" + strconv.Itoa(i)
+	}
+	return paths
+}
+
+func TestProviderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, p Provider)
+		run   func(p Provider) error
+		want  error
+	}{
+		{
+			name: "get missing link",
+			run:  func(p Provider) error { _, err := p.GetLinkDetails("docs"); return err },
+			want: ErrNotFound,
+		},
+		{
+			name:  "create taken path",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run:   func(p Provider) error { return p.CreateLink(testLink("docs")) },
+			want:  ErrAlreadyExists,
+		},
+		{
+			name: "create over alias",
+			setup: func(t *testing.T, p Provider) {
+				mustCreate(t, p, "docs")
+				mustAlias(t, p, "docs", "doc")
+			},
+			run:  func(p Provider) error { return p.CreateLink(testLink("doc")) },
+			want: ErrAlreadyExists,
+		},
+		{
+			name: "update missing link",
+			run:  func(p Provider) error { return p.UpdateLink(testLink("docs")) },
+			want: ErrNotFound,
+		},
+		{
+			name:  "update without changes",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run:   func(p Provider) error { return p.UpdateLink(testLink("docs")) },
+			want:  ErrNoChange,
+		},
+		{
+			name:  "update stale version",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run: func(p Provider) error {
+				lm := testLink("docs")
+				lm.TargetURL = "https://example.com/new"
+				lm.Version = 2
+				return p.UpdateLink(lm)
+			},
+			want: ErrVersionMismatch,
+		},
+		{
+			name:  "update version zero",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run: func(p Provider) error {
+				lm := testLink("docs")
+				lm.TargetURL = "https://example.com/new"
+				lm.Version = 0
+				return p.UpdateLink(lm)
+			},
+			want: ErrVersionMismatch,
+		},
+		{
+			name:  "update current version",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run: func(p Provider) error {
+				lm := testLink("docs")
+				lm.TargetURL = "https://example.com/new"
+				lm.Version = 1
+				return p.UpdateLink(lm)
+			},
+		},
+		{
+			name: "delete missing link",
+			run:  func(p Provider) error { return p.DeleteLink("docs", AnyVersion, "tester") },
+			want: ErrNotFound,
+		},
+		{
+			name:  "delete stale version",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run:   func(p Provider) error { return p.DeleteLink("docs", 2, "tester") },
+			want:  ErrVersionMismatch,
+		},
+		{
+			name:  "trash stale version",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run:   func(p Provider) error { return p.TrashLink("docs", 2, "tester") },
+			want:  ErrVersionMismatch,
+		},
+		{
+			name: "get trashed link",
+			setup: func(t *testing.T, p Provider) {
+				mustCreate(t, p, "docs")
+				if err := p.TrashLink("docs", AnyVersion, "tester"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run:  func(p Provider) error { _, err := p.GetLinkDetails("docs"); return err },
+			want: ErrNotFound,
+		},
+		{
+			name: "update trashed link",
+			setup: func(t *testing.T, p Provider) {
+				mustCreate(t, p, "docs")
+				if err := p.TrashLink("docs", AnyVersion, "tester"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run: func(p Provider) error {
+				lm := testLink("docs")
+				lm.TargetURL = "https://example.com/new"
+				return p.UpdateLink(lm)
+			},
+			want: ErrNotFound,
+		},
+		{
+			name: "create over trashed link",
+			setup: func(t *testing.T, p Provider) {
+				mustCreate(t, p, "docs")
+				if err := p.TrashLink("docs", AnyVersion, "tester"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run:  func(p Provider) error { return p.CreateLink(testLink("docs")) },
+			want: ErrAlreadyExists,
+		},
+		{
+			name:  "restore live link",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run:   func(p Provider) error { _, err := p.RestoreLink("docs", 0, "tester"); return err },
+			want:  ErrNotFound,
+		},
+		{
+			name:  "purge live link",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run:   func(p Provider) error { return p.PurgeLink("docs", 1<<62) },
+			want:  ErrNotFound,
+		},
+		{
+			name: "rename missing link",
+			run: func(p Provider) error {
+				_, err := p.RenameLink("docs", "guide", AnyVersion, false, false, "tester")
+				return err
+			},
+			want: ErrNotFound,
+		},
+		{
+			name:  "rename to a taken path",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs", "guide") },
+			run: func(p Provider) error {
+				_, err := p.RenameLink("docs", "guide", AnyVersion, false, false, "tester")
+				return err
+			},
+			want: ErrAlreadyExists,
+		},
+		{
+			name: "rename to an alias path",
+			setup: func(t *testing.T, p Provider) {
+				mustCreate(t, p, "docs")
+				mustAlias(t, p, "docs", "doc")
+			},
+			run: func(p Provider) error {
+				_, err := p.RenameLink("docs", "doc", AnyVersion, false, false, "tester")
+				return err
+			},
+			want: ErrAlreadyExists,
+		},
+		{
+			name:  "rename stale version",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run: func(p Provider) error {
+				_, err := p.RenameLink("docs", "guide", 2, false, false, "tester")
+				return err
+			},
+			want: ErrVersionMismatch,
+		},
+		{
+			name:  "get missing revision",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run:   func(p Provider) error { _, err := p.GetRevision("docs", 2); return err },
+			want:  ErrNotFound,
+		},
+		{
+			name: "get link through its alias",
+			setup: func(t *testing.T, p Provider) {
+				mustCreate(t, p, "docs")
+				mustAlias(t, p, "docs", "doc")
+			},
+			run:  func(p Provider) error { _, err := p.GetLinkDetails("doc"); return err },
+			want: ErrNotFound,
+		},
+		{
+			name:  "get alias at a link path",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run:   func(p Provider) error { _, err := p.GetAlias("docs"); return err },
+			want:  ErrNotFound,
+		},
+		{
+			name:  "delete alias at a link path",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs") },
+			run:   func(p Provider) error { return p.DeleteAlias("docs") },
+			want:  ErrNotFound,
+		},
+		{
+			name: "alias a missing link",
+			run:  func(p Provider) error { return p.CreateAlias(testAlias("doc", "docs"), false) },
+			want: ErrNotFound,
+		},
+		{
+			name:  "alias at a link path",
+			setup: func(t *testing.T, p Provider) { mustCreate(t, p, "docs", "guide") },
+			run:   func(p Provider) error { return p.CreateAlias(testAlias("guide", "docs"), false) },
+			want:  ErrAlreadyExists,
+		},
+		{
+			name: "alias past the limit",
+			setup: func(t *testing.T, p Provider) {
+				mustCreate(t, p, "docs")
+				mustAlias(t, p, "docs", aliasPaths("docs", MaxAliases)...)
+			},
+			run:  func(p Provider) error { return p.CreateAlias(testAlias("doc", "docs"), false) },
+			want: ErrTooManyAliases,
+		},
+		{
+			name: "rename keeping the old path past the alias limit",
+			setup: func(t *testing.T, p Provider) {
+				mustCreate(t, p, "docs")
+				mustAlias(t, p, "docs", aliasPaths("docs", MaxAliases)...)
+			},
+			run: func(p Provider) error {
+				_, err := p.RenameLink("docs", "guide", AnyVersion, true, false, "tester")
+				return err
+			},
+			want: ErrTooManyAliases,
+		},
+		{
+			name: "rename at the alias limit",
+			setup: func(t *testing.T, p Provider) {
+				mustCreate(t, p, "docs")
+				mustAlias(t, p, "docs", aliasPaths("docs", MaxAliases)...)
+			},
+			run: func(p Provider) error {
+				_, err := p.RenameLink("docs", "guide", AnyVersion, false, false, "tester")
+				return err
+			},
+		},
+		{
+			name: "list with a malformed cursor",
+			run: func(p Provider) error {
+				_, err := p.ListLinks(&ListFilter{Cursor: "not a cursor"})
+				return err
+			},
+			want: ErrInvalidCursor,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachProvider(t, func(t *testing.T, p Provider) {
+				if tt.setup != nil {
+					tt.setup(t, p)
+				}
+				err := tt.run(p)
+				if tt.want == nil && err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				if tt.want != nil && !errors.Is(err, tt.want) {
+					t.Fatalf("got error %v, want %v", err, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestProviderListLinks(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name   string
+		filter ListFilter
+		want   []string
+	}{
+		{
+			name:   "pages of two",
+			filter: ListFilter{Limit: 2},
+			want:   []string{"link0", "link1", "link2", "link3", "link4", "link6"},
+		},
+		{
+			name:   "one page",
+			filter: ListFilter{Limit: 100},
+			want:   []string{"link0", "link1", "link2", "link3", "link4", "link6"},
+		},
+		{
+			name:   "default page size",
+			filter: ListFilter{},
+			want:   []string{"link0", "link1", "link2", "link3", "link4", "link6"},
+		},
+		{
+			name:   "enabled",
+			filter: ListFilter{Limit: 2, Enabled: &enabled},
+			want:   []string{"link0", "link1", "link2", "link4", "link6"},
+		},
+		{
+			name:   "disabled",
+			filter: ListFilter{Limit: 2, Enabled: &disabled},
+			want:   []string{"link3"},
+		},
+		{
+			name:   "trashed",
+			filter: ListFilter{Limit: 2, Trashed: true},
+			want:   []string{"link5"},
+		},
+		{
+			name:   "scheduled",
+			filter: ListFilter{Limit: 1, Scheduled: true},
+			want:   []string{"link1", "link4"},
+		},
+		{
+			name:   "name prefix",
+			filter: ListFilter{Limit: 2, NamePrefix: "namelink1"},
+			want:   []string{"link1"},
+		},
+		{
+			name:   "modified by",
+			filter: ListFilter{Limit: 2, LastModifiedBy: "editor"},
+			want:   []string{"link2", "link3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachProvider(t, func(t *testing.T, p Provider) {
+				for i := 0; i < 7; i++ {
+					lm := testLink("link" + strconv.Itoa(i))
+					switch i {
+					case 1:
+						lm.ActivateAt = 100
+					case 2:
+						lm.LastModifiedBy = "editor"
+					case 3:
+						lm.Enabled = false
+						lm.LastModifiedBy = "editor"
+					case 4:
+						lm.ExpireAt = 200
+					}
+					if err := p.CreateLink(lm); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := p.TrashLink("link5", AnyVersion, "tester"); err != nil {
+					t.Fatal(err)
+				}
+				// Aliases are never listed
+				mustAlias(t, p, "link0", "link0-alias")
+
+				filter := tt.filter
+				got := []string{}
+				for pages := 0; ; pages++ {
+					if pages > 10 {
+						t.Fatal("cursor never ran out")
+					}
+					page, err := p.ListLinks(&filter)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if int64(len(page.Links)) > filter.pageSize() {
+						t.Fatalf("page holds %d links, limit is %d", len(page.Links), filter.pageSize())
+					}
+					for _, lm := range page.Links {
+						got = append(got, lm.LinkPath)
+					}
+					if page.NextCursor == "" {
+						break
+					}
+					filter.Cursor = page.NextCursor
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestProviderVersions(t *testing.T) {
+	forEachProvider(t, func(t *testing.T, p Provider) {
+		lm := testLink("docs")
+		if err := p.CreateLink(lm); err != nil {
+			t.Fatal(err)
+		}
+		if lm.Version != 1 {
+			t.Fatalf("created at version %d, want 1", lm.Version)
+		}
+
+		lm.TargetURL = "https://example.com/v2"
+		lm.Version = 1
+		if err := p.UpdateLink(lm); err != nil {
+			t.Fatal(err)
+		}
+		if lm.Version != 2 {
+			t.Fatalf("updated to version %d, want 2", lm.Version)
+		}
+		stored, err := p.GetLinkDetails("docs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Version != 2 || stored.TargetURL != "https://example.com/v2" {
+			t.Fatalf("stored %+v, want version 2 and the new target", stored)
+		}
+
+		// The delete is revision 3, so the path carries on from 4 when it is created again
+		if err := p.DeleteLink("docs", 2, "tester"); err != nil {
+			t.Fatal(err)
+		}
+		again := testLink("docs")
+		if err := p.CreateLink(again); err != nil {
+			t.Fatal(err)
+		}
+		if again.Version != 4 {
+			t.Fatalf("created again at version %d, want 4", again.Version)
+		}
+
+		revisions, err := p.ListRevisions("docs", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, rev := range revisions {
+			got = append(got, strconv.FormatInt(rev.Revision, 10)+":"+rev.Operation)
+		}
+		want := []string{"4:create", "3:delete", "2:update", "1:create"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got history %v, want %v", got, want)
+		}
+
+		older, err := p.ListRevisions("docs", 3, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(older) != 1 || older[0].Revision != 2 {
+			t.Fatalf("got %d revisions before 3, want only revision 2", len(older))
+		}
+	})
+}
+
+func TestProviderTransactLinks(t *testing.T) {
+	tooMany := make([]*LinkOp, MaxTransactOps+1)
+	for i := range tooMany {
+		tooMany[i] = &LinkOp{Op: OpCreate, Link: testLink("new" + strconv.Itoa(i))}
+	}
+
+	changed := testLink("docs")
+	changed.TargetURL = "https://example.com/new"
+
+	tests := []struct {
+		name string
+		ops  []*LinkOp
+		// index is the operation a *TransactError should report, or -1
+		index int
+		want  error
+		// live lists the paths that must resolve afterwards, and gone those that must not
+		live []string
+		gone []string
+	}{
+		{
+			name: "create, update and delete",
+			ops: []*LinkOp{
+				{Op: OpCreate, Link: testLink("new0")},
+				{Op: OpUpdate, Link: changed},
+				{Op: OpDelete, Link: &models.LinkModel{LinkPath: "old", Version: 1, LastModifiedBy: "tester"}},
+			},
+			index: -1,
+			live:  []string{"new0", "docs"},
+			gone:  []string{"old"},
+		},
+		{
+			name: "failed update rolls back the create",
+			ops: []*LinkOp{
+				{Op: OpCreate, Link: testLink("new0")},
+				{Op: OpUpdate, Link: testLink("missing")},
+			},
+			index: 1,
+			want:  ErrNotFound,
+			gone:  []string{"new0"},
+		},
+		{
+			name: "stale delete rolls back the trash",
+			ops: []*LinkOp{
+				{Op: OpTrash, Link: &models.LinkModel{LinkPath: "docs", Version: AnyVersion, LastModifiedBy: "tester"}},
+				{Op: OpDelete, Link: &models.LinkModel{LinkPath: "old", Version: 5, LastModifiedBy: "tester"}},
+			},
+			index: 1,
+			want:  ErrVersionMismatch,
+			live:  []string{"docs", "old"},
+		},
+		{
+			name:  "create over a trashed link",
+			ops:   []*LinkOp{{Op: OpCreate, Link: testLink("trashed")}},
+			index: 0,
+			want:  ErrAlreadyExists,
+		},
+		{
+			name:  "create purging a trashed link",
+			ops:   []*LinkOp{{Op: OpCreate, Link: testLink("trashed"), PurgeTrashed: true}},
+			index: -1,
+			live:  []string{"trashed"},
+		},
+		{
+			name:  "purge doesn't apply to live links",
+			ops:   []*LinkOp{{Op: OpCreate, Link: testLink("docs"), PurgeTrashed: true}},
+			index: 0,
+			want:  ErrAlreadyExists,
+		},
+		{
+			name:  "empty",
+			ops:   []*LinkOp{},
+			index: -1,
+			want:  ErrInvalidTransaction,
+		},
+		{
+			name:  "too many operations",
+			ops:   tooMany,
+			index: -1,
+			want:  ErrInvalidTransaction,
+			gone:  []string{"new0"},
+		},
+		{
+			name: "repeated path",
+			ops: []*LinkOp{
+				{Op: OpCreate, Link: testLink("new0")},
+				{Op: OpDelete, Link: testLink("new0")},
+			},
+			index: -1,
+			want:  ErrInvalidTransaction,
+			gone:  []string{"new0"},
+		},
+		{
+			name:  "unknown operation",
+			ops:   []*LinkOp{{Op: "rename", Link: testLink("docs")}},
+			index: -1,
+			want:  ErrInvalidTransaction,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachProvider(t, func(t *testing.T, p Provider) {
+				mustCreate(t, p, "docs", "old", "trashed")
+				if err := p.TrashLink("trashed", AnyVersion, "tester"); err != nil {
+					t.Fatal(err)
+				}
+
+				// Operations are copied, as providers set the version of created and updated links
+				ops := make([]*LinkOp, len(tt.ops))
+				for i, op := range tt.ops {
+					lm := *op.Link
+					ops[i] = &LinkOp{Op: op.Op, Link: &lm, PurgeTrashed: op.PurgeTrashed}
+				}
+				err := p.TransactLinks(ops)
+				if tt.want == nil && err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				if tt.want != nil && !errors.Is(err, tt.want) {
+					t.Fatalf("got error %v, want %v", err, tt.want)
+				}
+				var te *TransactError
+				if errors.As(err, &te) != (tt.index >= 0) {
+					t.Fatalf("got error %v, want a TransactError only for index %d", err, tt.index)
+				}
+				if te != nil && te.Index != tt.index {
+					t.Fatalf("got failed operation %d, want %d", te.Index, tt.index)
+				}
+
+				for _, path := range tt.live {
+					if _, err := p.GetLinkDetails(path); err != nil {
+						t.Errorf("%s: %v", path, err)
+					}
+				}
+				for _, path := range tt.gone {
+					if _, err := p.GetLinkDetails(path); !errors.Is(err, ErrNotFound) {
+						t.Errorf("%s: got error %v, want NotFound", path, err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestProviderBatchPutLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		// puts builds the batch, taking the Previous of each put from what BatchGetLinks returned
+		puts   func(stored map[string]*models.LinkModel) []*BatchPut
+		failed []string
+		// versions are the stored versions afterwards
+		versions map[string]int64
+	}{
+		{
+			name: "create and replace",
+			puts: func(stored map[string]*models.LinkModel) []*BatchPut {
+				return []*BatchPut{
+					{Link: testLink("new")},
+					{Link: testLink("docs"), Previous: stored["docs"]},
+				}
+			},
+			versions: map[string]int64{"new": 1, "docs": 2},
+		},
+		{
+			name: "create over an existing link",
+			puts: func(stored map[string]*models.LinkModel) []*BatchPut {
+				return []*BatchPut{
+					{Link: testLink("docs")},
+					{Link: testLink("new")},
+				}
+			},
+			failed:   []string{"docs"},
+			versions: map[string]int64{"docs": 1, "new": 1},
+		},
+		{
+			name: "replace a link that changed since it was read",
+			puts: func(stored map[string]*models.LinkModel) []*BatchPut {
+				stale := *stored["docs"]
+				stale.Version = 7
+				return []*BatchPut{{Link: testLink("docs"), Previous: &stale}}
+			},
+			failed:   []string{"docs"},
+			versions: map[string]int64{"docs": 1},
+		},
+		{
+			name: "replace a link in the trash",
+			puts: func(stored map[string]*models.LinkModel) []*BatchPut {
+				return []*BatchPut{{Link: testLink("trashed"), Previous: stored["trashed"]}}
+			},
+			// Created at 1 and trashed at 2
+			versions: map[string]int64{"trashed": 3},
+		},
+		{
+			name: "replace an alias",
+			puts: func(stored map[string]*models.LinkModel) []*BatchPut {
+				return []*BatchPut{{Link: testLink("doc"), Previous: stored["doc"]}}
+			},
+			failed: []string{"doc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachProvider(t, func(t *testing.T, p Provider) {
+				mustCreate(t, p, "docs", "trashed")
+				mustAlias(t, p, "docs", "doc")
+				if err := p.TrashLink("trashed", AnyVersion, "tester"); err != nil {
+					t.Fatal(err)
+				}
+				stored, err := p.BatchGetLinks([]string{"docs", "trashed", "doc", "new"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := stored["new"]; ok || len(stored) != 3 {
+					t.Fatalf("BatchGetLinks found %d links, want docs, trashed and doc", len(stored))
+				}
+
+				err = p.BatchPutLinks(tt.puts(stored))
+				var bwe *BatchWriteError
+				if len(tt.failed) == 0 && err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				if len(tt.failed) > 0 {
+					if !errors.As(err, &bwe) {
+						t.Fatalf("got error %v, want a BatchWriteError", err)
+					}
+					if !reflect.DeepEqual(bwe.Failed, tt.failed) {
+						t.Fatalf("got failed %v, want %v", bwe.Failed, tt.failed)
+					}
+				}
+
+				for path, want := range tt.versions {
+					lm, err := p.GetLinkDetails(path)
+					if err != nil {
+						t.Fatalf("%s: %v", path, err)
+					}
+					if lm.Version != want {
+						t.Errorf("%s is at version %d, want %d", path, lm.Version, want)
+					}
+					if _, err := p.GetRevision(path, want); err != nil {
+						t.Errorf("%s has no revision %d: %v", path, want, err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestProviderRenameLink(t *testing.T) {
+	tests := []struct {
+		name    string
+		keepOld bool
+		// oldAlias is true when the old path should be left as an alias of the new one
+		oldAlias bool
+	}{
+		{name: "free the old path"},
+		{name: "keep the old path", keepOld: true, oldAlias: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachProvider(t, func(t *testing.T, p Provider) {
+				created := testLink("docs")
+				created.CreatedTime = 42
+				if err := p.CreateLink(created); err != nil {
+					t.Fatal(err)
+				}
+				mustAlias(t, p, "docs", "doc", "documentation")
+
+				renamed, err := p.RenameLink("docs", "guide", 1, tt.keepOld, false, "renamer")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if renamed.LinkPath != "guide" || renamed.CreatedTime != 42 || renamed.LastModifiedBy != "renamer" {
+					t.Fatalf("renamed to %+v", renamed)
+				}
+				if _, err := p.GetLinkDetails("docs"); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("old path: got error %v, want NotFound", err)
+				}
+
+				aliases, err := p.ListAliases("guide")
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, a := range aliases {
+					got = append(got, a.AliasPath)
+				}
+				want := []string{"doc", "documentation"}
+				if tt.oldAlias {
+					want = []string{"doc", "docs", "documentation"}
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("got aliases %v, want %v", got, want)
+				}
+
+				_, err = p.GetAlias("docs")
+				if tt.oldAlias != (err == nil) {
+					t.Fatalf("alias at the old path: got error %v", err)
+				}
+				if !tt.oldAlias {
+					// The old path is free again
+					mustCreate(t, p, "docs")
+				}
+
+				// Both paths record the rename, the old one as revision 2 after its creation
+				for path, revision := range map[string]int64{"guide": 1, "docs": 2} {
+					rev, err := p.GetRevision(path, revision)
+					if err != nil {
+						t.Fatalf("%s: %v", path, err)
+					}
+					if rev.Operation != models.RevisionRename {
+						t.Errorf("%s: revision %d is a %s, want a rename", path, revision, rev.Operation)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestProviderAliasesFollowDeletes(t *testing.T) {
+	forEachProvider(t, func(t *testing.T, p Provider) {
+		mustCreate(t, p, "docs")
+		mustAlias(t, p, "docs", "doc")
+		if err := p.DeleteAlias("doc"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.GetAlias("doc"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("got error %v, want NotFound", err)
+		}
+		// The freed path and alias slot can be used again
+		mustAlias(t, p, "docs", aliasPaths("docs", MaxAliases)...)
+	})
+}
+
+func TestProviderListAllAliases(t *testing.T) {
+	forEachProvider(t, func(t *testing.T, p Provider) {
+		mustCreate(t, p, "docs", "guide", "home")
+		mustAlias(t, p, "docs", "doc", "documentation")
+		mustAlias(t, p, "guide", "guides")
+
+		aliases, err := p.ListAllAliases()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, len(aliases))
+		for i, alias := range aliases {
+			got[i] = alias.AliasPath + ">" + alias.LinkPath
+		}
+		// Links are never listed, and aliases come in AliasPath order
+		want := []string{"doc>docs", "documentation>docs", "guides>guide"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestProviderClaimTrashedPaths(t *testing.T) {
+	tests := []struct {
+		name string
+		// claim writes something at the path "old", which holds a link in the trash
+		claim        func(p Provider, purgeTrashed bool) error
+		purgeTrashed bool
+		want         error
+	}{
+		{
+			name:  "alias",
+			claim: func(p Provider, purge bool) error { return p.CreateAlias(testAlias("old", "docs"), purge) },
+			want:  ErrAlreadyExists,
+		},
+		{
+			name:         "alias purging the trashed link",
+			claim:        func(p Provider, purge bool) error { return p.CreateAlias(testAlias("old", "docs"), purge) },
+			purgeTrashed: true,
+		},
+		{
+			name:         "alias of a missing link",
+			claim:        func(p Provider, purge bool) error { return p.CreateAlias(testAlias("old", "missing"), purge) },
+			purgeTrashed: true,
+			want:         ErrNotFound,
+		},
+		{
+			name: "rename",
+			claim: func(p Provider, purge bool) error {
+				_, err := p.RenameLink("docs", "old", AnyVersion, false, purge, "tester")
+				return err
+			},
+			want: ErrAlreadyExists,
+		},
+		{
+			name: "rename purging the trashed link",
+			claim: func(p Provider, purge bool) error {
+				_, err := p.RenameLink("docs", "old", AnyVersion, false, purge, "tester")
+				return err
+			},
+			purgeTrashed: true,
+		},
+		{
+			name: "rename of a stale version",
+			claim: func(p Provider, purge bool) error {
+				_, err := p.RenameLink("docs", "old", 2, false, purge, "tester")
+				return err
+			},
+			purgeTrashed: true,
+			want:         ErrVersionMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachProvider(t, func(t *testing.T, p Provider) {
+				mustCreate(t, p, "docs", "old")
+				if err := p.TrashLink("old", AnyVersion, "tester"); err != nil {
+					t.Fatal(err)
+				}
+
+				err := tt.claim(p, tt.purgeTrashed)
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("got error %v, want %v", err, tt.want)
+				}
+				// The trashed link is only gone if the write that claimed its path went through
+				_, err = p.RestoreLink("old", 0, "tester")
+				if tt.want == nil && !errors.Is(err, ErrNotFound) {
+					t.Fatalf("restoring got error %v, want the trashed link purged", err)
+				}
+				if tt.want != nil && err != nil {
+					t.Fatalf("restoring got error %v, want the trashed link kept", err)
+				}
+			})
+		})
+	}
+}
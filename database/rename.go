@@ -0,0 +1,27 @@
+package database
+
+import (
+	"time"
+
+	"github.com/regalias/atlas-api/models"
+)
+
+// renamedLink builds the link stored under the new path
+// Everything but the path, version and modification fields is carried over, including CreatedTime
+func renamedLink(old *models.LinkModel, newpath string, version int64, actor string) *models.LinkModel {
+	lm := *old
+	lm.LinkPath = newpath
+	lm.Version = version
+	lm.LastModified = time.Now().Unix()
+	lm.LastModifiedBy = actor
+	return &lm
+}
+
+// renameRevisions builds the revisions recording a rename on the new and old paths
+// The old path's revision only records where the link went
+func renameRevisions(old *models.LinkModel, renamed *models.LinkModel, actor string) (*models.Revision, *models.Revision) {
+	to := newRevision(models.RevisionRename, old, renamed, renamed.Version, actor)
+	from := newRevision(models.RevisionRename, old, nil, old.Version+1, actor)
+	from.Changes = []models.FieldChange{{Field: "LinkPath", Old: old.LinkPath, New: renamed.LinkPath}}
+	return to, from
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"time"
+
+	"github.com/regalias/atlas-api/models"
+)
+
+// newRevision builds the revision recording a change from old to new
+// old is nil for creations and new is nil for deletions
+func newRevision(operation string, old *models.LinkModel, new *models.LinkModel, revision int64, actor string) *models.Revision {
+	rev := &models.Revision{
+		Revision:  revision,
+		Operation: operation,
+		Timestamp: time.Now().Unix(),
+		Actor:     actor,
+		Changes:   models.DiffLinkModels(old, new),
+	}
+	if new != nil {
+		c := *new
+		c.Version = revision
+		rev.Link = &c
+		rev.LinkPath = new.LinkPath
+		rev.Timestamp = new.LastModified
+	} else {
+		rev.LinkPath = old.LinkPath
+	}
+	return rev
+}
+
+// revisionPageSize clamps the requested number of revisions to the supported range
+func revisionPageSize(limit int64) int64 {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return limit
+}
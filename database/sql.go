@@ -0,0 +1,732 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+	"github.com/rs/zerolog"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	// DialectPostgres selects the lib/pq PostgreSQL driver
+	DialectPostgres = "postgres"
+	// DialectSQLite selects the pure Go modernc.org/sqlite driver, which needs no cgo
+	DialectSQLite = "sqlite"
+)
+
+// linkColumns is the column list matching scanLink and linkArgs
+const linkColumns = "link_path, canonical_name, target_url, enabled, created_time, last_modified, last_modified_by, version, deleted_at, deleted_by, activate_at, expire_at, expiry_behaviour, fallback_url, alias_of"
+
+// SQLProvider contains methods to interact with a PostgreSQL or SQLite database used for persistent storage
+// Implements the database.Provider interface
+type SQLProvider struct {
+	db      *sql.DB
+	dialect string
+	logger  *zerolog.Logger
+}
+
+// NewSQL opens a database/sql connection pool for the given dialect and data source name
+func NewSQL(logger *zerolog.Logger, dialect string, dsn string) (*SQLProvider, error) {
+	if dialect != DialectPostgres && dialect != DialectSQLite {
+		return nil, errors.New("unsupported SQL dialect " + dialect)
+	}
+
+	db, err := sql.Open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == DialectSQLite {
+		// SQLite only allows a single writer, and every connection to :memory: is a separate database
+		db.SetMaxOpenConns(1)
+	}
+
+	return &SQLProvider{
+		db:      db,
+		dialect: dialect,
+		logger:  logger,
+	}, nil
+}
+
+// InitDatabase checks the connection and applies any outstanding schema migrations
+func (sp *SQLProvider) InitDatabase() error {
+	if err := sp.db.Ping(); err != nil {
+		sp.logger.Error().Msg("SQL Ping Failed: " + err.Error())
+		return err
+	}
+	return sp.migrate()
+}
+
+// GetLinkDetails fetches the link details based on a link path
+func (sp *SQLProvider) GetLinkDetails(linkpath string) (*models.LinkModel, error) {
+	lm, err := scanLink(sp.db.QueryRow(sp.rebind("SELECT "+linkColumns+" FROM links WHERE link_path = ? AND deleted_at = 0 AND alias_of = ''"), linkpath))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, util.WrapError(ErrNotFound, err)
+		}
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return nil, err
+	}
+	return lm, nil
+}
+
+// ListLinks returns links matching the filter in LinkPath order, one page at a time
+func (sp *SQLProvider) ListLinks(filter *ListFilter) (*ListPage, error) {
+	startPath, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := []string{"link_path > ?", "alias_of = ''"}
+	args := []interface{}{startPath}
+	if filter.Trashed {
+		conditions = append(conditions, "deleted_at <> 0")
+	} else {
+		conditions = append(conditions, "deleted_at = 0")
+	}
+	if filter.Scheduled {
+		conditions = append(conditions, "(activate_at <> 0 OR expire_at <> 0)")
+	}
+	if filter.Enabled != nil {
+		conditions = append(conditions, "enabled = ?")
+		args = append(args, *filter.Enabled)
+	}
+	if filter.NamePrefix != "" {
+		// substr rather than LIKE, as LIKE is case insensitive in SQLite and needs wildcard escaping
+		conditions = append(conditions, "substr(canonical_name, 1, ?) = ?")
+		args = append(args, utf8.RuneCountInString(filter.NamePrefix), filter.NamePrefix)
+	}
+	if filter.LastModifiedBy != "" {
+		conditions = append(conditions, "last_modified_by = ?")
+		args = append(args, filter.LastModifiedBy)
+	}
+
+	// Fetch one extra row to find out if there is another page
+	limit := filter.pageSize()
+	args = append(args, limit+1)
+	query := "SELECT " + linkColumns + " FROM links WHERE " + strings.Join(conditions, " AND ") + " ORDER BY link_path LIMIT ?"
+
+	rows, err := sp.db.Query(sp.rebind(query), args...)
+	if err != nil {
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &ListPage{Links: make([]*models.LinkModel, 0, limit)}
+	for rows.Next() {
+		if int64(len(page.Links)) == limit {
+			page.NextCursor = encodeCursor(page.Links[len(page.Links)-1].LinkPath)
+			break
+		}
+		lm, err := scanLink(rows)
+		if err != nil {
+			sp.logger.Error().Msg("SQL Scan Failed: " + err.Error())
+			return nil, err
+		}
+		page.Links = append(page.Links, lm)
+	}
+	if err := rows.Err(); err != nil {
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return nil, err
+	}
+	return page, nil
+}
+
+// CreateLink creates a new link from the supplied model
+// The primary keys on link_path and the revision guarantee uniqueness
+func (sp *SQLProvider) CreateLink(linkmodel *models.LinkModel) error {
+	var version int64
+	err := sp.inTx(func(tx *sql.Tx) (err error) {
+		version, err = sp.createTx(tx, linkmodel)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	linkmodel.Version = version
+	return nil
+}
+
+// UpdateLink updates the existing link matching the link path in the supplied model
+// The read and write happen in one transaction and the write is conditional on the version read,
+// so neither the NoChange check nor the version check can race another update
+func (sp *SQLProvider) UpdateLink(linkmodel *models.LinkModel) error {
+	var version int64
+	err := sp.inTx(func(tx *sql.Tx) (err error) {
+		version, err = sp.updateTx(tx, linkmodel)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	linkmodel.Version = version
+	return nil
+}
+
+// DeleteLink deletes the link matching the link path
+// Returns ErrNotFound if the link does not exist, or ErrVersionMismatch if the version differs
+func (sp *SQLProvider) DeleteLink(linkpath string, version int64, actor string) error {
+	return sp.inTx(func(tx *sql.Tx) error {
+		return sp.deleteTx(tx, linkpath, version, actor)
+	})
+}
+
+// TrashLink moves the link to the trash and bumps its version
+// Returns ErrNotFound if the link does not exist, or ErrVersionMismatch if the version differs
+func (sp *SQLProvider) TrashLink(linkpath string, version int64, actor string) error {
+	return sp.inTx(func(tx *sql.Tx) error {
+		return sp.trashTx(tx, linkpath, version, actor)
+	})
+}
+
+// RenameLink moves a live link and its aliases to a new path in one transaction
+func (sp *SQLProvider) RenameLink(linkpath string, newpath string, version int64, keepOld bool, purgeTrashed bool, actor string) (*models.LinkModel, error) {
+	var renamed *models.LinkModel
+	err := sp.inTx(func(tx *sql.Tx) error {
+		existing, err := sp.lockLive(tx, linkpath, version)
+		if err != nil {
+			return err
+		}
+		if purgeTrashed {
+			if err := sp.purgeTrashedTx(tx, newpath); err != nil {
+				return err
+			}
+		}
+		aliases, err := sp.aliasCountTx(tx, linkpath, newpath)
+		if err != nil {
+			return err
+		}
+		if keepOld {
+			aliases++
+		}
+		if aliases > MaxAliases {
+			return ErrTooManyAliases
+		}
+		last, err := sp.lastRevisionTx(tx, newpath)
+		if err != nil {
+			return err
+		}
+		renamed = renamedLink(existing, newpath, last+1, actor)
+		to, from := renameRevisions(existing, renamed, actor)
+
+		if _, err := tx.Exec(sp.rebind("INSERT INTO links ("+linkColumns+") VALUES ("+linkPlaceholders+")"), linkArgs(renamed)...); err != nil {
+			if isUniqueViolation(err) {
+				sp.logger.Debug().Msg("SQL Insert Failed: " + err.Error())
+				return util.WrapError(ErrAlreadyExists, err)
+			}
+			sp.logger.Error().Msg("SQL Insert Failed: " + err.Error())
+			return err
+		}
+		if err := sp.insertRevision(tx, to); err != nil {
+			if isUniqueViolation(err) {
+				return util.WrapError(ErrAlreadyExists, err)
+			}
+			return err
+		}
+		res, err := tx.Exec(sp.rebind("DELETE FROM links WHERE link_path = ? AND version = ?"), linkpath, existing.Version)
+		if err != nil {
+			sp.logger.Error().Msg("SQL Delete Failed: " + err.Error())
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return ErrVersionMismatch
+		}
+		if err := sp.insertRevision(tx, from); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(sp.rebind("UPDATE links SET alias_of = ? WHERE alias_of = ?"), newpath, linkpath); err != nil {
+			sp.logger.Error().Msg("SQL Update Failed: " + err.Error())
+			return err
+		}
+		if keepOld {
+			if _, err := tx.Exec(sp.rebind("INSERT INTO links ("+linkColumns+") VALUES ("+linkPlaceholders+")"), linkArgs(aliasRow(keptAlias(linkpath, newpath, actor)))...); err != nil {
+				sp.logger.Error().Msg("SQL Insert Failed: " + err.Error())
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return renamed, nil
+}
+
+// TransactLinks applies every operation in a single SQL transaction
+func (sp *SQLProvider) TransactLinks(ops []*LinkOp) error {
+	if err := checkTransaction(ops); err != nil {
+		return err
+	}
+
+	versions := make([]int64, len(ops))
+	err := sp.inTx(func(tx *sql.Tx) error {
+		for i, op := range ops {
+			var err error
+			switch op.Op {
+			case OpCreate:
+				if op.PurgeTrashed {
+					if err := sp.purgeTrashedTx(tx, op.Link.LinkPath); err != nil {
+						return err
+					}
+				}
+				versions[i], err = sp.createTx(tx, op.Link)
+			case OpUpdate:
+				versions[i], err = sp.updateTx(tx, op.Link)
+			case OpDelete:
+				err = sp.deleteTx(tx, op.Link.LinkPath, op.Link.Version, op.Link.LastModifiedBy)
+			case OpTrash:
+				err = sp.trashTx(tx, op.Link.LinkPath, op.Link.Version, op.Link.LastModifiedBy)
+			}
+			if err != nil {
+				return &TransactError{Index: i, Err: err}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, op := range ops {
+		if op.Op == OpCreate || op.Op == OpUpdate {
+			op.Link.Version = versions[i]
+		}
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction, committing only if it succeeds
+func (sp *SQLProvider) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := sp.db.Begin()
+	if err != nil {
+		sp.logger.Error().Msg("SQL Begin Failed: " + err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		sp.logger.Error().Msg("SQL Commit Failed: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// createTx inserts a link and its first revision, returning the version it was created at
+func (sp *SQLProvider) createTx(tx *sql.Tx, linkmodel *models.LinkModel) (int64, error) {
+	last, err := sp.lastRevisionTx(tx, linkmodel.LinkPath)
+	if err != nil {
+		return 0, err
+	}
+	version := last + 1
+
+	lm := *linkmodel
+	lm.Version = version
+	if _, err := tx.Exec(sp.rebind("INSERT INTO links ("+linkColumns+") VALUES ("+linkPlaceholders+")"), linkArgs(&lm)...); err != nil {
+		if isUniqueViolation(err) {
+			sp.logger.Debug().Msg("SQL Insert Failed: " + err.Error())
+			return 0, util.WrapError(ErrAlreadyExists, err)
+		}
+		sp.logger.Error().Msg("SQL Insert Failed: " + err.Error())
+		return 0, err
+	}
+	if err := sp.insertRevision(tx, newRevision(models.RevisionCreate, nil, &lm, version, lm.LastModifiedBy)); err != nil {
+		if isUniqueViolation(err) {
+			// Someone else re-created the path after we read its history
+			return 0, util.WrapError(ErrAlreadyExists, err)
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+// updateTx updates a live link and records the revision, returning the new version
+func (sp *SQLProvider) updateTx(tx *sql.Tx, linkmodel *models.LinkModel) (int64, error) {
+	existing, err := sp.lockLive(tx, linkmodel.LinkPath, linkmodel.Version)
+	if err != nil {
+		return 0, err
+	}
+
+	if models.CheckLinkModelsAreEqual(linkmodel, existing) {
+		// Models are same, no changes required!
+		return 0, ErrNoChange
+	}
+
+	res, err := tx.Exec(sp.rebind("UPDATE links SET canonical_name = ?, target_url = ?, enabled = ?, activate_at = ?, expire_at = ?, expiry_behaviour = ?, fallback_url = ?, last_modified = ?, last_modified_by = ?, version = ? WHERE link_path = ? AND version = ?"),
+		linkmodel.CanonicalName,
+		linkmodel.TargetURL,
+		linkmodel.Enabled,
+		linkmodel.ActivateAt,
+		linkmodel.ExpireAt,
+		linkmodel.ExpiryBehaviour,
+		linkmodel.FallbackURL,
+		linkmodel.LastModified,
+		linkmodel.LastModifiedBy,
+		existing.Version+1,
+		linkmodel.LinkPath,
+		existing.Version,
+	)
+	if err != nil {
+		sp.logger.Error().Msg("SQL Update Failed: " + err.Error())
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return 0, ErrVersionMismatch
+	}
+
+	updated := *linkmodel
+	updated.CreatedTime = existing.CreatedTime
+	if err := sp.insertRevision(tx, newRevision(models.RevisionUpdate, existing, &updated, existing.Version+1, linkmodel.LastModifiedBy)); err != nil {
+		return 0, err
+	}
+	return existing.Version + 1, nil
+}
+
+// deleteTx deletes a live link and records the revision
+func (sp *SQLProvider) deleteTx(tx *sql.Tx, linkpath string, version int64, actor string) error {
+	// The deleted state goes into the revision, so read it in the same transaction
+	existing, err := sp.lockLive(tx, linkpath, version)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(sp.rebind("DELETE FROM links WHERE link_path = ? AND version = ?"), linkpath, existing.Version)
+	if err != nil {
+		sp.logger.Error().Msg("SQL Delete Failed: " + err.Error())
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrVersionMismatch
+	}
+	return sp.insertRevision(tx, newRevision(models.RevisionDelete, existing, nil, existing.Version+1, actor))
+}
+
+// trashTx moves a live link to the trash and records the revision
+func (sp *SQLProvider) trashTx(tx *sql.Tx, linkpath string, version int64, actor string) error {
+	existing, err := sp.lockLive(tx, linkpath, version)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(sp.rebind("UPDATE links SET deleted_at = ?, deleted_by = ?, version = ? WHERE link_path = ? AND version = ?"),
+		time.Now().Unix(),
+		actor,
+		existing.Version+1,
+		linkpath,
+		existing.Version,
+	)
+	if err != nil {
+		sp.logger.Error().Msg("SQL Update Failed: " + err.Error())
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrVersionMismatch
+	}
+	return sp.insertRevision(tx, newRevision(models.RevisionTrash, existing, nil, existing.Version+1, actor))
+}
+
+// lastRevisionTx returns the newest revision number of a link path inside a transaction, or 0 if it has no history
+func (sp *SQLProvider) lastRevisionTx(tx *sql.Tx, linkpath string) (int64, error) {
+	var last int64
+	if err := tx.QueryRow(sp.rebind("SELECT COALESCE(MAX(revision), 0) FROM link_revisions WHERE link_path = ?"), linkpath).Scan(&last); err != nil {
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return 0, err
+	}
+	return last, nil
+}
+
+// lockLive reads a live link for a write, checking the version against the stored one
+func (sp *SQLProvider) lockLive(tx *sql.Tx, linkpath string, version int64) (*models.LinkModel, error) {
+	existing, err := sp.lockLink(tx, linkpath, false)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, util.WrapError(ErrNotFound, err)
+		}
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return nil, err
+	}
+	if version != AnyVersion && version != existing.Version {
+		return nil, ErrVersionMismatch
+	}
+	return existing, nil
+}
+
+// RestoreLink brings a link that was moved to the trash at or after deletedAfter back
+// Returns ErrNotFound if there is no such link in the trash
+func (sp *SQLProvider) RestoreLink(linkpath string, deletedAfter int64, actor string) (*models.LinkModel, error) {
+	tx, err := sp.db.Begin()
+	if err != nil {
+		sp.logger.Error().Msg("SQL Begin Failed: " + err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lm, err := sp.lockLink(tx, linkpath, true)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, util.WrapError(ErrNotFound, err)
+		}
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return nil, err
+	}
+	if lm.DeletedAt < deletedAfter {
+		return nil, ErrNotFound
+	}
+
+	previous := lm.Version
+	lm.DeletedAt = 0
+	lm.DeletedBy = ""
+	lm.LastModified = time.Now().Unix()
+	lm.LastModifiedBy = actor
+	lm.Version++
+
+	res, err := tx.Exec(sp.rebind("UPDATE links SET deleted_at = 0, deleted_by = '', last_modified = ?, last_modified_by = ?, version = ? WHERE link_path = ? AND version = ?"),
+		lm.LastModified,
+		lm.LastModifiedBy,
+		lm.Version,
+		linkpath,
+		previous,
+	)
+	if err != nil {
+		sp.logger.Error().Msg("SQL Update Failed: " + err.Error())
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrNotFound
+	}
+	if err := sp.insertRevision(tx, newRevision(models.RevisionRestore, nil, lm, lm.Version, actor)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		sp.logger.Error().Msg("SQL Commit Failed: " + err.Error())
+		return nil, err
+	}
+	return lm, nil
+}
+
+// PurgeLink permanently removes a link that was moved to the trash at or before deletedBefore
+func (sp *SQLProvider) PurgeLink(linkpath string, deletedBefore int64) error {
+	res, err := sp.db.Exec(sp.rebind("DELETE FROM links WHERE link_path = ? AND deleted_at <> 0 AND deleted_at <= ?"), linkpath, deletedBefore)
+	if err != nil {
+		sp.logger.Error().Msg("SQL Delete Failed: " + err.Error())
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// purgeTrashedTx removes a link in the trash at linkpath, if there is one, so the path can be claimed in the same transaction
+func (sp *SQLProvider) purgeTrashedTx(tx *sql.Tx, linkpath string) error {
+	if _, err := tx.Exec(sp.rebind("DELETE FROM links WHERE link_path = ? AND deleted_at <> 0"), linkpath); err != nil {
+		sp.logger.Error().Msg("SQL Delete Failed: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// lockLink reads a live or trashed link inside a transaction, locking the row on PostgreSQL
+func (sp *SQLProvider) lockLink(tx *sql.Tx, linkpath string, trashed bool) (*models.LinkModel, error) {
+	query := "SELECT " + linkColumns + " FROM links WHERE link_path = ? AND deleted_at = 0 AND alias_of = ''"
+	if trashed {
+		query = "SELECT " + linkColumns + " FROM links WHERE link_path = ? AND deleted_at <> 0"
+	}
+	if sp.dialect == DialectPostgres {
+		query += " FOR UPDATE"
+	}
+	return scanLink(tx.QueryRow(sp.rebind(query), linkpath))
+}
+
+// ListRevisions returns up to limit revisions of a link, newest first
+func (sp *SQLProvider) ListRevisions(linkpath string, before int64, limit int64) ([]*models.Revision, error) {
+	query := "SELECT " + revisionColumns + " FROM link_revisions WHERE link_path = ?"
+	args := []interface{}{linkpath}
+	if before != 0 {
+		query += " AND revision < ?"
+		args = append(args, before)
+	}
+	query += " ORDER BY revision DESC LIMIT ?"
+	args = append(args, revisionPageSize(limit))
+
+	rows, err := sp.db.Query(sp.rebind(query), args...)
+	if err != nil {
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	revs := make([]*models.Revision, 0)
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			sp.logger.Error().Msg("SQL Scan Failed: " + err.Error())
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+	if err := rows.Err(); err != nil {
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return nil, err
+	}
+	return revs, nil
+}
+
+// GetRevision fetches a single revision of a link
+func (sp *SQLProvider) GetRevision(linkpath string, revision int64) (*models.Revision, error) {
+	rev, err := scanRevision(sp.db.QueryRow(sp.rebind("SELECT "+revisionColumns+" FROM link_revisions WHERE link_path = ? AND revision = ?"), linkpath, revision))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, util.WrapError(ErrNotFound, err)
+		}
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return nil, err
+	}
+	return rev, nil
+}
+
+// insertRevision adds a revision to the history as part of the caller's transaction
+// The field changes and link snapshot are stored as JSON
+func (sp *SQLProvider) insertRevision(tx *sql.Tx, rev *models.Revision) error {
+	changes, err := json.Marshal(rev.Changes)
+	if err != nil {
+		return err
+	}
+	var link sql.NullString
+	if rev.Link != nil {
+		b, err := json.Marshal(rev.Link)
+		if err != nil {
+			return err
+		}
+		link = sql.NullString{String: string(b), Valid: true}
+	}
+
+	_, err = tx.Exec(sp.rebind("INSERT INTO link_revisions ("+revisionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)"),
+		rev.LinkPath,
+		rev.Revision,
+		rev.Operation,
+		rev.Timestamp,
+		rev.Actor,
+		string(changes),
+		link,
+	)
+	if err != nil && !isUniqueViolation(err) {
+		sp.logger.Error().Msg("SQL Insert Failed: " + err.Error())
+	}
+	return err
+}
+
+// revisionColumns is the column list matching scanRevision and insertRevision
+const revisionColumns = "link_path, revision, operation, changed_at, actor, changes, link"
+
+// scanRevision reads a row selected with revisionColumns into a revision
+func scanRevision(row rowScanner) (*models.Revision, error) {
+	rev := &models.Revision{}
+	var changes string
+	var link sql.NullString
+	if err := row.Scan(&rev.LinkPath, &rev.Revision, &rev.Operation, &rev.Timestamp, &rev.Actor, &changes, &link); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(changes), &rev.Changes); err != nil {
+		return nil, err
+	}
+	if link.Valid {
+		rev.Link = &models.LinkModel{}
+		if err := json.Unmarshal([]byte(link.String), rev.Link); err != nil {
+			return nil, err
+		}
+	}
+	return rev, nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanLink reads a row selected with linkColumns into a link model
+func scanLink(row rowScanner) (*models.LinkModel, error) {
+	lm := &models.LinkModel{}
+	err := row.Scan(
+		&lm.LinkPath,
+		&lm.CanonicalName,
+		&lm.TargetURL,
+		&lm.Enabled,
+		&lm.CreatedTime,
+		&lm.LastModified,
+		&lm.LastModifiedBy,
+		&lm.Version,
+		&lm.DeletedAt,
+		&lm.DeletedBy,
+		&lm.ActivateAt,
+		&lm.ExpireAt,
+		&lm.ExpiryBehaviour,
+		&lm.FallbackURL,
+		&lm.AliasOf,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return lm, nil
+}
+
+// linkPlaceholders matches the number of columns in linkColumns
+const linkPlaceholders = "?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?"
+
+// linkArgs returns the model fields in linkColumns order
+func linkArgs(lm *models.LinkModel) []interface{} {
+	return []interface{}{
+		lm.LinkPath,
+		lm.CanonicalName,
+		lm.TargetURL,
+		lm.Enabled,
+		lm.CreatedTime,
+		lm.LastModified,
+		lm.LastModifiedBy,
+		lm.Version,
+		lm.DeletedAt,
+		lm.DeletedBy,
+		lm.ActivateAt,
+		lm.ExpireAt,
+		lm.ExpiryBehaviour,
+		lm.FallbackURL,
+		lm.AliasOf,
+	}
+}
+
+// rebind rewrites ? placeholders for the dialect
+// Queries in this package never contain a literal ?
+func (sp *SQLProvider) rebind(query string) string {
+	if sp.dialect != DialectPostgres {
+		return query
+	}
+	return util.RebindPostgres(query)
+}
+
+// isUniqueViolation checks if the driver error is a unique or primary key constraint failure
+func isUniqueViolation(err error) bool {
+	switch e := err.(type) {
+	case *pq.Error:
+		return e.Code == "23505"
+	case *sqlite.Error:
+		return e.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || e.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/regalias/atlas-api/models"
+	"github.com/regalias/atlas-api/util"
+)
+
+// CreateAlias adds an alias for a live link
+// The link is locked while its aliases are counted and the alias inserted, and the primary key keeps alias and link paths apart
+func (sp *SQLProvider) CreateAlias(alias *models.Alias, purgeTrashed bool) error {
+	return sp.inTx(func(tx *sql.Tx) error {
+		if _, err := sp.lockLive(tx, alias.LinkPath, AnyVersion); err != nil {
+			return err
+		}
+		if purgeTrashed {
+			if err := sp.purgeTrashedTx(tx, alias.AliasPath); err != nil {
+				return err
+			}
+		}
+		count, err := sp.aliasCountTx(tx, alias.LinkPath)
+		if err != nil {
+			return err
+		}
+		if count >= MaxAliases {
+			return ErrTooManyAliases
+		}
+		if _, err := tx.Exec(sp.rebind("INSERT INTO links ("+linkColumns+") VALUES ("+linkPlaceholders+")"), linkArgs(aliasRow(alias))...); err != nil {
+			if isUniqueViolation(err) {
+				sp.logger.Debug().Msg("SQL Insert Failed: " + err.Error())
+				return util.WrapError(ErrAlreadyExists, err)
+			}
+			sp.logger.Error().Msg("SQL Insert Failed: " + err.Error())
+			return err
+		}
+		return nil
+	})
+}
+
+// GetAlias fetches an alias by its path
+func (sp *SQLProvider) GetAlias(aliaspath string) (*models.Alias, error) {
+	lm, err := scanLink(sp.db.QueryRow(sp.rebind("SELECT "+linkColumns+" FROM links WHERE link_path = ? AND alias_of <> ''"), aliaspath))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, util.WrapError(ErrNotFound, err)
+		}
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return nil, err
+	}
+	return aliasFromRow(lm), nil
+}
+
+// ListAliases returns every alias of a link in AliasPath order
+func (sp *SQLProvider) ListAliases(linkpath string) ([]*models.Alias, error) {
+	return sp.queryAliases("SELECT "+linkColumns+" FROM links WHERE alias_of = ? ORDER BY link_path", linkpath)
+}
+
+// ListAllAliases returns every alias of every link in AliasPath order
+func (sp *SQLProvider) ListAllAliases() ([]*models.Alias, error) {
+	return sp.queryAliases("SELECT " + linkColumns + " FROM links WHERE alias_of <> '' ORDER BY link_path")
+}
+
+// queryAliases runs a query selecting alias rows
+func (sp *SQLProvider) queryAliases(query string, args ...interface{}) ([]*models.Alias, error) {
+	rows, err := sp.db.Query(sp.rebind(query), args...)
+	if err != nil {
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := make([]*models.Alias, 0)
+	for rows.Next() {
+		lm, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, aliasFromRow(lm))
+	}
+	return aliases, rows.Err()
+}
+
+// DeleteAlias removes an alias
+func (sp *SQLProvider) DeleteAlias(aliaspath string) error {
+	res, err := sp.db.Exec(sp.rebind("DELETE FROM links WHERE link_path = ? AND alias_of <> ''"), aliaspath)
+	if err != nil {
+		sp.logger.Error().Msg("SQL Delete Failed: " + err.Error())
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// aliasCountTx counts the aliases of the given links
+func (sp *SQLProvider) aliasCountTx(tx *sql.Tx, linkpaths ...string) (int, error) {
+	args := make([]interface{}, len(linkpaths))
+	for i, p := range linkpaths {
+		args[i] = p
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(linkpaths)), ", ")
+
+	var count int
+	if err := tx.QueryRow(sp.rebind("SELECT COUNT(*) FROM links WHERE alias_of IN ("+placeholders+")"), args...).Scan(&count); err != nil {
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return 0, err
+	}
+	return count, nil
+}
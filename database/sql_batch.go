@@ -0,0 +1,149 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/regalias/atlas-api/models"
+)
+
+// sqlBatchSize bounds the links handled per statement or transaction
+// It keeps IN lists under SQLite's default limit of 999 bound parameters
+const sqlBatchSize = 500
+
+// BatchGetLinks fetches the links stored under the given paths, including links in the trash
+func (sp *SQLProvider) BatchGetLinks(linkpaths []string) (map[string]*models.LinkModel, error) {
+	found := make(map[string]*models.LinkModel, len(linkpaths))
+	for start := 0; start < len(linkpaths); start += sqlBatchSize {
+		end := start + sqlBatchSize
+		if end > len(linkpaths) {
+			end = len(linkpaths)
+		}
+		chunk := linkpaths[start:end]
+
+		args := make([]interface{}, len(chunk))
+		for i, p := range chunk {
+			args[i] = p
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ")
+
+		rows, err := sp.db.Query(sp.rebind("SELECT "+linkColumns+" FROM links WHERE link_path IN ("+placeholders+")"), args...)
+		if err != nil {
+			sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+			return nil, err
+		}
+		for rows.Next() {
+			lm, err := scanLink(rows)
+			if err != nil {
+				rows.Close()
+				sp.logger.Error().Msg("SQL Scan Failed: " + err.Error())
+				return nil, err
+			}
+			found[lm.LinkPath] = lm
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// BatchPutLinks creates or replaces links, recording a revision for each
+// Links are written in transactions of up to sqlBatchSize, a failed transaction fails all of its links
+// Links changed since their Previous was read are left out of their transaction and reported as failed
+func (sp *SQLProvider) BatchPutLinks(puts []*BatchPut) error {
+	var failed []string
+	var lastErr error
+	for start := 0; start < len(puts); start += sqlBatchSize {
+		end := start + sqlBatchSize
+		if end > len(puts) {
+			end = len(puts)
+		}
+		chunk := puts[start:end]
+
+		changed, err := sp.putChunk(chunk)
+		if err != nil {
+			sp.logger.Error().Msg("SQL Batch Write Failed: " + err.Error())
+			for _, put := range chunk {
+				failed = append(failed, put.Link.LinkPath)
+			}
+			lastErr = err
+			continue
+		}
+		if len(changed) > 0 {
+			failed = append(failed, changed...)
+			lastErr = ErrVersionMismatch
+		}
+	}
+	if len(failed) > 0 {
+		return &BatchWriteError{Failed: failed, Err: lastErr}
+	}
+	return nil
+}
+
+// putChunk replaces each link and records its revision in a single transaction
+// Returns the paths of the links left out because they changed since their Previous was read
+func (sp *SQLProvider) putChunk(puts []*BatchPut) ([]string, error) {
+	versions := make([]int64, len(puts))
+	var changed []string
+	err := sp.inTx(func(tx *sql.Tx) error {
+		for i, put := range puts {
+			lm := *put.Link
+
+			stored, err := sp.lockStored(tx, lm.LinkPath)
+			if err != nil {
+				return err
+			}
+			if !batchUnchanged(put, stored) {
+				changed = append(changed, lm.LinkPath)
+				continue
+			}
+			last, err := sp.lastRevisionTx(tx, lm.LinkPath)
+			if err != nil {
+				return err
+			}
+			lm.Version = batchVersion(stored, last)
+			versions[i] = lm.Version
+
+			if _, err := tx.Exec(sp.rebind("DELETE FROM links WHERE link_path = ?"), lm.LinkPath); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(sp.rebind("INSERT INTO links ("+linkColumns+") VALUES ("+linkPlaceholders+")"), linkArgs(&lm)...); err != nil {
+				return err
+			}
+			if err := sp.insertRevision(tx, batchRevision(put, lm.Version)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, put := range puts {
+		if versions[i] != 0 {
+			put.Link.Version = versions[i]
+		}
+	}
+	return changed, nil
+}
+
+// lockStored reads whatever is stored at the path for a batch put, or nil if nothing is
+func (sp *SQLProvider) lockStored(tx *sql.Tx, linkpath string) (*models.LinkModel, error) {
+	query := "SELECT " + linkColumns + " FROM links WHERE link_path = ?"
+	if sp.dialect == DialectPostgres {
+		query += " FOR UPDATE"
+	}
+	stored, err := scanLink(tx.QueryRow(sp.rebind(query), linkpath))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		sp.logger.Error().Msg("SQL Select Failed: " + err.Error())
+		return nil, err
+	}
+	return stored, nil
+}
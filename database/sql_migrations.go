@@ -0,0 +1,131 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// migration is a numbered schema change, applied once in version order
+type migration struct {
+	version    int
+	statements []string
+}
+
+// migrations must only ever be appended to, applied migrations are never re-run
+// The statements are written to work unchanged on both PostgreSQL and SQLite
+var migrations = []migration{
+	{
+		version: 1,
+		statements: []string{
+			`CREATE TABLE links (
+				link_path        VARCHAR(50) PRIMARY KEY,
+				canonical_name   VARCHAR(50) NOT NULL,
+				target_url       VARCHAR(500) NOT NULL,
+				enabled          BOOLEAN NOT NULL,
+				created_time     BIGINT NOT NULL,
+				last_modified    BIGINT NOT NULL,
+				last_modified_by VARCHAR(255) NOT NULL
+			)`,
+		},
+	},
+	{
+		version: 2,
+		statements: []string{
+			`ALTER TABLE links ADD COLUMN version BIGINT NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 3,
+		statements: []string{
+			`CREATE TABLE link_revisions (
+				link_path  VARCHAR(50) NOT NULL,
+				revision   BIGINT NOT NULL,
+				operation  VARCHAR(10) NOT NULL,
+				changed_at BIGINT NOT NULL,
+				actor      VARCHAR(255) NOT NULL,
+				changes    TEXT NOT NULL,
+				link       TEXT,
+				PRIMARY KEY (link_path, revision)
+			)`,
+		},
+	},
+	{
+		version: 4,
+		statements: []string{
+			`ALTER TABLE links ADD COLUMN deleted_at BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE links ADD COLUMN deleted_by VARCHAR(255) NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 5,
+		statements: []string{
+			`ALTER TABLE links ADD COLUMN activate_at BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE links ADD COLUMN expire_at BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE links ADD COLUMN expiry_behaviour VARCHAR(10) NOT NULL DEFAULT ''`,
+			`ALTER TABLE links ADD COLUMN fallback_url VARCHAR(500) NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 6,
+		statements: []string{
+			`ALTER TABLE links ADD COLUMN alias_of VARCHAR(255) NOT NULL DEFAULT ''`,
+			`CREATE INDEX links_alias_of ON links (alias_of)`,
+		},
+	},
+}
+
+// migrate applies every migration newer than the recorded schema version
+// Each migration runs in its own transaction together with its version record
+func (sp *SQLProvider) migrate() error {
+	if _, err := sp.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`); err != nil {
+		sp.logger.Error().Msg("SQL Migration Failed: " + err.Error())
+		return err
+	}
+
+	for _, m := range migrations {
+		if err := sp.applyMigration(m); err != nil {
+			sp.logger.Error().Msg("SQL Migration " + strconv.Itoa(m.version) + " Failed: " + err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+func (sp *SQLProvider) applyMigration(m migration) error {
+	tx, err := sp.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if sp.dialect == DialectPostgres {
+		// Serialise migrations between instances starting at the same time
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock(7353021)"); err != nil {
+			return err
+		}
+	}
+
+	var applied int
+	err = tx.QueryRow(sp.rebind("SELECT version FROM schema_migrations WHERE version = ?"), m.version).Scan(&applied)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	sp.logger.Info().Msg("Applying schema migration " + strconv.Itoa(m.version))
+	for _, stmt := range m.statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(sp.rebind("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"), m.version, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"strconv"
+
+	"github.com/regalias/atlas-api/models"
+)
+
+// Operations accepted by TransactLinks
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+	OpTrash  = "trash"
+)
+
+// MaxTransactOps is the most operations TransactLinks accepts in one call
+// DynamoDB transactions hold at most 25 items and every operation also writes a revision
+const MaxTransactOps = 12
+
+// LinkOp is a single write applied by TransactLinks
+// Creates and updates use the whole Link, following the same rules as CreateLink and UpdateLink
+// Deletes and trashes only use its LinkPath, Version and LastModifiedBy as the actor responsible
+type LinkOp struct {
+	Op   string
+	Link *models.LinkModel
+	// PurgeTrashed lets a create take the path of a link in the trash, which is permanently removed
+	// in the same transaction as if by PurgeLink
+	PurgeTrashed bool
+}
+
+// TransactError reports the operation that stopped a transaction, nothing in the transaction was written
+// Err is the error the single link method would have returned for that operation
+type TransactError struct {
+	Index int
+	Err   error
+}
+
+func (e *TransactError) Error() string {
+	return "operation " + strconv.Itoa(e.Index) + ": " + e.Err.Error()
+}
+
+// Unwrap returns the error of the failed operation
+func (e *TransactError) Unwrap() error {
+	return e.Err
+}
+
+// checkTransaction rejects empty or oversized transactions, unknown operations and repeated paths
+func checkTransaction(ops []*LinkOp) error {
+	if len(ops) == 0 || len(ops) > MaxTransactOps {
+		return ErrInvalidTransaction
+	}
+	seen := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		if op.Link == nil || seen[op.Link.LinkPath] {
+			return ErrInvalidTransaction
+		}
+		switch op.Op {
+		case OpCreate, OpUpdate, OpDelete, OpTrash:
+		default:
+			return ErrInvalidTransaction
+		}
+		seen[op.Link.LinkPath] = true
+	}
+	return nil
+}
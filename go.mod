@@ -1,16 +1,46 @@
 module github.com/regalias/atlas-api
 
-go 1.14
+go 1.17
 
 require (
+	github.com/BurntSushi/toml v0.3.0
 	github.com/allegro/bigcache v1.2.1
 	github.com/aws/aws-sdk-go v1.29.22
 	github.com/go-playground/validator/v10 v10.2.0
-	github.com/go-redis/redis/v7 v7.2.0
-	github.com/jmespath/go-jmespath v0.0.0-20200310193758-2437e8417af5 // indirect
+	github.com/go-redis/redis/v7 v7.4.1
+	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/justinas/alice v1.2.0
+	github.com/lib/pq v1.3.0
 	// github.com/rs/xid v1.2.1
 	github.com/rs/zerolog v1.18.0
-	golang.org/x/net v0.0.0-20200301022130-244492dfa37a // indirect
+	gopkg.in/yaml.v2 v2.4.0
+	modernc.org/sqlite v1.20.4
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.0 // indirect
+	github.com/go-playground/locales v0.13.0 // indirect
+	github.com/go-playground/universal-translator v0.17.0 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/jmespath/go-jmespath v0.0.0-20200310193758-2437e8417af5 // indirect
+	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
+	github.com/leodido/go-urn v1.2.0 // indirect
+	github.com/mattn/go-isatty v0.0.16 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20200410134404-eec4a21b6bb0 // indirect
+	github.com/rs/xid v1.2.1 // indirect
+	github.com/zenazn/goji v0.9.0 // indirect
+	golang.org/x/mod v0.3.0 // indirect
+	golang.org/x/sys v0.0.0-20220811171246-fbc7d0a398ab // indirect
+	golang.org/x/tools v0.0.0-20201124115921-2c860bdd6e78 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	lukechampine.com/uint128 v1.2.0 // indirect
+	modernc.org/cc/v3 v3.40.0 // indirect
+	modernc.org/ccgo/v3 v3.16.13 // indirect
+	modernc.org/libc v1.22.2 // indirect
+	modernc.org/mathutil v1.5.0 // indirect
+	modernc.org/memory v1.4.0 // indirect
+	modernc.org/opt v0.1.3 // indirect
+	modernc.org/strutil v1.1.3 // indirect
+	modernc.org/token v1.0.1 // indirect
 )
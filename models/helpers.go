@@ -1,9 +1,95 @@
-package models
-
-// CheckLinkModelsAreEqual compares user controllable properties inside the model
-func CheckLinkModelsAreEqual(lm1 *LinkModel, lm2 *LinkModel) bool {
-	if (lm1.CanonicalName != lm2.CanonicalName) || (lm1.LinkPath != lm2.LinkPath) || (lm1.TargetURL != lm2.TargetURL || (lm1.Enabled != lm2.Enabled)) {
-		return false
-	}
-	return true
-}
+package models
+
+import "strconv"
+
+// CheckLinkModelsAreEqual compares user controllable properties inside the model
+func CheckLinkModelsAreEqual(lm1 *LinkModel, lm2 *LinkModel) bool {
+	if (lm1.CanonicalName != lm2.CanonicalName) || (lm1.LinkPath != lm2.LinkPath) || (lm1.TargetURL != lm2.TargetURL || (lm1.Enabled != lm2.Enabled)) {
+		return false
+	}
+	if (lm1.ActivateAt != lm2.ActivateAt) || (lm1.ExpireAt != lm2.ExpireAt) || (lm1.ExpiryBehaviour != lm2.ExpiryBehaviour) || (lm1.FallbackURL != lm2.FallbackURL) {
+		return false
+	}
+	return true
+}
+
+// linkFields lists the user controllable properties compared by CheckLinkModelsAreEqual in display form
+var linkFields = []struct {
+	name  string
+	value func(lm *LinkModel) string
+}{
+	{"LinkPath", func(lm *LinkModel) string { return lm.LinkPath }},
+	{"CanonicalName", func(lm *LinkModel) string { return lm.CanonicalName }},
+	{"TargetURL", func(lm *LinkModel) string { return lm.TargetURL }},
+	{"Enabled", func(lm *LinkModel) string { return strconv.FormatBool(lm.Enabled) }},
+	{"ActivateAt", func(lm *LinkModel) string { return formatTimestamp(lm.ActivateAt) }},
+	{"ExpireAt", func(lm *LinkModel) string { return formatTimestamp(lm.ExpireAt) }},
+	{"ExpiryBehaviour", func(lm *LinkModel) string { return lm.ExpiryBehaviour }},
+	{"FallbackURL", func(lm *LinkModel) string { return lm.FallbackURL }},
+}
+
+// formatTimestamp shows unset timestamps as empty rather than 0
+func formatTimestamp(t int64) string {
+	if t == 0 {
+		return ""
+	}
+	return strconv.FormatInt(t, 10)
+}
+
+// DiffLinkModels lists the user controllable properties that differ between two models
+// A nil model stands for a link that does not exist, so every field of the other model is reported
+func DiffLinkModels(old *LinkModel, new *LinkModel) []FieldChange {
+	changes := []FieldChange{}
+	for _, f := range linkFields {
+		var o, n string
+		if old != nil {
+			o = f.value(old)
+		}
+		if new != nil {
+			n = f.value(new)
+		}
+		if o != n {
+			changes = append(changes, FieldChange{Field: f.name, Old: o, New: n})
+		}
+	}
+	return changes
+}
+
+// ResolveLink works out where a link redirects to at the given unix time
+// Returns false if the link should not resolve, because it is disabled, not yet active, or expired without a fallback
+func ResolveLink(lm *LinkModel, now int64) (string, bool) {
+	if !lm.Enabled {
+		return "", false
+	}
+	if lm.ActivateAt != 0 && now < lm.ActivateAt {
+		return "", false
+	}
+	if lm.ExpireAt != 0 && now >= lm.ExpireAt {
+		if lm.ExpiryBehaviour == ExpiryFallback && lm.FallbackURL != "" {
+			return lm.FallbackURL, true
+		}
+		return "", false
+	}
+	return lm.TargetURL, true
+}
+
+// NextScheduleChange returns the first activation or expiry time after now, or 0 if there is none
+func NextScheduleChange(lm *LinkModel, now int64) int64 {
+	var next int64
+	for _, t := range []int64{lm.ActivateAt, lm.ExpireAt} {
+		if t > now && (next == 0 || t < next) {
+			next = t
+		}
+	}
+	return next
+}
+
+// ScheduleChangedBetween checks if the link was activated or expired after from and up to and including to
+func ScheduleChangedBetween(lm *LinkModel, from int64, to int64) bool {
+	for _, t := range []int64{lm.ActivateAt, lm.ExpireAt} {
+		if t != 0 && t > from && t <= to {
+			return true
+		}
+	}
+	return false
+}
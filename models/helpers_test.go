@@ -0,0 +1,181 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveLink(t *testing.T) {
+	const now = 1000
+	tests := []struct {
+		name   string
+		link   LinkModel
+		want   string
+		active bool
+	}{
+		{
+			name:   "enabled",
+			link:   LinkModel{Enabled: true, TargetURL: "https://example.com"},
+			want:   "https://example.com",
+			active: true,
+		},
+		{
+			name: "disabled",
+			link: LinkModel{TargetURL: "https://example.com"},
+		},
+		{
+			name: "not yet active",
+			link: LinkModel{Enabled: true, TargetURL: "https://example.com", ActivateAt: now + 1},
+		},
+		{
+			name:   "activated now",
+			link:   LinkModel{Enabled: true, TargetURL: "https://example.com", ActivateAt: now},
+			want:   "https://example.com",
+			active: true,
+		},
+		{
+			name:   "expires later",
+			link:   LinkModel{Enabled: true, TargetURL: "https://example.com", ExpireAt: now + 1},
+			want:   "https://example.com",
+			active: true,
+		},
+		{
+			name: "expired now",
+			link: LinkModel{Enabled: true, TargetURL: "https://example.com", ExpireAt: now},
+		},
+		{
+			name: "expired with fallback",
+			link: LinkModel{Enabled: true, TargetURL: "https://example.com", ExpireAt: now,
+				ExpiryBehaviour: ExpiryFallback, FallbackURL: "https://example.com/gone"},
+			want:   "https://example.com/gone",
+			active: true,
+		},
+		{
+			name: "expired with fallback behaviour but no URL",
+			link: LinkModel{Enabled: true, TargetURL: "https://example.com", ExpireAt: now,
+				ExpiryBehaviour: ExpiryFallback},
+		},
+		{
+			name: "disabled with fallback",
+			link: LinkModel{TargetURL: "https://example.com", ExpireAt: now,
+				ExpiryBehaviour: ExpiryFallback, FallbackURL: "https://example.com/gone"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, active := ResolveLink(&tt.link, now)
+			if got != tt.want || active != tt.active {
+				t.Fatalf("got (%q, %v), want (%q, %v)", got, active, tt.want, tt.active)
+			}
+		})
+	}
+}
+
+func TestScheduleWindows(t *testing.T) {
+	const now = 1000
+	tests := []struct {
+		name       string
+		activateAt int64
+		expireAt   int64
+		// next is the NextScheduleChange after now
+		next int64
+		// changed is the ScheduleChangedBetween result for the window from 900 to now
+		changed bool
+	}{
+		{name: "unscheduled"},
+		{name: "activates later", activateAt: 1100, next: 1100},
+		{name: "activated in the window", activateAt: 950, changed: true},
+		{name: "activated now", activateAt: now, changed: true},
+		{name: "activated at the window start", activateAt: 900},
+		{name: "activated before the window", activateAt: 500},
+		{name: "active window ahead", activateAt: 1100, expireAt: 1200, next: 1100},
+		{name: "inside the active window", activateAt: 500, expireAt: 1200, next: 1200},
+		{name: "expired in the window", activateAt: 500, expireAt: 990, changed: true},
+		{name: "expires later", expireAt: 1500, next: 1500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lm := &LinkModel{ActivateAt: tt.activateAt, ExpireAt: tt.expireAt}
+			if got := NextScheduleChange(lm, now); got != tt.next {
+				t.Errorf("NextScheduleChange = %d, want %d", got, tt.next)
+			}
+			if got := ScheduleChangedBetween(lm, 900, now); got != tt.changed {
+				t.Errorf("ScheduleChangedBetween = %v, want %v", got, tt.changed)
+			}
+		})
+	}
+}
+
+func TestDiffLinkModels(t *testing.T) {
+	base := LinkModel{
+		LinkPath:      "docs",
+		CanonicalName: "Docs",
+		TargetURL:     "https://example.com",
+		Enabled:       true,
+		// Audit fields are not user controllable, so never reported
+		Version:      3,
+		LastModified: 100,
+	}
+	changed := base
+	changed.TargetURL = "https://example.com/new"
+	changed.ExpireAt = 2000
+	changed.Version = 4
+	changed.LastModified = 200
+
+	tests := []struct {
+		name string
+		old  *LinkModel
+		new  *LinkModel
+		want []FieldChange
+	}{
+		{
+			name: "unchanged",
+			old:  &base,
+			new:  &base,
+			want: []FieldChange{},
+		},
+		{
+			name: "updated",
+			old:  &base,
+			new:  &changed,
+			want: []FieldChange{
+				{Field: "TargetURL", Old: "https://example.com", New: "https://example.com/new"},
+				{Field: "ExpireAt", Old: "", New: "2000"},
+			},
+		},
+		{
+			name: "created",
+			new:  &base,
+			want: []FieldChange{
+				{Field: "LinkPath", New: "docs"},
+				{Field: "CanonicalName", New: "Docs"},
+				{Field: "TargetURL", New: "https://example.com"},
+				{Field: "Enabled", Old: "", New: "true"},
+			},
+		},
+		{
+			name: "deleted",
+			old:  &base,
+			want: []FieldChange{
+				{Field: "LinkPath", Old: "docs"},
+				{Field: "CanonicalName", Old: "Docs"},
+				{Field: "TargetURL", Old: "https://example.com"},
+				{Field: "Enabled", Old: "true", New: ""},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffLinkModels(tt.old, tt.new)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			if tt.old == nil || tt.new == nil {
+				return
+			}
+			if equal := CheckLinkModelsAreEqual(tt.old, tt.new); equal != (len(got) == 0) {
+				t.Fatalf("CheckLinkModelsAreEqual = %v with %d changes", equal, len(got))
+			}
+		})
+	}
+}
@@ -1,19 +1,83 @@
-package models
-
-type genericResponse struct {
-	Error   string `json:"error"`
-	Details string `json:"details"`
-}
-
-// LinkModel is a model of saved links
-type LinkModel struct {
-	// LinkID        string `json:"LinkID"`
-	LinkPath      string `json:"LinkPath"` // LinkPath is now the unique identifier
-	CanonicalName string `json:"CanonicalName"`
-	TargetURL     string `json:"TargetURL"`
-	Enabled       bool   `json:"Enabled"`
-	// Audit info
-	CreatedTime    int64  `json:"CreatedTime"`
-	LastModified   int64  `json:"LastModified"`
-	LastModifiedBy string `json:"LastModifiedBy"`
-}
+package models
+
+type genericResponse struct {
+	Error   string `json:"error"`
+	Details string `json:"details"`
+}
+
+// LinkModel is a model of saved links
+type LinkModel struct {
+	// LinkID        string `json:"LinkID"`
+	LinkPath      string `json:"LinkPath"` // LinkPath is now the unique identifier
+	CanonicalName string `json:"CanonicalName"`
+	TargetURL     string `json:"TargetURL"`
+	Enabled       bool   `json:"Enabled"`
+	// Optional activation window as unix timestamps, zero means unbounded
+	ActivateAt int64 `json:"ActivateAt,omitempty"`
+	ExpireAt   int64 `json:"ExpireAt,omitempty"`
+	// ExpiryBehaviour decides what an expired link does, see ExpiryNotFound and ExpiryFallback
+	ExpiryBehaviour string `json:"ExpiryBehaviour,omitempty"`
+	FallbackURL     string `json:"FallbackURL,omitempty"`
+	// Version is incremented on every update, links created before versioning are version 0
+	Version int64 `json:"Version"`
+	// Audit info
+	CreatedTime    int64  `json:"CreatedTime"`
+	LastModified   int64  `json:"LastModified"`
+	LastModifiedBy string `json:"LastModifiedBy"`
+	// Set when the link is in the trash, zero for live links
+	DeletedAt int64  `json:"DeletedAt,omitempty"`
+	DeletedBy string `json:"DeletedBy,omitempty"`
+	// AliasOf is only set on stored rows that hold an Alias rather than a link
+	// Aliases share the link namespace so a path can't be both
+	AliasOf string `json:"AliasOf,omitempty"`
+	// AliasCount is only kept on DynamoDB rows, where the aliases of a link can't be counted inside a transaction
+	// It is never exposed through the API
+	AliasCount int64 `json:"-" dynamodbav:"AliasCount,omitempty"`
+}
+
+// Alias is an extra path that redirects wherever the link at LinkPath does
+type Alias struct {
+	AliasPath   string `json:"AliasPath"`
+	LinkPath    string `json:"LinkPath"`
+	CreatedTime int64  `json:"CreatedTime"`
+	CreatedBy   string `json:"CreatedBy"`
+}
+
+// Expiry behaviours
+const (
+	// ExpiryNotFound makes expired links respond as if they did not exist, this is the default
+	ExpiryNotFound = "notfound"
+	// ExpiryFallback redirects expired links to their FallbackURL
+	ExpiryFallback = "fallback"
+)
+
+// Revision operations
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionTrash   = "trash"
+	RevisionRestore = "restore"
+	// RevisionRename is recorded on the new path, and on the old path when the rename removed it
+	RevisionRename = "rename"
+)
+
+// Revision is an immutable record of a single change to a link
+// Revision numbers follow the link's Version, and carry on from the last revision if a deleted path is re-created
+type Revision struct {
+	LinkPath  string        `json:"LinkPath"`
+	Revision  int64         `json:"Revision"`
+	Operation string        `json:"Operation"`
+	Timestamp int64         `json:"Timestamp"`
+	Actor     string        `json:"Actor"`
+	Changes   []FieldChange `json:"Changes"`
+	// Link is the state of the link after the change, nil for deletions and moves to the trash
+	Link *LinkModel `json:"Link"`
+}
+
+// FieldChange is the old and new value of a single user controllable field
+type FieldChange struct {
+	Field string `json:"Field"`
+	Old   string `json:"Old"`
+	New   string `json:"New"`
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Placeholders understood in the TargetURL of a template link, besides the numbered {1} to {9} segments
+const (
+	// PlaceholderRest is replaced by every trailing path segment of the request, joined with slashes
+	PlaceholderRest = "{*}"
+	// PlaceholderQuery is replaced by the query string of the request
+	PlaceholderQuery = "{query}"
+)
+
+var (
+	// ErrInvalidTemplate is returned when a target URL has unknown or misplaced placeholders
+	ErrInvalidTemplate = errors.New("Target URL is not a valid template")
+	// ErrTemplateMismatch is returned when a request's trailing path segments don't fit a template
+	ErrTemplateMismatch = errors.New("Path does not match the link template")
+	// ErrInvalidSegment is returned for empty, . or .. trailing path segments
+	ErrInvalidSegment = errors.New("Path contains an invalid segment")
+)
+
+var placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// ValidateTemplate checks that every placeholder in a target URL is known and sits in its path or query
+// Target URLs without placeholders are always valid
+func ValidateTemplate(target string) error {
+	locs := placeholderPattern.FindAllStringIndex(target, -1)
+	if len(locs) == 0 {
+		return nil
+	}
+	if strings.ContainsAny(placeholderPattern.ReplaceAllString(target, ""), "{}") {
+		return ErrInvalidTemplate
+	}
+
+	// Placeholders may not change the scheme or host, so the path must have started before the first one
+	scheme := strings.Index(target, "://")
+	if scheme < 0 {
+		return ErrInvalidTemplate
+	}
+	pathStart := strings.IndexAny(target[scheme+3:], "/?")
+	if pathStart < 0 || scheme+3+pathStart >= locs[0][0] {
+		return ErrInvalidTemplate
+	}
+	fragment := strings.Index(target, "#")
+	query := queryStart(target)
+	for _, loc := range locs {
+		if fragment >= 0 && loc[0] > fragment {
+			return ErrInvalidTemplate
+		}
+		switch p := target[loc[0]:loc[1]]; p {
+		case PlaceholderRest:
+		case PlaceholderQuery:
+			if query < 0 || loc[0] < query {
+				return ErrInvalidTemplate
+			}
+		default:
+			if _, ok := placeholderIndex(p); !ok {
+				return ErrInvalidTemplate
+			}
+		}
+	}
+
+	u, err := url.Parse(placeholderPattern.ReplaceAllString(target, "x"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidTemplate
+	}
+	return nil
+}
+
+// ExpandTemplate fills in the placeholders of a target URL from the trailing path segments and query of a request
+// Segments must be unescaped, and are escaped again for the part of the URL they land in
+// A target without placeholders only matches a request without trailing segments, and is returned as is
+func ExpandTemplate(target string, segments []string, query url.Values) (string, error) {
+	// Tolerate a single trailing slash
+	if n := len(segments); n > 0 && segments[n-1] == "" {
+		segments = segments[:n-1]
+	}
+	for _, seg := range segments {
+		if seg == "" || seg == "." || seg == ".." {
+			return "", ErrInvalidSegment
+		}
+	}
+
+	locs := placeholderPattern.FindAllStringIndex(target, -1)
+	highest, rest := 0, false
+	for _, loc := range locs {
+		if i, ok := placeholderIndex(target[loc[0]:loc[1]]); ok && i > highest {
+			highest = i
+		}
+		rest = rest || target[loc[0]:loc[1]] == PlaceholderRest
+	}
+	if len(segments) < highest || (len(segments) > highest && !rest) {
+		return "", ErrTemplateMismatch
+	}
+
+	qs := queryStart(target)
+	var b strings.Builder
+	last := 0
+	for _, loc := range locs {
+		b.WriteString(target[last:loc[0]])
+		last = loc[1]
+
+		inQuery := qs >= 0 && loc[0] > qs
+		escape := url.PathEscape
+		if inQuery {
+			escape = url.QueryEscape
+		}
+		switch p := target[loc[0]:loc[1]]; p {
+		case PlaceholderRest:
+			if inQuery {
+				b.WriteString(escape(strings.Join(segments, "/")))
+				continue
+			}
+			for i, seg := range segments {
+				if i > 0 {
+					b.WriteByte('/')
+				}
+				b.WriteString(escape(seg))
+			}
+		case PlaceholderQuery:
+			b.WriteString(query.Encode())
+		default:
+			// Braces that aren't placeholders can only come from links saved before templates existed
+			if i, ok := placeholderIndex(p); ok {
+				b.WriteString(escape(segments[i-1]))
+			} else {
+				b.WriteString(p)
+			}
+		}
+	}
+	b.WriteString(target[last:])
+	return b.String(), nil
+}
+
+// placeholderIndex parses a numbered placeholder into its 1 based segment index
+func placeholderIndex(p string) (int, bool) {
+	if len(p) != 3 {
+		return 0, false
+	}
+	i, err := strconv.Atoi(p[1:2])
+	if err != nil || i < 1 {
+		return 0, false
+	}
+	return i, true
+}
+
+// queryStart finds the ? starting the query of a target URL, or -1 if it has none
+func queryStart(target string) int {
+	q := strings.Index(target, "?")
+	if f := strings.Index(target, "#"); f >= 0 && q > f {
+		return -1
+	}
+	return q
+}
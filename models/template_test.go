@@ -0,0 +1,153 @@
+package models
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		target string
+		valid  bool
+	}{
+		{target: "https://example.com/docs", valid: true},
+		{target: "https://example.com/{1}", valid: true},
+		{target: "https://example.com/{1}/{2}/{*}", valid: true},
+		{target: "https://example.com/search?q={1}&{query}", valid: true},
+		{target: "https://example.com/?{query}", valid: true},
+		{target: "https://example.com?q={*}", valid: true},
+		// Unknown or malformed placeholders
+		{target: "https://example.com/{0}", valid: false},
+		{target: "https://example.com/{10}", valid: false},
+		{target: "https://example.com/{name}", valid: false},
+		{target: "https://example.com/{1}/{2", valid: false},
+		{target: "https://example.com/{1}/2}", valid: false},
+		// Stray braces without any placeholder are kept from links saved before templates
+		{target: "https://example.com/{1", valid: true},
+		{target: "https://example.com/{{1}}", valid: false},
+		// Placeholders may not change the scheme or host
+		{target: "https://{1}.example.com/", valid: false},
+		{target: "https://example.com{1}", valid: false},
+		{target: "{1}://example.com/", valid: false},
+		{target: "example.com/{1}", valid: false},
+		{target: "ftp://example.com/{1}", valid: false},
+		// The query placeholder only fits in the query, and nothing fits in the fragment
+		{target: "https://example.com/{query}", valid: false},
+		{target: "https://example.com/docs#{1}", valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			err := ValidateTemplate(tt.target)
+			if tt.valid && err != nil {
+				t.Fatalf("got error %v, want valid", err)
+			}
+			if !tt.valid && err != ErrInvalidTemplate {
+				t.Fatalf("got error %v, want ErrInvalidTemplate", err)
+			}
+		})
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		segments []string
+		query    url.Values
+		want     string
+		err      error
+	}{
+		{
+			name:   "plain link",
+			target: "https://example.com/docs",
+			want:   "https://example.com/docs",
+		},
+		{
+			name:     "plain link with a trailing slash",
+			target:   "https://example.com/docs",
+			segments: []string{""},
+			want:     "https://example.com/docs",
+		},
+		{
+			name:     "plain link with segments",
+			target:   "https://example.com/docs",
+			segments: []string{"extra"},
+			err:      ErrTemplateMismatch,
+		},
+		{
+			name:     "numbered segments",
+			target:   "https://github.com/{1}/{2}/issues",
+			segments: []string{"regalias", "atlas-api"},
+			want:     "https://github.com/regalias/atlas-api/issues",
+		},
+		{
+			name:     "missing segment",
+			target:   "https://github.com/{1}/{2}/issues",
+			segments: []string{"regalias"},
+			err:      ErrTemplateMismatch,
+		},
+		{
+			name:     "extra segment",
+			target:   "https://github.com/{1}",
+			segments: []string{"regalias", "atlas-api"},
+			err:      ErrTemplateMismatch,
+		},
+		{
+			name:     "rest of the path",
+			target:   "https://example.com/{*}",
+			segments: []string{"a", "b c", "d"},
+			want:     "https://example.com/a/b%20c/d",
+		},
+		{
+			name:     "rest of the path includes numbered segments",
+			target:   "https://example.com/{1}/all/{*}",
+			segments: []string{"a", "b"},
+			want:     "https://example.com/a/all/a/b",
+		},
+		{
+			name:   "rest of the path when empty",
+			target: "https://example.com/files/{*}",
+			want:   "https://example.com/files/",
+		},
+		{
+			name:     "segments in the query",
+			target:   "https://example.com/search?q={1}&path={*}",
+			segments: []string{"a&b", "c/d"},
+			want:     "https://example.com/search?q=a%26b&path=a%26b%2Fc%2Fd",
+		},
+		{
+			name:   "query string",
+			target: "https://example.com/search?src=atlas&{query}",
+			query:  url.Values{"q": {"go"}, "page": {"2"}},
+			want:   "https://example.com/search?src=atlas&page=2&q=go",
+		},
+		{
+			name:     "dot segment",
+			target:   "https://example.com/{*}",
+			segments: []string{"a", ".."},
+			err:      ErrInvalidSegment,
+		},
+		{
+			name:     "empty segment",
+			target:   "https://example.com/{*}",
+			segments: []string{"a", "", "b"},
+			err:      ErrInvalidSegment,
+		},
+		{
+			name:   "braces saved before templates",
+			target: "https://example.com/{name}",
+			want:   "https://example.com/{name}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandTemplate(tt.target, tt.segments, tt.query)
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package util
+
+// Error pairs one of a package's sentinel errors with the underlying driver, AWS, Redis or bigcache error that caused it
+// Use errors.Is to check the sentinel and errors.As to inspect the cause
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Is reports whether the target is the sentinel this error represents
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the underlying cause
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WrapError tags an error with a sentinel
+func WrapError(kind error, cause error) error {
+	return &Error{Kind: kind, Err: cause}
+}